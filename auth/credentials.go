@@ -0,0 +1,186 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"triple-s/flags"
+	"triple-s/storage"
+)
+
+// Credential is one access key pair, from the credentials file or issued
+// to a user of the UserStore.
+type Credential struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	// User is the name of the profile section the key was read from, or
+	// of the user it was issued to.
+	User  string
+	Admin bool
+	// hash replaces SecretAccessKey for keys whose secret is not kept.
+	hash *SecretHash
+}
+
+// Store looks up credentials by access key ID in the credentials file and
+// then in the user store.
+type Store struct {
+	keys    map[string]Credential
+	users   *UserStore
+	enabled atomic.Bool
+}
+
+func (s *Store) Lookup(accessKeyID string) (Credential, bool) {
+	if credential, ok := s.keys[accessKeyID]; ok {
+		return credential, true
+	}
+	if s.users == nil {
+		return Credential{}, false
+	}
+	return s.users.lookup(accessKeyID)
+}
+
+// Enabled reports whether requests must be signed: there is a credentials
+// file, or the user store has had a user since the server started. The
+// user store is read again when it changed, so the first user created with
+// the users command turns authentication on for a running server. Deleting
+// every user does not turn it off again until a restart.
+func (s *Store) Enabled() bool {
+	if len(s.keys) > 0 || s.enabled.Load() {
+		return true
+	}
+	if s.users == nil || !s.users.hasUsers() {
+		return false
+	}
+	s.enabled.Store(true)
+	return true
+}
+
+// HasUser reports whether name is a profile of the credentials file.
+func (s *Store) HasUser(name string) bool {
+	for _, credential := range s.keys {
+		if credential.User == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Credentials holds the keys requests are checked against. It is set by
+// Init; see Enabled.
+var Credentials *Store
+
+// Enabled reports whether Credentials requires requests to be signed.
+func Enabled() bool {
+	return Credentials != nil && Credentials.Enabled()
+}
+
+// Users is the user store under the storage directory.
+var Users *UserStore
+
+// Init opens the user store and loads the file named by the -credentials
+// flag.
+func Init() error {
+	users, err := OpenUserStore(filepath.Join(flags.StorageDir, storage.SystemDir, usersFile))
+	if err != nil {
+		return err
+	}
+	Users = users
+	list, err := users.List()
+	if err != nil {
+		return err
+	}
+
+	store := &Store{keys: make(map[string]Credential), users: users}
+	if flags.CredentialsFile != "" {
+		if store, err = LoadCredentials(flags.CredentialsFile); err != nil {
+			return err
+		}
+		store.users = users
+		log.Printf("Loaded %d access keys from %s", len(store.keys), flags.CredentialsFile)
+	}
+	Credentials = store
+	if flags.CredentialsFile == "" && len(list) == 0 {
+		log.Println("No -credentials file given and no users, requests are not authenticated until a user is created")
+		return nil
+	}
+	log.Printf("%d users in %s", len(list), users.path)
+	return nil
+}
+
+// LoadCredentials reads a file in the format of the AWS shared credentials
+// file: one [profile] section per user with aws_access_key_id and
+// aws_secret_access_key entries. "admin = true" lets a profile use the
+// admin API. Lines starting with # or ; are comments.
+func LoadCredentials(path string) (*Store, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open credentials file: %w", err)
+	}
+	defer file.Close()
+
+	store := &Store{keys: make(map[string]Credential)}
+	var sections []Credential
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			sections = append(sections, Credential{User: strings.TrimSpace(line[1 : len(line)-1])})
+			continue
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok || len(sections) == 0 {
+			return nil, fmt.Errorf("%s:%d: expected a [section] or key = value", path, lineNumber)
+		}
+		current := &sections[len(sections)-1]
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "aws_access_key_id":
+			current.AccessKeyID = strings.TrimSpace(value)
+		case "aws_secret_access_key":
+			current.SecretAccessKey = strings.TrimSpace(value)
+		case "admin":
+			current.Admin = strings.EqualFold(strings.TrimSpace(value), "true")
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read credentials file: %w", err)
+	}
+
+	for _, credential := range sections {
+		if credential.AccessKeyID == "" || credential.SecretAccessKey == "" {
+			return nil, fmt.Errorf("%s: profile [%s] needs aws_access_key_id and aws_secret_access_key", path, credential.User)
+		}
+		if _, ok := store.keys[credential.AccessKeyID]; ok {
+			return nil, fmt.Errorf("%s: access key %s is listed twice", path, credential.AccessKeyID)
+		}
+		store.keys[credential.AccessKeyID] = credential
+	}
+	return store, nil
+}
+
+// Identity is who signed a request.
+type Identity struct {
+	AccessKeyID string
+	User        string
+	Admin       bool
+}
+
+type identityKey struct{}
+
+func WithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// IdentityFrom returns the identity stored by WithIdentity. It reports false
+// for anonymous requests and when authentication is off.
+func IdentityFrom(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"triple-s/flags"
+	"triple-s/storage"
+)
+
+func TestEnabledFollowsUsers(t *testing.T) {
+	previousDir, previousFile, previousCredentials, previousUsers := flags.StorageDir, flags.CredentialsFile, Credentials, Users
+	t.Cleanup(func() {
+		flags.StorageDir, flags.CredentialsFile, Credentials, Users = previousDir, previousFile, previousCredentials, previousUsers
+	})
+	flags.StorageDir, flags.CredentialsFile = t.TempDir(), ""
+	if err := os.MkdirAll(filepath.Join(flags.StorageDir, storage.SystemDir), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	if Credentials == nil || Enabled() {
+		t.Fatal("authentication is on without users")
+	}
+
+	// A second store stands in for the users command, which writes the
+	// same file from another process.
+	command, err := OpenUserStore(filepath.Join(flags.StorageDir, storage.SystemDir, usersFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := command.Create("admin", true); err != nil {
+		t.Fatal(err)
+	}
+	if !Enabled() {
+		t.Fatal("authentication is off after the first user was created")
+	}
+	if err := command.Delete("admin"); err != nil {
+		t.Fatal(err)
+	}
+	if !Enabled() {
+		t.Fatal("authentication is off after the last user was deleted")
+	}
+}
+
+func TestUsersFileMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), usersFile)
+	if err := os.WriteFile(path, []byte("[]"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenUserStore(path); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := info.Mode().Perm(); mode != 0o600 {
+		t.Errorf("users file has mode %o, want 600", mode)
+	}
+}
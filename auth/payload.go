@@ -0,0 +1,180 @@
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var errPayloadMismatch = &Error{Status: http.StatusBadRequest, Code: "XAmzContentSHA256Mismatch", Message: "The provided x-amz-content-sha256 header does not match what was computed"}
+
+// payloadReader hashes the body as it is read and fails the final read if
+// the result differs from the signed x-amz-content-sha256.
+type payloadReader struct {
+	io.ReadCloser
+	hash     hash.Hash
+	expected []byte
+}
+
+func (p *payloadReader) Read(b []byte) (int, error) {
+	n, err := p.ReadCloser.Read(b)
+	p.hash.Write(b[:n])
+	if err == io.EOF && !bytes.Equal(p.hash.Sum(nil), p.expected) {
+		return n, errPayloadMismatch
+	}
+	return n, err
+}
+
+var errMalformedChunk = &Error{Status: http.StatusBadRequest, Code: "IncompleteBody", Message: "The aws-chunked request body is malformed"}
+
+// chunkedReader decodes an aws-chunked body:
+//
+//	<hex size>[;chunk-signature=<sig>]\r\n<data>\r\n ... 0[;chunk-signature=<sig>]\r\n[trailers]\r\n
+//
+// With a seed signature every chunk's signature is checked against the
+// previous one, starting from the request's. Trailing headers are read
+// and dropped.
+type chunkedReader struct {
+	body      io.ReadCloser
+	r         *bufio.Reader
+	seed      *signature
+	key       []byte
+	previous  string
+	chunkSig  string
+	hash      hash.Hash
+	remaining int64
+	started   bool
+	err       error
+}
+
+func newChunkedReader(body io.ReadCloser, seed *signature, key []byte) *chunkedReader {
+	c := &chunkedReader{body: body, r: bufio.NewReader(body), seed: seed, key: key, hash: sha256.New()}
+	if seed != nil {
+		c.previous = seed.signature
+	}
+	return c
+}
+
+func (c *chunkedReader) Close() error {
+	return c.body.Close()
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.remaining == 0 {
+		if err := c.nextChunk(); err != nil {
+			c.err = err
+			return 0, err
+		}
+		if c.err != nil {
+			return 0, c.err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	c.hash.Write(p[:n])
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// nextChunk finishes the current chunk and reads the header of the next.
+// After the final, empty chunk c.err is io.EOF.
+func (c *chunkedReader) nextChunk() error {
+	if c.started {
+		if line, err := c.readLine(); err != nil || line != "" {
+			return errMalformedChunk
+		}
+		if err := c.verifyChunk(); err != nil {
+			return err
+		}
+	}
+	c.started = true
+
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	sizeField, extension, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+	if err != nil || size < 0 {
+		return errMalformedChunk
+	}
+	if c.seed != nil {
+		signature, ok := strings.CutPrefix(extension, "chunk-signature=")
+		if !ok {
+			return errMalformedChunk
+		}
+		c.chunkSig = signature
+	}
+	c.hash.Reset()
+	c.remaining = size
+	if size > 0 {
+		return nil
+	}
+
+	if err := c.verifyChunk(); err != nil {
+		return err
+	}
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "" {
+			break
+		}
+	}
+	c.err = io.EOF
+	return nil
+}
+
+func (c *chunkedReader) verifyChunk() error {
+	if c.seed == nil {
+		return nil
+	}
+	toSign := strings.Join([]string{
+		algorithm + "-PAYLOAD",
+		c.seed.timestamp,
+		c.seed.scope(),
+		c.previous,
+		emptySHA256,
+		hex.EncodeToString(c.hash.Sum(nil)),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(c.key, toSign))
+	if !hmac.Equal([]byte(expected), []byte(c.chunkSig)) {
+		return &Error{Status: http.StatusForbidden, Code: "SignatureDoesNotMatch", Message: "The chunk signature we calculated does not match the signature you provided"}
+	}
+	c.previous = c.chunkSig
+	return nil
+}
+
+// readLine returns the next CRLF-terminated line without its terminator.
+// Lines longer than the reader's buffer are rejected.
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.r.ReadSlice('\n')
+	if err == io.EOF {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", errMalformedChunk
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"triple-s/flags"
+)
+
+// MaxSizeParam limits the body of a request made with a presigned URL. Like
+// every query parameter it is covered by the signature, so the holder of the
+// URL cannot raise it.
+const MaxSizeParam = "X-Triple-S-Max-Size"
+
+// PresignOptions describes the request a presigned URL allows.
+type PresignOptions struct {
+	Method  string
+	Bucket  string
+	Key     string
+	Expires time.Duration
+	// ContentType, when set, is signed, so the request must send exactly
+	// this Content-Type.
+	ContentType string
+	// MaxSize, when positive, is the largest body the request may send.
+	MaxSize int64
+}
+
+// Presign returns a URL on endpoint, such as "http://localhost:6000", that
+// performs the request described by options with credential's authority
+// until it expires. It is signed the way the AWS SDKs presign, so the
+// server checks it like any other SigV4 request.
+func Presign(credential Credential, endpoint string, options PresignOptions) (string, error) {
+	base, err := url.Parse(endpoint)
+	if err != nil || base.Host == "" {
+		return "", fmt.Errorf("invalid endpoint %q", endpoint)
+	}
+	if options.Expires <= 0 || options.Expires > MaxPresignExpiry {
+		return "", fmt.Errorf("expiry must be between 1s and %s", MaxPresignExpiry)
+	}
+
+	now := time.Now().UTC()
+	sig := &signature{
+		accessKeyID:   credential.AccessKeyID,
+		date:          now.Format(dateFormat),
+		region:        flags.Region,
+		signedHeaders: []string{"host"},
+		timestamp:     now.Format(timeFormat),
+		payloadHash:   UnsignedPayload,
+	}
+	if options.ContentType != "" {
+		sig.signedHeaders = []string{"content-type", "host"}
+	}
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", algorithm)
+	query.Set("X-Amz-Credential", sig.accessKeyID+"/"+sig.scope())
+	query.Set("X-Amz-Date", sig.timestamp)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(options.Expires.Seconds())))
+	query.Set("X-Amz-SignedHeaders", strings.Join(sig.signedHeaders, ";"))
+	if options.MaxSize > 0 {
+		query.Set(MaxSizeParam, strconv.FormatInt(options.MaxSize, 10))
+	}
+
+	path := "/" + options.Bucket
+	if options.Key != "" {
+		path += "/" + options.Key
+	}
+	r := &http.Request{
+		Method: options.Method,
+		Host:   base.Host,
+		URL:    &url.URL{Path: path, RawQuery: query.Encode()},
+		Header: http.Header{},
+	}
+	if options.ContentType != "" {
+		r.Header.Set("Content-Type", options.ContentType)
+	}
+
+	key, err := signingKey(credential, sig.date, sig.region)
+	if err != nil {
+		return "", err
+	}
+	signed := hex.EncodeToString(hmacSHA256(key, stringToSign(sig.timestamp, sig.scope(), canonicalRequest(r, sig))))
+	return fmt.Sprintf("%s://%s%s?%s&X-Amz-Signature=%s", base.Scheme, base.Host, canonicalURI(path), canonicalQuery(r.URL.RawQuery), signed), nil
+}
+
+// limitBody enforces MaxSizeParam on a presigned request.
+func limitBody(r *http.Request, query url.Values) error {
+	if !query.Has(MaxSizeParam) {
+		return nil
+	}
+	limit, err := strconv.ParseInt(query.Get(MaxSizeParam), 10, 64)
+	if err != nil || limit < 0 {
+		return errorf(http.StatusBadRequest, "AuthorizationQueryParametersError", "%s must be a size in bytes", MaxSizeParam)
+	}
+	if r.ContentLength > limit {
+		return errTooLarge(limit)
+	}
+	r.Body = &limitedBody{ReadCloser: r.Body, remaining: limit, limit: limit}
+	return nil
+}
+
+func errTooLarge(limit int64) *Error {
+	return errorf(http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed size of %d bytes", limit)
+}
+
+// limitedBody fails the read that goes past the allowed size, for bodies
+// without a Content-Length.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	limit     int64
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, errTooLarge(l.limit)
+	}
+	return n, err
+}
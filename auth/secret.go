@@ -0,0 +1,65 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"fmt"
+)
+
+// SecretHash stands in for a secret access key that is not stored. SigV4
+// only ever uses the secret as the HMAC-SHA256 key "AWS4"+secret, and an
+// HMAC is fully determined by the SHA-256 states after absorbing the key
+// XORed with the inner and the outer pad. Those two states are enough to
+// derive signing keys, while the secret cannot be recovered from them.
+//
+// This is not a password hash. SigV4 is a shared-secret scheme, so whoever
+// holds the states can sign any request to this server as the key's user;
+// they only keep the secret itself, which may be used elsewhere, from
+// being read back. They must be protected exactly like the secret.
+type SecretHash struct {
+	Inner []byte `json:"inner"`
+	Outer []byte `json:"outer"`
+}
+
+func HashSecret(secret string) (SecretHash, error) {
+	key := []byte("AWS4" + secret)
+	if len(key) > sha256.BlockSize {
+		sum := sha256.Sum256(key)
+		key = sum[:]
+	}
+	pads := [2][]byte{make([]byte, sha256.BlockSize), make([]byte, sha256.BlockSize)}
+	for i := range pads[0] {
+		var b byte
+		if i < len(key) {
+			b = key[i]
+		}
+		pads[0][i] = b ^ 0x36
+		pads[1][i] = b ^ 0x5c
+	}
+
+	var states [2][]byte
+	for i, pad := range pads {
+		h := sha256.New()
+		h.Write(pad)
+		state, err := h.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return SecretHash{}, fmt.Errorf("could not hash secret: %w", err)
+		}
+		states[i] = state
+	}
+	return SecretHash{Inner: states[0], Outer: states[1]}, nil
+}
+
+// mac returns HMAC-SHA256("AWS4"+secret, data).
+func (s SecretHash) mac(data string) ([]byte, error) {
+	inner, outer := sha256.New(), sha256.New()
+	if err := inner.(encoding.BinaryUnmarshaler).UnmarshalBinary(s.Inner); err != nil {
+		return nil, fmt.Errorf("corrupt secret hash: %w", err)
+	}
+	if err := outer.(encoding.BinaryUnmarshaler).UnmarshalBinary(s.Outer); err != nil {
+		return nil, fmt.Errorf("corrupt secret hash: %w", err)
+	}
+	inner.Write([]byte(data))
+	outer.Write(inner.Sum(nil))
+	return outer.Sum(nil), nil
+}
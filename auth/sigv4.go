@@ -0,0 +1,414 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"triple-s/flags"
+)
+
+const (
+	algorithm  = "AWS4-HMAC-SHA256"
+	service    = "s3"
+	terminator = "aws4_request"
+	timeFormat = "20060102T150405Z"
+	dateFormat = "20060102"
+
+	// maxClockSkew is how far the signing time of a request may be from the
+	// server's clock.
+	maxClockSkew = 15 * time.Minute
+	// MaxPresignExpiry is the longest validity a presigned URL may ask for.
+	MaxPresignExpiry = 7 * 24 * time.Hour
+
+	UnsignedPayload          = "UNSIGNED-PAYLOAD"
+	StreamingPayload         = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	StreamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+	emptySHA256              = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	// maxBufferedPayload is the largest body whose x-amz-content-sha256 is
+	// checked before the handler runs. Bigger bodies are checked as they are
+	// read, and a mismatch surfaces as a read error at the end.
+	maxBufferedPayload = 1 << 20
+)
+
+// Error is a failed authentication, carrying the S3 error code and HTTP
+// status to answer with.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+func errorf(status int, code, format string, args ...any) *Error {
+	return &Error{Status: status, Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ErrAnonymous is returned for requests that carry no signature at all.
+var ErrAnonymous = &Error{Status: http.StatusForbidden, Code: "AccessDenied", Message: "Access Denied"}
+
+// signature is the parsed authentication information of a request.
+type signature struct {
+	accessKeyID   string
+	date          string
+	region        string
+	signedHeaders []string
+	signature     string
+	timestamp     string
+	payloadHash   string
+}
+
+func (s *signature) scope() string {
+	return strings.Join([]string{s.date, s.region, service, terminator}, "/")
+}
+
+// Authenticate checks the AWS Signature Version 4 of r, given either in the
+// Authorization header or in the query string of a presigned URL, and
+// returns who signed it. r.Body is replaced so that reading it verifies the
+// signed payload hash or decodes an aws-chunked upload. Failures are
+// returned as *Error.
+func Authenticate(r *http.Request) (Identity, error) {
+	query := r.URL.Query()
+	var sig *signature
+	var err error
+	switch {
+	case r.Header.Get("Authorization") != "":
+		sig, err = parseHeaderAuth(r)
+	case query.Has("X-Amz-Signature"):
+		sig, err = parseQueryAuth(query)
+	default:
+		return Identity{}, ErrAnonymous
+	}
+	if err != nil {
+		return Identity{}, err
+	}
+
+	credential, ok := Credentials.Lookup(sig.accessKeyID)
+	if !ok {
+		return Identity{}, errorf(http.StatusForbidden, "InvalidAccessKeyId", "The AWS access key ID %s does not exist in our records", sig.accessKeyID)
+	}
+	key, err := signingKey(credential, sig.date, sig.region)
+	if err != nil {
+		return Identity{}, err
+	}
+	canonical := canonicalRequest(r, sig)
+	expected := hex.EncodeToString(hmacSHA256(key, stringToSign(sig.timestamp, sig.scope(), canonical)))
+	if !hmac.Equal([]byte(expected), []byte(sig.signature)) {
+		return Identity{}, errorf(http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided")
+	}
+	if err := verifyPayload(r, sig, key); err != nil {
+		return Identity{}, err
+	}
+	if err := limitBody(r, query); err != nil {
+		return Identity{}, err
+	}
+	return Identity{AccessKeyID: credential.AccessKeyID, User: credential.User, Admin: credential.Admin}, nil
+}
+
+// parseHeaderAuth reads an Authorization header of the form
+// AWS4-HMAC-SHA256 Credential=<key>/<scope>, SignedHeaders=<h1;h2>, Signature=<hex>.
+func parseHeaderAuth(r *http.Request) (*signature, error) {
+	value, ok := strings.CutPrefix(r.Header.Get("Authorization"), algorithm+" ")
+	if !ok {
+		return nil, errorf(http.StatusBadRequest, "InvalidArgument", "Only %s authorization is supported", algorithm)
+	}
+	fields := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		fields[name] = value
+	}
+	if fields["Credential"] == "" || fields["SignedHeaders"] == "" || fields["Signature"] == "" {
+		return nil, errorf(http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed")
+	}
+
+	sig := &signature{signedHeaders: strings.Split(fields["SignedHeaders"], ";"), signature: fields["Signature"]}
+	if err := sig.parseCredential(fields["Credential"]); err != nil {
+		return nil, err
+	}
+
+	var signedAt time.Time
+	if amzDate := r.Header.Get("X-Amz-Date"); amzDate != "" {
+		t, err := time.Parse(timeFormat, amzDate)
+		if err != nil {
+			return nil, errorf(http.StatusForbidden, "AccessDenied", "X-Amz-Date must be in the ISO8601 basic format")
+		}
+		signedAt = t
+	} else if t, err := http.ParseTime(r.Header.Get("Date")); err == nil {
+		signedAt = t
+	} else {
+		return nil, errorf(http.StatusForbidden, "AccessDenied", "AWS authentication requires a valid Date or x-amz-date header")
+	}
+	if skew := time.Since(signedAt); skew > maxClockSkew || skew < -maxClockSkew {
+		return nil, errorf(http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large")
+	}
+	sig.timestamp = signedAt.UTC().Format(timeFormat)
+	if err := sig.checkDate(); err != nil {
+		return nil, err
+	}
+
+	sig.payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+	if sig.payloadHash == "" {
+		return nil, errorf(http.StatusBadRequest, "InvalidRequest", "Missing required header for this request: x-amz-content-sha256")
+	}
+	if !sig.signs("host") {
+		return nil, errorf(http.StatusBadRequest, "AuthorizationHeaderMalformed", "The host header must be signed")
+	}
+	return sig, nil
+}
+
+// parseQueryAuth reads the X-Amz-* parameters of a presigned URL. The
+// payload of a presigned request is never signed.
+func parseQueryAuth(query url.Values) (*signature, error) {
+	if query.Get("X-Amz-Algorithm") != algorithm {
+		return nil, errorf(http.StatusBadRequest, "AuthorizationQueryParametersError", "X-Amz-Algorithm only supports %q", algorithm)
+	}
+	sig := &signature{
+		signedHeaders: strings.Split(query.Get("X-Amz-SignedHeaders"), ";"),
+		signature:     query.Get("X-Amz-Signature"),
+		timestamp:     query.Get("X-Amz-Date"),
+		payloadHash:   UnsignedPayload,
+	}
+	if err := sig.parseCredential(query.Get("X-Amz-Credential")); err != nil {
+		return nil, err
+	}
+	signedAt, err := time.Parse(timeFormat, sig.timestamp)
+	if err != nil {
+		return nil, errorf(http.StatusBadRequest, "AuthorizationQueryParametersError", "X-Amz-Date must be in the ISO8601 basic format")
+	}
+	seconds, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil || seconds < 1 || time.Duration(seconds)*time.Second > MaxPresignExpiry {
+		return nil, errorf(http.StatusBadRequest, "AuthorizationQueryParametersError", "X-Amz-Expires must be between 1 and %d seconds", int(MaxPresignExpiry.Seconds()))
+	}
+	if time.Until(signedAt) > maxClockSkew {
+		return nil, errorf(http.StatusForbidden, "AccessDenied", "Request is not valid yet")
+	}
+	if time.Since(signedAt) > time.Duration(seconds)*time.Second {
+		return nil, errorf(http.StatusForbidden, "AccessDenied", "Request has expired")
+	}
+	if err := sig.checkDate(); err != nil {
+		return nil, err
+	}
+	if !sig.signs("host") {
+		return nil, errorf(http.StatusBadRequest, "AuthorizationQueryParametersError", "The host header must be signed")
+	}
+	return sig, nil
+}
+
+// parseCredential splits <access key>/<date>/<region>/s3/aws4_request.
+func (s *signature) parseCredential(value string) error {
+	parts := strings.Split(value, "/")
+	if len(parts) != 5 || parts[0] == "" {
+		return errorf(http.StatusBadRequest, "AuthorizationHeaderMalformed", "The credential %q is malformed", value)
+	}
+	if parts[4] != terminator || parts[3] != service {
+		return errorf(http.StatusBadRequest, "AuthorizationHeaderMalformed", "The credential scope must end in %s/%s", service, terminator)
+	}
+	if parts[2] != flags.Region {
+		return errorf(http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed; the region '%s' is wrong; expecting '%s'", parts[2], flags.Region)
+	}
+	s.accessKeyID, s.date, s.region = parts[0], parts[1], parts[2]
+	return nil
+}
+
+func (s *signature) checkDate() error {
+	if !strings.HasPrefix(s.timestamp, s.date) {
+		return errorf(http.StatusBadRequest, "AuthorizationHeaderMalformed", "The credential date %s does not match the request date", s.date)
+	}
+	return nil
+}
+
+func (s *signature) signs(header string) bool {
+	for _, name := range s.signedHeaders {
+		if name == header {
+			return true
+		}
+	}
+	return false
+}
+
+func canonicalRequest(r *http.Request, sig *signature) string {
+	var headers strings.Builder
+	for _, name := range sig.signedHeaders {
+		headers.WriteString(name)
+		headers.WriteByte(':')
+		headers.WriteString(headerValue(r, name))
+		headers.WriteByte('\n')
+	}
+	return strings.Join([]string{
+		r.Method,
+		canonicalURI(r.URL.Path),
+		canonicalQuery(r.URL.RawQuery),
+		headers.String(),
+		strings.Join(sig.signedHeaders, ";"),
+		sig.payloadHash,
+	}, "\n")
+}
+
+func canonicalURI(path string) string {
+	if path == "" {
+		return "/"
+	}
+	return uriEncode(path, false)
+}
+
+// canonicalQuery sorts the parameters by encoded name and value. The
+// signature itself is not part of what is signed. Unlike url.ParseQuery,
+// a literal "+" is kept as it is rather than read as a space, as S3 does.
+func canonicalQuery(rawQuery string) string {
+	var pairs []string
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		name, err := url.PathUnescape(name)
+		if err != nil || name == "X-Amz-Signature" {
+			continue
+		}
+		if unescaped, err := url.PathUnescape(value); err == nil {
+			value = unescaped
+		}
+		pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// headerValue returns the canonical value of a signed header: all values
+// joined by commas with surrounding space trimmed and inner runs of spaces
+// collapsed. net/http moves some headers out of r.Header.
+func headerValue(r *http.Request, name string) string {
+	var values []string
+	switch name {
+	case "host":
+		values = []string{r.Host}
+	case "content-length":
+		values = r.Header.Values("Content-Length")
+		if len(values) == 0 && r.ContentLength >= 0 {
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		}
+	case "transfer-encoding":
+		values = r.TransferEncoding
+	default:
+		values = r.Header.Values(name)
+	}
+	trimmed := make([]string, len(values))
+	for i, value := range values {
+		trimmed[i] = strings.Join(strings.Fields(value), " ")
+	}
+	return strings.Join(trimmed, ",")
+}
+
+// uriEncode percent-encodes everything but the unreserved characters of
+// RFC 3986, the way the AWS SDKs do. Slashes are kept unless encodeSlash.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !encodeSlash {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+func stringToSign(timestamp, scope, canonicalRequest string) string {
+	sum := sha256.Sum256([]byte(canonicalRequest))
+	return strings.Join([]string{algorithm, timestamp, scope, hex.EncodeToString(sum[:])}, "\n")
+}
+
+// signingKey derives the key for date and region from the credential's
+// secret, or from its hash for keys whose secret is not kept.
+func signingKey(credential Credential, date, region string) ([]byte, error) {
+	var key []byte
+	if credential.hash != nil {
+		var err error
+		if key, err = credential.hash.mac(date); err != nil {
+			return nil, err
+		}
+	} else {
+		key = hmacSHA256([]byte("AWS4"+credential.SecretAccessKey), date)
+	}
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, terminator), nil
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// verifyPayload arranges for the body to be checked against the signed
+// payload hash.
+func verifyPayload(r *http.Request, sig *signature, key []byte) error {
+	switch sig.payloadHash {
+	case UnsignedPayload:
+		return nil
+	case StreamingPayload, StreamingUnsignedTrailer:
+		if decoded := r.Header.Get("X-Amz-Decoded-Content-Length"); decoded != "" {
+			if n, err := strconv.ParseInt(decoded, 10, 64); err == nil {
+				r.ContentLength = n
+			}
+		}
+		removeAWSChunked(r.Header)
+		var seed *signature
+		if sig.payloadHash == StreamingPayload {
+			seed = sig
+		}
+		r.Body = newChunkedReader(r.Body, seed, key)
+		return nil
+	}
+
+	expected, err := hex.DecodeString(sig.payloadHash)
+	if err != nil || len(expected) != sha256.Size {
+		if strings.HasPrefix(sig.payloadHash, "STREAMING-") {
+			return errorf(http.StatusNotImplemented, "NotImplemented", "%s is not supported", sig.payloadHash)
+		}
+		return errorf(http.StatusBadRequest, "InvalidArgument", "x-amz-content-sha256 must be %s, a streaming mode or a hex SHA-256", UnsignedPayload)
+	}
+	if r.ContentLength < 0 || r.ContentLength > maxBufferedPayload {
+		r.Body = &payloadReader{ReadCloser: r.Body, hash: sha256.New(), expected: expected}
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBufferedPayload+1))
+	if err != nil {
+		return errorf(http.StatusBadRequest, "IncompleteBody", "Could not read the request body")
+	}
+	if sum := sha256.Sum256(body); !bytes.Equal(sum[:], expected) {
+		return errPayloadMismatch
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return nil
+}
+
+// removeAWSChunked drops the aws-chunked transfer coding from
+// Content-Encoding, so only the encodings of the object itself are stored.
+func removeAWSChunked(header http.Header) {
+	var kept []string
+	for _, encoding := range strings.Split(header.Get("Content-Encoding"), ",") {
+		if encoding = strings.TrimSpace(encoding); encoding != "" && encoding != "aws-chunked" {
+			kept = append(kept, encoding)
+		}
+	}
+	if len(kept) == 0 {
+		header.Del("Content-Encoding")
+		return
+	}
+	header.Set("Content-Encoding", strings.Join(kept, ","))
+}
@@ -0,0 +1,336 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+	"triple-s/storage"
+)
+
+const usersFile = "users.json"
+
+const (
+	KeyActive   = "Active"
+	KeyInactive = "Inactive"
+)
+
+var (
+	ErrInvalidUserName = errors.New("user names are 1 to 64 letters, digits or _+=,.@-")
+	ErrUserExists      = errors.New("user already exists")
+	ErrNoSuchUser      = errors.New("user does not exist")
+	ErrNoSuchKey       = errors.New("access key does not exist")
+	ErrInvalidStatus   = errors.New("key status must be Active or Inactive")
+)
+
+// User is an account of the UserStore. Buckets record the Name of the user
+// that created them as their owner.
+type User struct {
+	Name    string      `json:"name"`
+	Admin   bool        `json:"admin,omitempty"`
+	Created time.Time   `json:"created"`
+	Keys    []AccessKey `json:"keys"`
+}
+
+// AccessKey is an issued key. The secret is returned once, by CreateKey or
+// RotateKey, and stored as a SecretHash, which signs requests to this
+// server as well as the secret does.
+type AccessKey struct {
+	ID      string     `json:"id"`
+	Status  string     `json:"status"`
+	Created time.Time  `json:"created"`
+	Secret  SecretHash `json:"secret"`
+}
+
+// UserStore keeps users and their access keys in a JSON file. The file is
+// read again whenever it changed on disk, so keys managed with the users
+// command apply to a running server without a restart.
+type UserStore struct {
+	path    string
+	mu      sync.Mutex
+	users   []User
+	modTime time.Time
+	size    int64
+}
+
+func OpenUserStore(path string) (*UserStore, error) {
+	s := &UserStore{path: path}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// reload reads the file if it changed since it was last read. s.mu must be
+// held.
+func (s *UserStore) reload() error {
+	info, err := os.Stat(s.path)
+	if os.IsNotExist(err) {
+		s.users, s.modTime, s.size = nil, time.Time{}, 0
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not stat users file: %w", err)
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return nil
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		// The file is as good as the secrets, see SecretHash.
+		if err := os.Chmod(s.path, 0o600); err != nil {
+			return fmt.Errorf("could not restrict users file to its owner: %w", err)
+		}
+		log.Printf("Users file %s was readable by other users; its mode is now 0600", s.path)
+	}
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return fmt.Errorf("could not read users file: %w", err)
+	}
+	var users []User
+	if err := json.Unmarshal(data, &users); err != nil {
+		return fmt.Errorf("could not decode users file: %w", err)
+	}
+	s.users, s.modTime, s.size = users, info.ModTime(), info.Size()
+	return nil
+}
+
+// save writes s.users with a mode only the server can read. s.mu must be
+// held.
+func (s *UserStore) save() error {
+	sort.Slice(s.users, func(i, j int) bool { return s.users[i].Name < s.users[j].Name })
+	err := storage.WriteFileAtomicMode(s.path, 0o600, func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(s.users)
+	})
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(s.path); err == nil {
+		s.modTime, s.size = info.ModTime(), info.Size()
+	}
+	return nil
+}
+
+// update reloads the file, applies change and saves the result.
+func (s *UserStore) update(change func() error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return err
+	}
+	if err := change(); err != nil {
+		return err
+	}
+	return s.save()
+}
+
+func (s *UserStore) find(name string) *User {
+	for i := range s.users {
+		if s.users[i].Name == name {
+			return &s.users[i]
+		}
+	}
+	return nil
+}
+
+func (s *UserStore) lookup(accessKeyID string) (Credential, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return Credential{}, false
+	}
+	for _, user := range s.users {
+		for _, key := range user.Keys {
+			if key.ID == accessKeyID && key.Status == KeyActive {
+				secret := key.Secret
+				return Credential{AccessKeyID: key.ID, User: user.Name, Admin: user.Admin, hash: &secret}, true
+			}
+		}
+	}
+	return Credential{}, false
+}
+
+// hasUsers reports whether the store has a user. A file that cannot be
+// read counts as having users, so authentication stays on.
+func (s *UserStore) hasUsers() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return true
+	}
+	return len(s.users) > 0
+}
+
+func (s *UserStore) List() ([]User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return nil, err
+	}
+	return append([]User(nil), s.users...), nil
+}
+
+func (s *UserStore) Get(name string) (User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.reload(); err != nil {
+		return User{}, err
+	}
+	user := s.find(name)
+	if user == nil {
+		return User{}, ErrNoSuchUser
+	}
+	return *user, nil
+}
+
+func (s *UserStore) Create(name string, admin bool) (User, error) {
+	if !isValidUserName(name) {
+		return User{}, ErrInvalidUserName
+	}
+	user := User{Name: name, Admin: admin, Created: time.Now().UTC(), Keys: []AccessKey{}}
+	err := s.update(func() error {
+		if s.find(name) != nil || (Credentials != nil && Credentials.HasUser(name)) {
+			return ErrUserExists
+		}
+		s.users = append(s.users, user)
+		return nil
+	})
+	return user, err
+}
+
+// Delete removes a user along with its keys. The caller checks that the
+// user owns no buckets.
+func (s *UserStore) Delete(name string) error {
+	return s.update(func() error {
+		for i := range s.users {
+			if s.users[i].Name == name {
+				s.users = append(s.users[:i], s.users[i+1:]...)
+				return nil
+			}
+		}
+		return ErrNoSuchUser
+	})
+}
+
+// CreateKey issues a new active key to a user.
+func (s *UserStore) CreateKey(name string) (Credential, error) {
+	var credential Credential
+	err := s.update(func() error {
+		user := s.find(name)
+		if user == nil {
+			return ErrNoSuchUser
+		}
+		var err error
+		credential, err = issueKey(user)
+		return err
+	})
+	return credential, err
+}
+
+// RotateKey issues a new key to a user and deactivates accessKeyID. The
+// old key can be deleted once clients have moved to the new one.
+func (s *UserStore) RotateKey(name, accessKeyID string) (Credential, error) {
+	var credential Credential
+	err := s.update(func() error {
+		user := s.find(name)
+		if user == nil {
+			return ErrNoSuchUser
+		}
+		old := findKey(user, accessKeyID)
+		if old == nil {
+			return ErrNoSuchKey
+		}
+		old.Status = KeyInactive
+		var err error
+		credential, err = issueKey(user)
+		return err
+	})
+	return credential, err
+}
+
+// SetKeyStatus disables a key with KeyInactive or enables it again with
+// KeyActive.
+func (s *UserStore) SetKeyStatus(name, accessKeyID, status string) error {
+	if status != KeyActive && status != KeyInactive {
+		return ErrInvalidStatus
+	}
+	return s.update(func() error {
+		user := s.find(name)
+		if user == nil {
+			return ErrNoSuchUser
+		}
+		key := findKey(user, accessKeyID)
+		if key == nil {
+			return ErrNoSuchKey
+		}
+		key.Status = status
+		return nil
+	})
+}
+
+func (s *UserStore) DeleteKey(name, accessKeyID string) error {
+	return s.update(func() error {
+		user := s.find(name)
+		if user == nil {
+			return ErrNoSuchUser
+		}
+		for i, key := range user.Keys {
+			if key.ID == accessKeyID {
+				user.Keys = append(user.Keys[:i], user.Keys[i+1:]...)
+				return nil
+			}
+		}
+		return ErrNoSuchKey
+	})
+}
+
+func findKey(user *User, accessKeyID string) *AccessKey {
+	for i := range user.Keys {
+		if user.Keys[i].ID == accessKeyID {
+			return &user.Keys[i]
+		}
+	}
+	return nil
+}
+
+// issueKey adds a key in the shape AWS uses: a 20 character ID starting
+// with AKIA and a 40 character secret.
+func issueKey(user *User) (Credential, error) {
+	random := make([]byte, 40)
+	if _, err := rand.Read(random); err != nil {
+		return Credential{}, fmt.Errorf("could not generate access key: %w", err)
+	}
+	id := "AKIA" + base32.StdEncoding.EncodeToString(random[:10])
+	secret := base64.StdEncoding.EncodeToString(random[10:])
+	hash, err := HashSecret(secret)
+	if err != nil {
+		return Credential{}, err
+	}
+	user.Keys = append(user.Keys, AccessKey{ID: id, Status: KeyActive, Created: time.Now().UTC(), Secret: hash})
+	return Credential{AccessKeyID: id, SecretAccessKey: secret, User: user.Name, Admin: user.Admin}, nil
+}
+
+func isValidUserName(name string) bool {
+	if len(name) < 1 || len(name) > 64 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '+' || c == '=' || c == ',' || c == '.' || c == '@' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package commands
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"triple-s/storage"
+)
+
+// Fsck reports drift between the metadata store and the files under the
+// storage directory, and fixes it when -repair is given. Issues are printed
+// one per line followed by a single-line JSON summary. The exit status is 0
+// when nothing is left to fix and 1 otherwise.
+func Fsck(args []string) {
+	fs := flag.NewFlagSet("fsck", flag.ExitOnError)
+	repair := fs.Bool("repair", false, "Fix the issues that are found")
+	quiet := fs.Bool("quiet", false, "Print only the JSON summary")
+	fs.Parse(args)
+
+	if err := storage.Init(); err != nil {
+		log.Fatalf("Failed to open metadata store: %v", err)
+	}
+	defer storage.Metadata.Close()
+	defer storage.Journal.Close()
+
+	if pending := storage.Journal.Pending(); len(pending) > 0 {
+		if !*repair {
+			log.Printf("Journal has %d unfinished entries; run with -repair to recover them first", len(pending))
+		} else if err := storage.Journal.Recover(); err != nil {
+			log.Fatalf("Journal recovery failed: %v", err)
+		}
+	}
+
+	report, err := storage.Fsck(*repair)
+	if err != nil {
+		log.Fatalf("fsck failed: %v", err)
+	}
+
+	if !*quiet {
+		for _, issue := range report.Issues {
+			fmt.Println(formatIssue(issue))
+		}
+	}
+
+	summary, err := json.Marshal(struct {
+		Buckets    int            `json:"buckets"`
+		Objects    int            `json:"objects"`
+		Issues     int            `json:"issues"`
+		Repaired   int            `json:"repaired"`
+		Unrepaired int            `json:"unrepaired"`
+		Counts     map[string]int `json:"counts"`
+	}{report.Buckets, report.Objects, len(report.Issues), report.Repaired, report.Unrepaired(), report.Counts})
+	if err != nil {
+		log.Fatalf("Failed to encode summary: %v", err)
+	}
+	fmt.Println(string(summary))
+
+	if report.Unrepaired() > 0 {
+		storage.Metadata.Close()
+		storage.Journal.Close()
+		os.Exit(1)
+	}
+}
+
+func formatIssue(issue storage.FsckIssue) string {
+	target := issue.Bucket
+	if issue.Key != "" {
+		target += "/" + issue.Key
+	}
+	state := "found"
+	if issue.Repaired {
+		state = "repaired"
+	}
+	return strings.Join([]string{state, issue.Kind, target, issue.Detail}, "\t")
+}
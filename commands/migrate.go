@@ -0,0 +1,41 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"triple-s/flags"
+	"triple-s/storage"
+)
+
+// Migrate converts the metadata of an existing storage directory from the
+// CSV layout into another backend.
+func Migrate(args []string) {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", storage.BackendCSV, "Backend to read metadata from")
+	to := fs.String("to", storage.BackendKV, "Backend to write metadata to")
+	fs.Parse(args)
+
+	if *from == *to {
+		log.Fatalf("Source and destination backends are both %q", *from)
+	}
+
+	src, err := storage.Open(*from, flags.StorageDir)
+	if err != nil {
+		log.Fatalf("Failed to open %s metadata: %v", *from, err)
+	}
+	defer src.Close()
+
+	dst, err := storage.Open(*to, flags.StorageDir)
+	if err != nil {
+		log.Fatalf("Failed to open %s metadata: %v", *to, err)
+	}
+	defer dst.Close()
+
+	buckets, objects, err := storage.Migrate(src, dst)
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+	fmt.Printf("Migrated %d buckets and %d objects from %s to %s\n", buckets, objects, *from, *to)
+	fmt.Printf("Start the server with -metadata %s to use the migrated store\n", *to)
+}
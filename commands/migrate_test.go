@@ -0,0 +1,43 @@
+package commands
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+	"triple-s/flags"
+	"triple-s/models"
+	"triple-s/storage"
+)
+
+func TestMigrate(t *testing.T) {
+	previousDir, previousBackend := flags.StorageDir, flags.MetadataBackend
+	flags.StorageDir, flags.MetadataBackend = t.TempDir(), storage.BackendCSV
+	t.Cleanup(func() { flags.StorageDir, flags.MetadataBackend = previousDir, previousBackend })
+	if err := storage.Init(); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if err := storage.Metadata.PutBucket(models.Bucket{Name: "bucket", CreationDate: now, LastModified: now, ContentStatus: "active"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(flags.StorageDir, "bucket"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Metadata.PutObject("bucket", models.ObjectCSV{ObjectKey: "k", ObjectSize: 4, LastModified: now}); err != nil {
+		t.Fatal(err)
+	}
+	storage.Metadata.Close()
+
+	Migrate([]string{"-from", storage.BackendCSV, "-to", storage.BackendKV})
+
+	flags.MetadataBackend = storage.BackendKV
+	if err := storage.Init(); err != nil {
+		t.Fatal(err)
+	}
+	defer storage.Metadata.Close()
+	object, ok, err := storage.Metadata.GetObject("bucket", "k")
+	if err != nil || !ok || object.ObjectSize != 4 {
+		t.Fatalf("got %+v, %v (%v)", object, ok, err)
+	}
+}
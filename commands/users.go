@@ -0,0 +1,118 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"triple-s/auth"
+	"triple-s/storage"
+)
+
+const usersUsage = `Usage:
+    triple-s users list
+    triple-s users create [-admin] <user>
+    triple-s users delete <user>
+    triple-s users create-key <user>
+    triple-s users rotate-key <user> <access-key-id>
+    triple-s users disable-key <user> <access-key-id>
+    triple-s users enable-key <user> <access-key-id>
+    triple-s users delete-key <user> <access-key-id>`
+
+// Users manages the users and access keys of the storage directory. It
+// works on the same file as the admin API, and a running server picks up
+// the changes on its next request. Creating the first user turns
+// authentication on for a server that was started without any.
+func Users(args []string) {
+	if len(args) == 0 {
+		log.Fatal(usersUsage)
+	}
+	fs := flag.NewFlagSet("users "+args[0], flag.ExitOnError)
+	admin := fs.Bool("admin", false, "Let the new user manage users")
+	fs.Parse(args[1:])
+	operands := fs.Args()
+
+	if err := storage.Init(); err != nil {
+		log.Fatalf("Failed to open metadata store: %v", err)
+	}
+	defer storage.Metadata.Close()
+	defer storage.Journal.Close()
+	if err := auth.Init(); err != nil {
+		log.Fatalf("Failed to open users: %v", err)
+	}
+
+	wants := map[string]int{
+		"list": 0, "create": 1, "delete": 1, "create-key": 1,
+		"rotate-key": 2, "disable-key": 2, "enable-key": 2, "delete-key": 2,
+	}
+	n, ok := wants[args[0]]
+	if !ok || len(operands) != n {
+		log.Fatal(usersUsage)
+	}
+
+	var err error
+	switch args[0] {
+	case "list":
+		err = listUsers()
+	case "create":
+		_, err = auth.Users.Create(operands[0], *admin)
+	case "delete":
+		err = deleteUser(operands[0])
+	case "create-key":
+		err = printKey(auth.Users.CreateKey(operands[0]))
+	case "rotate-key":
+		err = printKey(auth.Users.RotateKey(operands[0], operands[1]))
+	case "disable-key":
+		err = auth.Users.SetKeyStatus(operands[0], operands[1], auth.KeyInactive)
+	case "enable-key":
+		err = auth.Users.SetKeyStatus(operands[0], operands[1], auth.KeyActive)
+	case "delete-key":
+		err = auth.Users.DeleteKey(operands[0], operands[1])
+	}
+	if err != nil {
+		storage.Metadata.Close()
+		storage.Journal.Close()
+		fmt.Fprintf(os.Stderr, "users %s: %v\n", args[0], err)
+		os.Exit(1)
+	}
+}
+
+func listUsers() error {
+	users, err := auth.Users.List()
+	if err != nil {
+		return err
+	}
+	for _, user := range users {
+		role := "user"
+		if user.Admin {
+			role = "admin"
+		}
+		var keys []string
+		for _, key := range user.Keys {
+			keys = append(keys, key.ID+":"+key.Status)
+		}
+		fmt.Println(strings.Join([]string{user.Name, role, strings.Join(keys, ",")}, "\t"))
+	}
+	return nil
+}
+
+func deleteUser(name string) error {
+	owned, err := storage.BucketsOwnedBy(name)
+	if err != nil {
+		return err
+	}
+	if len(owned) > 0 {
+		return fmt.Errorf("%s still owns buckets: %s", name, strings.Join(owned, ", "))
+	}
+	return auth.Users.Delete(name)
+}
+
+// printKey shows a new key. The secret cannot be shown again later.
+func printKey(credential auth.Credential, err error) error {
+	if err != nil {
+		return err
+	}
+	fmt.Printf("aws_access_key_id = %s\naws_secret_access_key = %s\n", credential.AccessKeyID, credential.SecretAccessKey)
+	return nil
+}
@@ -6,12 +6,21 @@ import (
 	"log"
 	"os"
 	"strings"
+	"time"
 )
 
 var (
-	Port           string
-	StorageDir     string
-	restrictedDirs = []string{"flags", "handlers", "models", "servers", "storage", "utils", "../", "./"}
+	Port            string
+	StorageDir      string
+	MetadataBackend string
+	StallTimeout    time.Duration
+	MaxMetadataSize int
+	CredentialsFile string
+	Region          string
+	LifecycleScan   time.Duration
+	Command         string
+	CommandArgs     []string
+	restrictedDirs  = []string{"auth", "commands", "flags", "handlers", "lifecycle", "models", "policy", "servers", "storage", "utils", "../", "./"}
 )
 
 func isRestrictedDir(dir string) bool {
@@ -29,8 +38,19 @@ func Setup() {
 	help := flag.Bool("help", false, "Display help information")
 	flag.StringVar(&Port, "port", defaultPort, "Port to run the server on")
 	flag.StringVar(&StorageDir, "directory", defaultStorageDir, "Directory for file storage")
+	flag.StringVar(&MetadataBackend, "metadata", "csv", "Metadata backend: csv or kv")
+	flag.DurationVar(&StallTimeout, "stall-timeout", 30*time.Second, "Drop clients that send or receive nothing for this long")
+	flag.IntVar(&MaxMetadataSize, "max-metadata-size", 2048, "Maximum total size in bytes of an object's x-amz-meta-* headers")
+	flag.StringVar(&CredentialsFile, "credentials", "", "AWS-style credentials file; requests must be signed with SigV4 when set")
+	flag.StringVar(&Region, "region", "us-east-1", "Region expected in request signatures")
+	flag.DurationVar(&LifecycleScan, "lifecycle-interval", time.Hour, "How often lifecycle rules are applied; 0 turns them off")
 	flag.Parse()
 
+	if flag.NArg() > 0 {
+		Command = flag.Arg(0)
+		CommandArgs = flag.Args()[1:]
+	}
+
 	if isRestrictedDir(StorageDir) {
 		log.Fatalf("The specified directory '%s' is restricted. Please choose a different name.", StorageDir)
 	}
@@ -48,11 +68,26 @@ func printUsage() {
 	fmt.Println("Simple Storage Service.")
 	fmt.Println()
 	fmt.Println("Usage:")
-	fmt.Println("    triple-s [-port <N>] [-directory <S>]")
+	fmt.Println("    triple-s [-port <N>] [-directory <S>] [-metadata <B>] [-stall-timeout <D>] [-max-metadata-size <N>] [-credentials <F>] [-region <R>] [-lifecycle-interval <D>]")
+	fmt.Println("    triple-s [-directory <S>] migrate [-to <B>]")
+	fmt.Println("    triple-s [-directory <S>] [-metadata <B>] fsck [-repair] [-quiet]")
+	fmt.Println("    triple-s [-directory <S>] [-metadata <B>] users <subcommand> [args]")
 	fmt.Println("    triple-s --help")
 	fmt.Println()
 	fmt.Println("Options:")
 	fmt.Println("  --help     Show this screen.")
 	fmt.Println("  --port N   Port number")
 	fmt.Println("  --dir S    Path to the directory")
+	fmt.Println("  --metadata B  Metadata backend, csv (default) or kv")
+	fmt.Println("  --stall-timeout D  Drop transfers idle for D (default 30s)")
+	fmt.Println("  --max-metadata-size N  Limit x-amz-meta-* headers to N bytes per object (default 2048)")
+	fmt.Println("  --credentials F  Require SigV4 signatures made with the keys in F")
+	fmt.Println("  --region R  Region clients sign for (default us-east-1)")
+	fmt.Println("  --lifecycle-interval D  Apply bucket lifecycle rules every D, 0 to never (default 1h)")
+	fmt.Println()
+	fmt.Println("Commands:")
+	fmt.Println("  migrate    Copy buckets.csv/objects.csv metadata into another backend")
+	fmt.Println("  fsck       Check metadata against the storage directory, -repair fixes it")
+	fmt.Println("  users      Manage users and access keys: list, create, delete, create-key,")
+	fmt.Println("             rotate-key, disable-key, enable-key, delete-key")
 }
@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"log"
+	"net/http"
+	"triple-s/flags"
+	"triple-s/models"
+	"triple-s/storage"
+)
+
+const (
+	xsiNamespace            = "http://www.w3.org/2001/XMLSchema-instance"
+	allUsersGroup           = "http://acs.amazonaws.com/groups/global/AllUsers"
+	authenticatedUsersGroup = "http://acs.amazonaws.com/groups/global/AuthenticatedUsers"
+)
+
+// readCannedACL returns the x-amz-acl header of a request, "" when it is
+// absent, and answers 400 when it is not a canned ACL.
+func readCannedACL(w http.ResponseWriter, r *http.Request) (string, bool) {
+	acl := r.Header.Get("x-amz-acl")
+	if acl != "" && !storage.IsCannedACL(acl) {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidArgument", Message: "x-amz-acl must be private, public-read, public-read-write or authenticated-read"})
+		return "", false
+	}
+	return acl, true
+}
+
+// readACLUpdate reads the ACL of PUT ?acl. Only canned ACLs in x-amz-acl
+// are supported, not grant lists in the body or x-amz-grant-* headers.
+func readACLUpdate(w http.ResponseWriter, r *http.Request) (string, bool) {
+	if r.Header.Get("x-amz-acl") == "" {
+		w.WriteHeader(http.StatusNotImplemented)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 501, Code: "NotImplemented", Message: "Only canned ACLs in the x-amz-acl header are supported"})
+		return "", false
+	}
+	return readCannedACL(w, r)
+}
+
+// accessControlPolicy lists the grants of a canned ACL the way S3 does.
+func accessControlPolicy(owner, acl string) models.AccessControlPolicy {
+	policy := models.AccessControlPolicy{
+		Owner: models.Owner{ID: owner, DisplayName: owner},
+		AccessControlList: []models.Grant{{
+			Grantee:    models.Grantee{XMLNSXsi: xsiNamespace, Type: "CanonicalUser", ID: owner, DisplayName: owner},
+			Permission: "FULL_CONTROL",
+		}},
+	}
+	group := func(uri, permission string) {
+		policy.AccessControlList = append(policy.AccessControlList, models.Grant{
+			Grantee:    models.Grantee{XMLNSXsi: xsiNamespace, Type: "Group", URI: uri},
+			Permission: permission,
+		})
+	}
+	switch acl {
+	case storage.ACLPublicRead:
+		group(allUsersGroup, "READ")
+	case storage.ACLPublicReadWrite:
+		group(allUsersGroup, "READ")
+		group(allUsersGroup, "WRITE")
+	case storage.ACLAuthenticatedRead:
+		group(authenticatedUsersGroup, "READ")
+	}
+	return policy
+}
+
+// bucketACLHandler serves GET and PUT /{bucket}?acl.
+func bucketACLHandler(w http.ResponseWriter, r *http.Request, bucketName string) {
+	bucket, bucketExists, err := storage.Metadata.GetBucket(bucketName)
+	if err != nil {
+		log.Printf("Error reading bucket metadata: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading bucket metadata"})
+		return
+	}
+	if !bucketExists || !isBucketExists(flags.StorageDir, bucketName) {
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.WriteHeader(http.StatusOK)
+		xml.NewEncoder(w).Encode(accessControlPolicy(bucket.Owner, bucket.ACL))
+	case http.MethodPut:
+		acl, ok := readACLUpdate(w, r)
+		if !ok {
+			return
+		}
+		if err := storage.SetBucketACL(bucketName, acl); err != nil {
+			log.Printf("Error updating ACL of %s: %v\n", bucketName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error updating bucket ACL"})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Bad request"})
+	}
+}
+
+// objectACLHandler serves GET and PUT /{bucket}/{key}?acl[&versionId=].
+// Objects are owned by the owner of their bucket.
+func objectACLHandler(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) {
+	versionID := r.URL.Query().Get("versionId")
+	object, _, ok := lookupObject(w, bucketName, objectKey, versionID)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		bucket, _, err := storage.Metadata.GetBucket(bucketName)
+		if err != nil {
+			log.Printf("Error reading bucket metadata: %v\n", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading bucket metadata"})
+			return
+		}
+		if object.VersionID != "" {
+			w.Header().Set("x-amz-version-id", object.VersionID)
+		}
+		w.WriteHeader(http.StatusOK)
+		xml.NewEncoder(w).Encode(accessControlPolicy(bucket.Owner, object.ACL))
+	case http.MethodPut:
+		acl, ok := readACLUpdate(w, r)
+		if !ok {
+			return
+		}
+		err := storage.SetObjectACL(bucketName, objectKey, versionID, acl)
+		if errors.Is(err, storage.ErrNoSuchObject) || errors.Is(err, storage.ErrNoSuchVersion) {
+			w.WriteHeader(http.StatusNotFound)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Object not found in metadata"})
+			return
+		}
+		if err != nil {
+			log.Printf("Error updating ACL of %s/%s: %v\n", bucketName, objectKey, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error updating object ACL"})
+			return
+		}
+		if object.VersionID != "" {
+			w.Header().Set("x-amz-version-id", object.VersionID)
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Bad request"})
+	}
+}
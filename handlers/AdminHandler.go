@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"triple-s/auth"
+	"triple-s/models"
+	"triple-s/utils"
+)
+
+// AdminHandler serves the administrative endpoints under /_admin/. Bucket
+// names cannot contain an underscore, so the prefix never hides a bucket.
+// They are signed like any other request.
+func AdminHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/xml")
+	r, ok := authenticate(w, r, false)
+	if !ok {
+		return
+	}
+	switch path := r.URL.Path; {
+	case path == "/_admin/users" || strings.HasPrefix(path, "/_admin/users/"):
+		usersHandler(w, r, strings.TrimPrefix(path, "/_admin/users"))
+	case path == "/_admin/lifecycle":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 405, Message: "Method not allowed"})
+			return
+		}
+		lifecycleStatusHandler(w, r)
+	case path == "/_admin/presign":
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 405, Message: "Method not allowed"})
+			return
+		}
+		presignHandler(w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Unknown admin endpoint"})
+	}
+}
+
+// presignHandler serves GET /_admin/presign?method=&bucket=&key=[&expires=]
+// [&content-type=][&max-size=]. The URL is signed with the caller's own
+// key, so it grants nothing the caller could not do.
+func presignHandler(w http.ResponseWriter, r *http.Request) {
+	identity, ok := auth.IdentityFrom(r.Context())
+	if !auth.Enabled() || !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Presigned URLs need authentication; start the server with -credentials or create a user"})
+		return
+	}
+	credential, ok := auth.Credentials.Lookup(identity.AccessKeyID)
+	if !ok {
+		w.WriteHeader(http.StatusForbidden)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 403, Code: "InvalidAccessKeyId", Message: "The access key no longer exists"})
+		return
+	}
+
+	query := r.URL.Query()
+	options := auth.PresignOptions{
+		Method:      query.Get("method"),
+		Bucket:      query.Get("bucket"),
+		Key:         query.Get("key"),
+		Expires:     time.Hour,
+		ContentType: query.Get("content-type"),
+	}
+	switch options.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidArgument", Message: "method must be GET, HEAD, PUT or DELETE"})
+		return
+	}
+	if !isValidBucketName(options.Bucket) {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidBucketName", Message: "Invalid bucket name"})
+		return
+	}
+	if options.Key != "" && !utils.IsValidObjectKey(options.Key) {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidArgument", Message: "Invalid object key"})
+		return
+	}
+	if value := query.Get("expires"); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 1 || time.Duration(seconds)*time.Second > auth.MaxPresignExpiry {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidArgument", Message: "expires must be between 1 and " + strconv.Itoa(int(auth.MaxPresignExpiry.Seconds())) + " seconds"})
+			return
+		}
+		options.Expires = time.Duration(seconds) * time.Second
+	}
+	if value := query.Get("max-size"); value != "" {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || size < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidArgument", Message: "max-size must be a positive number of bytes"})
+			return
+		}
+		options.MaxSize = size
+	}
+	if (options.ContentType != "" || options.MaxSize > 0) && options.Method != http.MethodPut {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidArgument", Message: "content-type and max-size only apply to PUT"})
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	url, err := auth.Presign(credential, scheme+"://"+r.Host, options)
+	if err != nil {
+		log.Printf("Error presigning URL: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error presigning URL"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(models.PresignedURL{
+		URL:     url,
+		Method:  options.Method,
+		Expires: time.Now().UTC().Add(options.Expires).Truncate(time.Second),
+	})
+}
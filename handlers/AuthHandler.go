@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"triple-s/auth"
+	"triple-s/models"
+	"triple-s/policy"
+	"triple-s/storage"
+)
+
+// authenticate checks the request's signature when credentials are
+// configured and writes the error response when it does not hold. The
+// returned request carries the caller's identity in its context. With
+// allowAnonymous, unsigned requests pass without an identity and are left
+// to authorize.
+func authenticate(w http.ResponseWriter, r *http.Request, allowAnonymous bool) (*http.Request, bool) {
+	if !auth.Enabled() {
+		return r, true
+	}
+	identity, err := auth.Authenticate(r)
+	if err == auth.ErrAnonymous && allowAnonymous {
+		return r, true
+	}
+	if err != nil {
+		writeAuthError(w, err)
+		return r, false
+	}
+	return r.WithContext(auth.WithIdentity(r.Context(), identity)), true
+}
+
+// writeAuthError answers with the S3 error carried by an *auth.Error,
+// which may also surface later while the body is read.
+func writeAuthError(w http.ResponseWriter, err error) {
+	var authErr *auth.Error
+	if !errors.As(err, &authErr) {
+		log.Printf("Authentication failed: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Authentication failed"})
+		return
+	}
+	w.WriteHeader(authErr.Status)
+	xml.NewEncoder(w).Encode(models.ErrorResponse{Status: authErr.Status, Code: authErr.Code, Message: authErr.Message})
+}
+
+// bucketAccess loads the owner, policy and ACL of a bucket and returns a
+// check of whether the caller may perform an action on it or on one of its
+// objects. The owner may do anything the policy does not explicitly deny,
+// and may always manage the policy itself so a bad one cannot lock them
+// out. Everyone else, anonymous callers included, needs an Allow from the
+// policy or a grant from the bucket's or the object's canned ACL. Buckets
+// created before there were owners belong to the admins. Without
+// authentication everything is allowed. Buckets that do not exist are left
+// to the handlers, except for anonymous callers.
+func bucketAccess(r *http.Request, bucketName string) (func(objectKey, versionID, action string) bool, error) {
+	if !auth.Enabled() {
+		return func(string, string, string) bool { return true }, nil
+	}
+	identity, authenticated := auth.IdentityFrom(r.Context())
+	bucket, exists, err := storage.Metadata.GetBucket(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return func(string, string, string) bool { return authenticated }, nil
+	}
+	var bucketPolicy *policy.Policy
+	if bucket.Policy != "" {
+		if bucketPolicy, err = policy.Parse([]byte(bucket.Policy), bucketName); err != nil {
+			return nil, fmt.Errorf("stored policy of %s: %w", bucketName, err)
+		}
+	}
+
+	owner := identity.User != "" && (identity.User == bucket.Owner || (bucket.Owner == "" && identity.Admin))
+	context := conditionContext(r, identity)
+	return func(objectKey, versionID, action string) bool {
+		decision := policy.NotApplicable
+		if bucketPolicy != nil {
+			decision = bucketPolicy.Evaluate(policy.Request{
+				User:     identity.User,
+				Action:   action,
+				Resource: policy.Resource(bucketName, objectKey),
+				Context:  context,
+			})
+		}
+		if owner && strings.HasSuffix(action, "BucketPolicy") {
+			return true
+		}
+		if decision == policy.Deny {
+			return false
+		}
+		return decision == policy.Allow || owner || aclAllows(r, bucket, objectKey, versionID, action, authenticated)
+	}, nil
+}
+
+// aclAllows reports whether a canned ACL grants an action to callers other
+// than the owner. READ on a bucket lists it and WRITE creates and deletes
+// its objects; READ on an object reads it. A grant only covers the
+// requests it describes, so an action reached through any other method or
+// resource is refused.
+func aclAllows(r *http.Request, bucket models.Bucket, objectKey, versionID, action string, authenticated bool) bool {
+	reads := r.Method == http.MethodGet || r.Method == http.MethodHead
+	acl := bucket.ACL
+	switch action {
+	case "s3:ListBucket", "s3:ListBucketVersions":
+		return objectKey == "" && reads && grantsRead(acl, authenticated)
+	case "s3:PutObject":
+		return objectKey != "" && (r.Method == http.MethodPut || r.Method == http.MethodPost) && acl == storage.ACLPublicReadWrite
+	case "s3:DeleteObject", "s3:DeleteObjectVersion":
+		// POST is the batch delete, which checks each key.
+		return objectKey != "" && (r.Method == http.MethodDelete || r.Method == http.MethodPost) && acl == storage.ACLPublicReadWrite
+	case "s3:AbortMultipartUpload":
+		return objectKey != "" && r.Method == http.MethodDelete && acl == storage.ACLPublicReadWrite
+	case "s3:ListMultipartUploadParts":
+		return objectKey != "" && r.Method == http.MethodGet && acl == storage.ACLPublicReadWrite
+	case "s3:GetObject", "s3:GetObjectVersion":
+		// A copy reads its source.
+		copies := r.Method == http.MethodPut && r.Header.Get("x-amz-copy-source") != ""
+		if objectKey == "" || !(reads || copies) {
+			return false
+		}
+		var object models.ObjectCSV
+		var err error
+		if versionID != "" {
+			object, _, err = storage.GetObjectVersion(bucket.Name, objectKey, versionID)
+		} else {
+			object, _, err = storage.Metadata.GetObject(bucket.Name, objectKey)
+		}
+		return err == nil && grantsRead(object.ACL, authenticated)
+	}
+	return false
+}
+
+func grantsRead(acl string, authenticated bool) bool {
+	return acl == storage.ACLPublicRead || acl == storage.ACLPublicReadWrite || (acl == storage.ACLAuthenticatedRead && authenticated)
+}
+
+// authorize applies bucketAccess to the request and answers 403 when it
+// is not allowed.
+func authorize(w http.ResponseWriter, r *http.Request, bucketName, objectKey, versionID, action string) bool {
+	allowed, err := bucketAccess(r, bucketName)
+	if err != nil {
+		log.Printf("Error reading bucket policy: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading bucket policy"})
+		return false
+	}
+	if !allowed(objectKey, versionID, action) {
+		w.WriteHeader(http.StatusForbidden)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 403, Code: "AccessDenied", Message: "Access Denied"})
+		return false
+	}
+	return true
+}
+
+// conditionContext collects the policy condition keys of a request.
+func conditionContext(r *http.Request, identity auth.Identity) map[string]string {
+	context := map[string]string{
+		"aws:SecureTransport": strconv.FormatBool(r.TLS != nil),
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		context["aws:SourceIp"] = host
+	}
+	if identity.User != "" {
+		context["aws:username"] = identity.User
+	}
+	if acl := r.Header.Get("x-amz-acl"); acl != "" {
+		context["s3:x-amz-acl"] = acl
+	}
+	query := r.URL.Query()
+	for param, key := range map[string]string{
+		"prefix":    "s3:prefix",
+		"delimiter": "s3:delimiter",
+		"max-keys":  "s3:max-keys",
+		"versionId": "s3:VersionId",
+	} {
+		if query.Has(param) {
+			context[key] = query.Get(param)
+		}
+	}
+	return context
+}
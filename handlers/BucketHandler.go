@@ -1,56 +1,148 @@
 package handlers
 
 import (
-	"encoding/csv"
+	"encoding/base64"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
+	"triple-s/auth"
 	"triple-s/flags"
 	"triple-s/models"
 	"triple-s/storage"
 )
 
 func listBucketsHandler(w http.ResponseWriter, r *http.Request) {
-	csvFilePath := filepath.Join(flags.StorageDir, "buckets.csv")
-	file, err := os.Open(csvFilePath)
+	buckets, err := storage.Metadata.ListBuckets()
 	if err != nil {
-		log.Printf("Error opening CSV file: %v\n", err)
+		log.Printf("Error reading bucket metadata: %v\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading buckets"})
 		return
 	}
-	defer file.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	// With authentication, callers only see their own buckets. Buckets
+	// created before there were owners are listed for admins.
+	if identity, ok := auth.IdentityFrom(r.Context()); ok {
+		owned := buckets[:0]
+		for _, bucket := range buckets {
+			if bucket.Owner == identity.User || (bucket.Owner == "" && identity.Admin) {
+				owned = append(owned, bucket)
+			}
+		}
+		buckets = owned
+	}
+
+	result := models.ListAllMyBucketsResult{Buckets: buckets}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	if err := xml.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("Error encoding XML response: %v\n", err)
+	}
+}
+
+const (
+	defaultMaxKeys = 1000
+	maxMaxKeys     = 1000
+)
+
+// listObjectsHandler serves ListObjectsV2. The continuation token is the
+// last entry of the previous page, encoded so clients treat it as opaque.
+func listObjectsHandler(w http.ResponseWriter, r *http.Request, bucketName string) {
+	_, bucketExists, err := storage.Metadata.GetBucket(bucketName)
 	if err != nil {
-		log.Printf("Error reading CSV file: %v\n", err)
+		log.Printf("Error reading bucket metadata: %v\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading buckets"})
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading bucket metadata"})
+		return
+	}
+	if !bucketExists || !isBucketExists(flags.StorageDir, bucketName) {
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
 		return
 	}
 
-	var buckets []models.Bucket
-	for _, record := range records {
-		if len(record) > 0 {
-			creationDate, _ := time.Parse(time.RFC3339, record[1])
-			lastModified, _ := time.Parse(time.RFC3339, record[3])
-			buckets = append(buckets, models.Bucket{
-				Name:          record[0],
-				CreationDate:  creationDate,
-				LastModified:  lastModified,
-				ContentStatus: record[2],
-			})
+	query := r.URL.Query()
+	opts := storage.ListOptions{
+		Prefix:     query.Get("prefix"),
+		Delimiter:  query.Get("delimiter"),
+		StartAfter: query.Get("start-after"),
+		MaxKeys:    defaultMaxKeys,
+	}
+	if value := query.Get("max-keys"); value != "" {
+		maxKeys, err := strconv.Atoi(value)
+		if err != nil || maxKeys < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Invalid max-keys"})
+			return
 		}
+		opts.MaxKeys = min(maxKeys, maxMaxKeys)
+	}
+	for _, filter := range query["tag"] {
+		key, value, ok := strings.Cut(filter, "=")
+		if !ok || key == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidArgument", Message: "tag must be key=value"})
+			return
+		}
+		if opts.Tags == nil {
+			opts.Tags = make(map[string]string)
+		}
+		opts.Tags[key] = value
+	}
+	token := query.Get("continuation-token")
+	if token != "" {
+		marker, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil || len(marker) == 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Invalid continuation token"})
+			return
+		}
+		opts.Marker = string(marker)
+	}
+
+	page, err := storage.ListObjectsPage(bucketName, opts)
+	if err != nil {
+		log.Printf("Error listing objects in %s: %v\n", bucketName, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading objects metadata"})
+		return
+	}
+
+	result := models.ListBucketResult{
+		Name:              bucketName,
+		Prefix:            opts.Prefix,
+		Delimiter:         opts.Delimiter,
+		StartAfter:        opts.StartAfter,
+		ContinuationToken: token,
+		KeyCount:          len(page.Objects) + len(page.CommonPrefixes),
+		MaxKeys:           opts.MaxKeys,
+		IsTruncated:       page.IsTruncated,
+	}
+	// An empty first page, as with max-keys=0, has nothing to continue
+	// from; the listing starts over without a token.
+	if page.IsTruncated && page.NextMarker != "" {
+		result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(page.NextMarker))
+	}
+	for _, object := range page.Objects {
+		result.Contents = append(result.Contents, models.ObjectContent{
+			Key:          object.ObjectKey,
+			LastModified: object.LastModified,
+			ETag:         quoteETag(object.ETag),
+			Size:         object.ObjectSize,
+			StorageClass: "STANDARD",
+		})
+	}
+	for _, prefix := range page.CommonPrefixes {
+		result.CommonPrefixes = append(result.CommonPrefixes, models.CommonPrefix{Prefix: prefix})
 	}
 
-	result := models.ListAllMyBucketsResult{Buckets: buckets}
-	w.Header().Set("Content-Type", "application/xml")
 	w.WriteHeader(http.StatusOK)
 	if err := xml.NewEncoder(w).Encode(result); err != nil {
 		log.Printf("Error encoding XML response: %v\n", err)
@@ -69,11 +161,8 @@ func createBucketHandler(w http.ResponseWriter, r *http.Request, bucketName stri
 		return
 	}
 
-	err := os.Mkdir(fmt.Sprintf("%s/%s", flags.StorageDir, bucketName), 0o755)
-	if err != nil {
-		log.Printf("Error creating bucket: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error creating bucket"})
+	acl, ok := readCannedACL(w, r)
+	if !ok {
 		return
 	}
 
@@ -83,12 +172,17 @@ func createBucketHandler(w http.ResponseWriter, r *http.Request, bucketName stri
 		CreationDate:  time.Now(),
 		ContentStatus: contentStatus,
 		LastModified:  time.Now(),
+		ACL:           acl,
+	}
+	if identity, ok := auth.IdentityFrom(r.Context()); ok {
+		csvdata.Owner = identity.User
 	}
 
-	err = storage.UpdateBucketCSV(bucketName, csvdata)
+	err := storage.CreateBucket(csvdata)
 	if err != nil {
+		log.Printf("Error creating bucket: %v\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error updating metadata"})
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error creating bucket"})
 		return
 	}
 
@@ -97,57 +191,47 @@ func createBucketHandler(w http.ResponseWriter, r *http.Request, bucketName stri
 }
 
 func isValidBucketName(bucketName string) bool {
-	if len(bucketName) < 3 || len(bucketName) > 63 {
-		return false
-	}
-	if !islowercaseLetterorDigit(bucketName[0]) || !islowercaseLetterorDigit(bucketName[len(bucketName)-1]) {
-		return false
-	}
+	return storage.ValidBucketName(bucketName)
+}
 
-	for i := 0; i < len(bucketName); i++ {
-		char := bucketName[i]
-		if !(islowercaseLetterorDigit(char) || char == '-' || char == '.') {
-			return false
-		}
+func headBucketHandler(w http.ResponseWriter, r *http.Request, bucketName string) {
+	_, bucketExists, err := storage.Metadata.GetBucket(bucketName)
+	if err != nil {
+		log.Printf("Error reading bucket metadata: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !bucketExists || !isBucketExists(flags.StorageDir, bucketName) {
+		w.WriteHeader(http.StatusNotFound)
+		return
 	}
-	return true
+	w.WriteHeader(http.StatusOK)
 }
 
 func deleteBucketHandler(w http.ResponseWriter, r *http.Request, bucketName string) {
-	bucketPath := filepath.Join(flags.StorageDir, bucketName)
-
 	if !isBucketExists(flags.StorageDir, bucketName) {
 		w.WriteHeader(http.StatusNotFound)
 		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
 		return
 	}
-	bucketExists, err := checkBucketInCSV(bucketName)
+	_, bucketExists, err := storage.Metadata.GetBucket(bucketName)
 	if err != nil {
-		log.Printf("Error checking object in objects.csv: %v\n", err)
+		log.Printf("Error reading bucket metadata: %v\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error checking object existence"})
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error checking bucket existence"})
 		return
 	}
 	if !bucketExists {
 		w.WriteHeader(http.StatusNotFound)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Object not found in objects.csv"})
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found in metadata"})
 		return
 	}
-	isEmpty, err := storage.IsBucketEmptyIgnoringObjectsCSV(bucketPath)
-	if err != nil {
-		log.Printf("Error checking if bucket is empty: %v\n", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error checking bucket status"})
-		return
-	}
-
-	if !isEmpty {
+	err = storage.RemoveBucket(bucketName)
+	if errors.Is(err, storage.ErrBucketNotEmpty) {
 		w.WriteHeader(http.StatusConflict)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 409, Message: "Bucket is not empty"})
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 409, Code: "BucketNotEmpty", Message: err.Error()})
 		return
 	}
-
-	err = os.RemoveAll(bucketPath)
 	if err != nil {
 		log.Printf("Error deleting bucket %s: %v\n", bucketName, err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -155,8 +239,6 @@ func deleteBucketHandler(w http.ResponseWriter, r *http.Request, bucketName stri
 		return
 	}
 
-	storage.RemoveBucketCSV(bucketName)
-
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -176,26 +258,3 @@ func isBucketEmpty(bucketPath string) (bool, error) {
 
 	return len(entries) == 0, nil
 }
-
-func checkBucketInCSV(bucketName string) (bool, error) {
-	csvFilePath := filepath.Join(flags.StorageDir, "buckets.csv")
-	file, err := os.Open(csvFilePath)
-	if err != nil {
-		return false, err
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return false, err
-	}
-
-	for _, record := range records {
-		if record[0] == bucketName { // Assuming ObjectKey is the first column
-			return true, nil
-		}
-	}
-
-	return false, nil
-}
@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"triple-s/flags"
+	"triple-s/models"
+	"triple-s/storage"
+)
+
+// maxCORSRules is the number of rules S3 accepts in one configuration.
+const maxCORSRules = 100
+
+// corsMethods are the methods a CORS rule may allow.
+var corsMethods = map[string]bool{
+	http.MethodGet: true, http.MethodPut: true, http.MethodHead: true, http.MethodPost: true, http.MethodDelete: true,
+}
+
+// bucketCORSHandler serves GET, PUT and DELETE /{bucket}?cors. The
+// document is stored as uploaded once it parses.
+func bucketCORSHandler(w http.ResponseWriter, r *http.Request, bucketName string) {
+	bucket, bucketExists, err := storage.Metadata.GetBucket(bucketName)
+	if err != nil {
+		log.Printf("Error reading bucket metadata: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading bucket metadata"})
+		return
+	}
+	if !bucketExists || !isBucketExists(flags.StorageDir, bucketName) {
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if bucket.CORS == "" {
+			w.WriteHeader(http.StatusNotFound)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Code: "NoSuchCORSConfiguration", Message: "The CORS configuration does not exist"})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, bucket.CORS)
+	case http.MethodPut:
+		document, err := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Failed to read request body"})
+			return
+		}
+		if _, err := parseCORS(document); err != nil {
+			code := "InvalidRequest"
+			if errors.Is(err, errMalformedCORS) {
+				code = "MalformedXML"
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: code, Message: err.Error()})
+			return
+		}
+		if err := storage.SetBucketCORS(bucketName, string(document)); err != nil {
+			log.Printf("Error updating CORS configuration of %s: %v\n", bucketName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error updating CORS configuration"})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		if err := storage.SetBucketCORS(bucketName, ""); err != nil {
+			log.Printf("Error deleting CORS configuration of %s: %v\n", bucketName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error deleting CORS configuration"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Bad request"})
+	}
+}
+
+var errMalformedCORS = errors.New("invalid CORSConfiguration document")
+
+// parseCORS reads a CORS configuration and checks its rules the way S3
+// does: every rule names origins and methods, and origins and allowed
+// headers hold at most one * wildcard.
+func parseCORS(document []byte) (models.CORSConfiguration, error) {
+	var config models.CORSConfiguration
+	if err := xml.NewDecoder(bytes.NewReader(document)).Decode(&config); err != nil {
+		return config, errMalformedCORS
+	}
+	if len(config.Rules) == 0 || len(config.Rules) > maxCORSRules {
+		return config, fmt.Errorf("a CORS configuration needs 1 to %d rules", maxCORSRules)
+	}
+	for _, rule := range config.Rules {
+		if len(rule.AllowedOrigins) == 0 || len(rule.AllowedMethods) == 0 {
+			return config, errors.New("every CORSRule needs an AllowedOrigin and an AllowedMethod")
+		}
+		for _, method := range rule.AllowedMethods {
+			if !corsMethods[method] {
+				return config, fmt.Errorf("found unsupported HTTP method in CORS config: %s", method)
+			}
+		}
+		for _, origin := range rule.AllowedOrigins {
+			if strings.Count(origin, "*") > 1 {
+				return config, fmt.Errorf("AllowedOrigin %q can not have more than one wildcard", origin)
+			}
+		}
+		for _, header := range rule.AllowedHeaders {
+			if strings.Count(header, "*") > 1 {
+				return config, fmt.Errorf("AllowedHeader %q can not have more than one wildcard", header)
+			}
+		}
+		if rule.MaxAgeSeconds != nil && *rule.MaxAgeSeconds < 0 {
+			return config, errors.New("MaxAgeSeconds can not be negative")
+		}
+	}
+	return config, nil
+}
+
+// matchCORSRule returns the first rule that allows origin to use method
+// with the given request headers.
+func matchCORSRule(config models.CORSConfiguration, origin, method string, headers []string) (models.CORSRule, bool) {
+	for _, rule := range config.Rules {
+		if !matchesAnyOrigin(rule.AllowedOrigins, origin) || !contains(rule.AllowedMethods, method) {
+			continue
+		}
+		allowed := true
+		for _, header := range headers {
+			if !matchesAnyHeader(rule.AllowedHeaders, header) {
+				allowed = false
+				break
+			}
+		}
+		if allowed {
+			return rule, true
+		}
+	}
+	return models.CORSRule{}, false
+}
+
+func matchesAnyOrigin(patterns []string, origin string) bool {
+	for _, pattern := range patterns {
+		if wildcardMatch(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAnyHeader compares header names case-insensitively.
+func matchesAnyHeader(patterns []string, header string) bool {
+	for _, pattern := range patterns {
+		if wildcardMatch(strings.ToLower(pattern), strings.ToLower(header)) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// wildcardMatch matches a pattern holding at most one *.
+func wildcardMatch(pattern, value string) bool {
+	prefix, suffix, ok := strings.Cut(pattern, "*")
+	if !ok {
+		return pattern == value
+	}
+	return len(value) >= len(prefix)+len(suffix) && strings.HasPrefix(value, prefix) && strings.HasSuffix(value, suffix)
+}
+
+// bucketCORS returns the parsed CORS configuration of a bucket, if it has
+// one.
+func bucketCORS(bucketName string) (models.CORSConfiguration, bool, error) {
+	bucket, exists, err := storage.Metadata.GetBucket(bucketName)
+	if err != nil || !exists || bucket.CORS == "" {
+		return models.CORSConfiguration{}, false, err
+	}
+	config, err := parseCORS([]byte(bucket.CORS))
+	if err != nil {
+		return config, false, fmt.Errorf("stored CORS configuration of %s: %w", bucketName, err)
+	}
+	return config, true, nil
+}
+
+// corsPreflightHandler answers OPTIONS requests from browsers. They are
+// never signed, so the bucket's CORS rules alone decide.
+func corsPreflightHandler(w http.ResponseWriter, r *http.Request, bucketName string) {
+	origin := r.Header.Get("Origin")
+	method := r.Header.Get("Access-Control-Request-Method")
+	if bucketName == "" || origin == "" || method == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Insufficient information. Origin and Access-Control-Request-Method request headers needed."})
+		return
+	}
+	config, ok, err := bucketCORS(bucketName)
+	if err != nil {
+		log.Printf("Error reading CORS configuration: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading CORS configuration"})
+		return
+	}
+	if !ok {
+		w.WriteHeader(http.StatusForbidden)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 403, Code: "AccessForbidden", Message: "CORSResponse: CORS is not enabled for this bucket."})
+		return
+	}
+
+	var headers []string
+	for _, header := range strings.Split(r.Header.Get("Access-Control-Request-Headers"), ",") {
+		if header = strings.TrimSpace(header); header != "" {
+			headers = append(headers, strings.ToLower(header))
+		}
+	}
+	w.Header().Set("Vary", "Origin, Access-Control-Request-Headers, Access-Control-Request-Method")
+	rule, ok := matchCORSRule(config, origin, method, headers)
+	if !ok {
+		w.WriteHeader(http.StatusForbidden)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 403, Code: "AccessForbidden", Message: "CORSResponse: This CORS request is not allowed."})
+		return
+	}
+	writeCORSHeaders(w, rule, origin)
+	if len(headers) > 0 {
+		w.Header().Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+	}
+	if rule.MaxAgeSeconds != nil {
+		w.Header().Set("Access-Control-Max-Age", strconv.Itoa(*rule.MaxAgeSeconds))
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// setCORSHeaders adds the Access-Control-* headers to the response of a
+// cross-origin request when a rule of the bucket allows it. They are set
+// before the request is authorized so browsers can read errors too.
+func setCORSHeaders(w http.ResponseWriter, r *http.Request, bucketName string) {
+	origin := r.Header.Get("Origin")
+	if bucketName == "" || origin == "" {
+		return
+	}
+	config, ok, err := bucketCORS(bucketName)
+	if err != nil {
+		log.Printf("Error reading CORS configuration: %v\n", err)
+		return
+	}
+	if !ok {
+		return
+	}
+	w.Header().Set("Vary", "Origin, Access-Control-Request-Headers, Access-Control-Request-Method")
+	if rule, ok := matchCORSRule(config, origin, r.Method, nil); ok {
+		writeCORSHeaders(w, rule, origin)
+	}
+}
+
+// writeCORSHeaders answers with "*" to rules open to every origin, and
+// otherwise echoes the origin and allows credentials.
+func writeCORSHeaders(w http.ResponseWriter, rule models.CORSRule, origin string) {
+	if contains(rule.AllowedOrigins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+	w.Header().Set("Access-Control-Allow-Methods", strings.Join(rule.AllowedMethods, ", "))
+	if len(rule.ExposeHeaders) > 0 {
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(rule.ExposeHeaders, ", "))
+	}
+}
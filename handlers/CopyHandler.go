@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"triple-s/flags"
+	"triple-s/models"
+	"triple-s/storage"
+	"triple-s/utils"
+)
+
+const (
+	metadataDirectiveCopy    = "COPY"
+	metadataDirectiveReplace = "REPLACE"
+)
+
+// copyObjectHandler serves PUT /{bucket}/{key} with an x-amz-copy-source
+// header. The data never passes through the handler; metadata is taken from
+// the source or, with x-amz-metadata-directive: REPLACE, from the request.
+// Tags are handled the same way with x-amz-tagging-directive.
+func copyObjectHandler(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) {
+	if !isBucketExists(flags.StorageDir, bucketName) {
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
+		return
+	}
+	if storage.CheckObjectKey(objectKey) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Invalid object key"})
+		return
+	}
+
+	sourceBucket, sourceKey, sourceVersion, ok := parseCopySource(r.Header.Get("x-amz-copy-source"))
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidArgument", Message: "Invalid x-amz-copy-source header"})
+		return
+	}
+	directive := strings.ToUpper(r.Header.Get("x-amz-metadata-directive"))
+	if directive == "" {
+		directive = metadataDirectiveCopy
+	}
+	if directive != metadataDirectiveCopy && directive != metadataDirectiveReplace {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidArgument", Message: "Invalid x-amz-metadata-directive header"})
+		return
+	}
+	taggingDirective := strings.ToUpper(r.Header.Get("x-amz-tagging-directive"))
+	if taggingDirective == "" {
+		taggingDirective = metadataDirectiveCopy
+	}
+	if taggingDirective != metadataDirectiveCopy && taggingDirective != metadataDirectiveReplace {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidArgument", Message: "Invalid x-amz-tagging-directive header"})
+		return
+	}
+	if sourceBucket == bucketName && sourceKey == objectKey && sourceVersion == "" && directive != metadataDirectiveReplace {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidRequest", Message: "An object cannot be copied onto itself without replacing its metadata"})
+		return
+	}
+
+	sourceAction := "s3:GetObject"
+	if sourceVersion != "" {
+		sourceAction = "s3:GetObjectVersion"
+	}
+	if !authorize(w, r, sourceBucket, sourceKey, sourceVersion, sourceAction) {
+		return
+	}
+
+	source, _, ok := lookupObject(w, sourceBucket, sourceKey, sourceVersion)
+	if !ok {
+		return
+	}
+	if !copySourceMatches(r, source) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 412, Message: "Precondition failed"})
+		return
+	}
+
+	acl, ok := readCannedACL(w, r)
+	if !ok {
+		return
+	}
+	now := time.Now()
+	object := models.ObjectCSV{ObjectKey: objectKey, CreationDate: now, LastModified: now, ACL: acl, Tags: source.Tags}
+	if taggingDirective == metadataDirectiveReplace {
+		if object.Tags, ok = readTaggingHeader(w, r); !ok {
+			return
+		}
+	}
+	if directive == metadataDirectiveReplace {
+		object.ContentType = r.Header.Get("Content-Type")
+		if !readObjectHeaders(r, &object) {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: fmt.Sprintf("User metadata exceeds %d bytes", flags.MaxMetadataSize)})
+			return
+		}
+	} else {
+		object.ContentType = source.ContentType
+		object.CacheControl = source.CacheControl
+		object.ContentDisposition = source.ContentDisposition
+		object.ContentEncoding = source.ContentEncoding
+		object.ContentLanguage = source.ContentLanguage
+		object.UserMetadata = source.UserMetadata
+	}
+
+	stored, err := storage.CopyObject(sourceBucket, source, bucketName, object, putPrecondition(r))
+	if errors.Is(err, storage.ErrBucketNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
+		return
+	}
+	if errors.Is(err, storage.ErrPreconditionFailed) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 412, Message: "Precondition failed"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to copy %s/%s to %s/%s: %v", sourceBucket, sourceKey, bucketName, objectKey, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error copying object"})
+		return
+	}
+
+	if source.VersionID != "" {
+		w.Header().Set("x-amz-copy-source-version-id", source.VersionID)
+	}
+	if stored.VersionID != "" {
+		w.Header().Set("x-amz-version-id", stored.VersionID)
+	}
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(models.CopyObjectResult{LastModified: stored.LastModified, ETag: quoteETag(stored.ETag)})
+}
+
+// parseCopySource splits an x-amz-copy-source value of the form
+// [/]bucket/key[?versionId=id], with the key URL-encoded.
+func parseCopySource(value string) (string, string, string, bool) {
+	value, query, _ := strings.Cut(value, "?")
+	path, err := url.PathUnescape(value)
+	if err != nil {
+		return "", "", "", false
+	}
+	bucketName, objectKey, err := splitPath("/" + strings.TrimPrefix(path, "/"))
+	if err != nil || !isValidBucketName(bucketName) || !utils.IsValidObjectKey(objectKey) {
+		return "", "", "", false
+	}
+	values, err := url.ParseQuery(query)
+	if err != nil {
+		return "", "", "", false
+	}
+	return bucketName, objectKey, values.Get("versionId"), true
+}
+
+// copySourceMatches evaluates the x-amz-copy-source-if-* headers against
+// the source object. As in S3, when an ETag and a date condition of the
+// same kind are both given, the ETag condition decides.
+func copySourceMatches(r *http.Request, source models.ObjectCSV) bool {
+	modified := source.LastModified.Truncate(time.Second)
+
+	if ifMatch := r.Header.Get("x-amz-copy-source-if-match"); ifMatch != "" {
+		if !etagListMatches(ifMatch, source.ETag) {
+			return false
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("x-amz-copy-source-if-unmodified-since")); err == nil && modified.After(since) {
+		return false
+	}
+
+	if ifNoneMatch := r.Header.Get("x-amz-copy-source-if-none-match"); ifNoneMatch != "" {
+		if etagListMatches(ifNoneMatch, source.ETag) {
+			return false
+		}
+	} else if since, err := http.ParseTime(r.Header.Get("x-amz-copy-source-if-modified-since")); err == nil && !modified.After(since) {
+		return false
+	}
+	return true
+}
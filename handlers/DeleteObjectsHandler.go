@@ -0,0 +1,129 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"triple-s/flags"
+	"triple-s/models"
+	"triple-s/storage"
+)
+
+const (
+	maxDeleteObjects = 1000
+	// maxDeleteBodySize bounds the Delete request, which lists at most
+	// maxDeleteObjects keys of up to 1024 bytes each.
+	maxDeleteBodySize = 2 << 20
+)
+
+// deleteObjectsHandler serves POST /{bucket}?delete. Keys without a version
+// ID are removed together by storage.RemoveObjects; versions are removed
+// one by one. Problems with single keys are reported in the result instead
+// of failing the request.
+func deleteObjectsHandler(w http.ResponseWriter, r *http.Request, bucketName string) {
+	if !isBucketExists(flags.StorageDir, bucketName) {
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxDeleteBodySize+1))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Failed to read request body"})
+		return
+	}
+	if value := r.Header.Get("Content-MD5"); value != "" {
+		expected, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(expected) != md5.Size {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidDigest", Message: "Invalid Content-MD5 header"})
+			return
+		}
+		if sum := md5.Sum(body); !bytes.Equal(sum[:], expected) {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "BadDigest", Message: "Content-MD5 does not match the request body"})
+			return
+		}
+	}
+
+	var request models.Delete
+	if len(body) > maxDeleteBodySize || xml.Unmarshal(body, &request) != nil || len(request.Objects) == 0 || len(request.Objects) > maxDeleteObjects {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "MalformedXML", Message: "Invalid Delete document"})
+		return
+	}
+
+	allowed, err := bucketAccess(r, bucketName)
+	if err != nil {
+		log.Printf("Error reading bucket policy: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading bucket policy"})
+		return
+	}
+	action := func(object models.ObjectIdentifier) string {
+		if object.VersionID != "" {
+			return "s3:DeleteObjectVersion"
+		}
+		return "s3:DeleteObject"
+	}
+
+	var keys []string
+	for _, object := range request.Objects {
+		if object.VersionID == "" && storage.CheckObjectKey(object.Key) == nil && allowed(object.Key, object.VersionID, action(object)) {
+			keys = append(keys, object.Key)
+		}
+	}
+	markers, batchErr := storage.RemoveObjects(bucketName, keys)
+	if batchErr != nil {
+		log.Printf("Error deleting %d objects from %s: %v\n", len(keys), bucketName, batchErr)
+	}
+
+	var result models.DeleteResult
+	for _, object := range request.Objects {
+		var deleted models.DeletedObject
+		switch {
+		case storage.CheckObjectKey(object.Key) != nil:
+			result.Errors = append(result.Errors, models.DeleteError{Key: object.Key, VersionID: object.VersionID, Code: "InvalidArgument", Message: "Invalid object key"})
+			continue
+		case !allowed(object.Key, object.VersionID, action(object)):
+			result.Errors = append(result.Errors, models.DeleteError{Key: object.Key, VersionID: object.VersionID, Code: "AccessDenied", Message: "Access Denied"})
+			continue
+		case object.VersionID != "":
+			removed, err := storage.RemoveObjectVersion(bucketName, object.Key, object.VersionID)
+			if errors.Is(err, storage.ErrNoSuchVersion) {
+				result.Errors = append(result.Errors, models.DeleteError{Key: object.Key, VersionID: object.VersionID, Code: "NoSuchVersion", Message: "Object version not found"})
+				continue
+			}
+			if err != nil {
+				log.Printf("Error deleting version %s of %s/%s: %v\n", object.VersionID, bucketName, object.Key, err)
+				result.Errors = append(result.Errors, models.DeleteError{Key: object.Key, VersionID: object.VersionID, Code: "InternalError", Message: "Error deleting object version"})
+				continue
+			}
+			deleted = models.DeletedObject{Key: object.Key, VersionID: object.VersionID, DeleteMarker: removed.DeleteMarker}
+			if removed.DeleteMarker {
+				deleted.DeleteMarkerVersionID = object.VersionID
+			}
+		case batchErr != nil:
+			result.Errors = append(result.Errors, models.DeleteError{Key: object.Key, Code: "InternalError", Message: "Error deleting object"})
+			continue
+		default:
+			deleted = models.DeletedObject{Key: object.Key}
+			if marker, ok := markers[object.Key]; ok {
+				deleted.DeleteMarker = true
+				deleted.DeleteMarkerVersionID = marker.VersionID
+			}
+		}
+		if !request.Quiet {
+			result.Deleted = append(result.Deleted, deleted)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"triple-s/auth"
+	"triple-s/flags"
+	"triple-s/lifecycle"
+	"triple-s/models"
+	"triple-s/storage"
+)
+
+// bucketLifecycleHandler serves GET, PUT and DELETE /{bucket}?lifecycle.
+// The document is stored as uploaded once it parses; the rules are
+// applied by the lifecycle scanner.
+func bucketLifecycleHandler(w http.ResponseWriter, r *http.Request, bucketName string) {
+	bucket, bucketExists, err := storage.Metadata.GetBucket(bucketName)
+	if err != nil {
+		log.Printf("Error reading bucket metadata: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading bucket metadata"})
+		return
+	}
+	if !bucketExists || !isBucketExists(flags.StorageDir, bucketName) {
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if bucket.Lifecycle == "" {
+			w.WriteHeader(http.StatusNotFound)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Code: "NoSuchLifecycleConfiguration", Message: "The lifecycle configuration does not exist"})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, bucket.Lifecycle)
+	case http.MethodPut:
+		document, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Failed to read request body"})
+			return
+		}
+		if _, err := lifecycle.Parse(document); err != nil {
+			code := "InvalidArgument"
+			if errors.Is(err, lifecycle.ErrMalformed) {
+				code = "MalformedXML"
+			}
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: code, Message: err.Error()})
+			return
+		}
+		if err := storage.SetBucketLifecycle(bucketName, string(document)); err != nil {
+			log.Printf("Error updating lifecycle configuration of %s: %v\n", bucketName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error updating lifecycle configuration"})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	case http.MethodDelete:
+		if err := storage.SetBucketLifecycle(bucketName, ""); err != nil {
+			log.Printf("Error deleting lifecycle configuration of %s: %v\n", bucketName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error deleting lifecycle configuration"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Bad request"})
+	}
+}
+
+// lifecycleStatusHandler serves GET /_admin/lifecycle, the progress of the
+// lifecycle scanner. With authentication it is limited to admins.
+func lifecycleStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if identity, _ := auth.IdentityFrom(r.Context()); auth.Enabled() && !identity.Admin {
+		w.WriteHeader(http.StatusForbidden)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 403, Code: "AccessDenied", Message: "Only admins can see the lifecycle status"})
+		return
+	}
+	status := models.LifecycleStatus{}
+	if lifecycle.Background != nil {
+		status = lifecycle.Background.Status()
+	}
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(status)
+}
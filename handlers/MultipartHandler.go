@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"triple-s/auth"
+	"triple-s/flags"
+	"triple-s/models"
+	"triple-s/storage"
+)
+
+const (
+	defaultMaxParts = 1000
+	// maxCompleteBodySize bounds the CompleteMultipartUpload request, which
+	// lists at most storage.MaxPartNumber parts.
+	maxCompleteBodySize = 1 << 20
+)
+
+// multipartHandler serves the object-level requests that carry ?uploads or
+// ?uploadId.
+func multipartHandler(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) {
+	if !isBucketExists(flags.StorageDir, bucketName) {
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
+		return
+	}
+
+	query := r.URL.Query()
+	uploadID := query.Get("uploadId")
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		initiateMultipartHandler(w, r, bucketName, objectKey)
+	case r.Method == http.MethodPut && uploadID != "":
+		uploadPartHandler(w, r, bucketName, objectKey, uploadID)
+	case r.Method == http.MethodPost && uploadID != "":
+		completeMultipartHandler(w, r, bucketName, objectKey, uploadID)
+	case r.Method == http.MethodDelete && uploadID != "":
+		abortMultipartHandler(w, r, bucketName, objectKey, uploadID)
+	case r.Method == http.MethodGet && uploadID != "":
+		listPartsHandler(w, r, bucketName, objectKey, uploadID)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Bad request"})
+	}
+}
+
+func initiateMultipartHandler(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) {
+	if storage.CheckObjectKey(objectKey) != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Invalid object key"})
+		return
+	}
+
+	acl, ok := readCannedACL(w, r)
+	if !ok {
+		return
+	}
+	tags, ok := readTaggingHeader(w, r)
+	if !ok {
+		return
+	}
+	object := models.ObjectCSV{ObjectKey: objectKey, ContentType: r.Header.Get("Content-Type"), ACL: acl, Tags: tags}
+	if !readObjectHeaders(r, &object) {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: fmt.Sprintf("User metadata exceeds %d bytes", flags.MaxMetadataSize)})
+		return
+	}
+
+	upload, err := storage.CreateMultipartUpload(bucketName, object)
+	if errors.Is(err, storage.ErrBucketNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to initiate upload of %s/%s: %v", bucketName, objectKey, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error initiating multipart upload"})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(models.InitiateMultipartUploadResult{Bucket: bucketName, Key: objectKey, UploadID: upload.ID})
+}
+
+func uploadPartHandler(w http.ResponseWriter, r *http.Request, bucketName, objectKey, uploadID string) {
+	defer r.Body.Close()
+
+	number, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || number < 1 || number > storage.MaxPartNumber {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidArgument", Message: fmt.Sprintf("partNumber must be between 1 and %d", storage.MaxPartNumber)})
+		return
+	}
+	var expected models.ObjectCSV
+	if header, ok := readUploadDigests(r, &expected); !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidDigest", Message: fmt.Sprintf("Invalid %s header", header)})
+		return
+	}
+
+	part, err := storage.UploadPart(bucketName, objectKey, uploadID, number, r.Body, expected)
+	if err != nil {
+		writeMultipartError(w, bucketName, objectKey, err)
+		return
+	}
+
+	w.Header().Set("ETag", quoteETag(part.ETag))
+	for algorithm, value := range part.Checksums {
+		w.Header().Set("x-amz-checksum-"+algorithm, value)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// completeMultipartHandler assembles the object. Copying large parts can
+// outlast the stall timeout, so once it has run for a while the handler
+// commits to 200 OK and sends whitespace until the result, or an error
+// document, is ready. S3 behaves the same way.
+func completeMultipartHandler(w http.ResponseWriter, r *http.Request, bucketName, objectKey, uploadID string) {
+	defer r.Body.Close()
+
+	var request models.CompleteMultipartUpload
+	if err := xml.NewDecoder(io.LimitReader(r.Body, maxCompleteBodySize)).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "MalformedXML", Message: "Invalid CompleteMultipartUpload document"})
+		return
+	}
+	parts := make([]storage.CompletedPart, len(request.Parts))
+	for i, part := range request.Parts {
+		parts[i] = storage.CompletedPart{Number: part.PartNumber, ETag: strings.Trim(part.ETag, `"`)}
+	}
+
+	type result struct {
+		object models.ObjectCSV
+		err    error
+	}
+	done := make(chan result, 1)
+	check := putPrecondition(r)
+	go func() {
+		object, err := storage.CompleteMultipartUpload(bucketName, objectKey, uploadID, parts, check)
+		done <- result{object, err}
+	}()
+
+	ticker := time.NewTicker(max(flags.StallTimeout/3, time.Second))
+	defer ticker.Stop()
+	started := false
+	for {
+		select {
+		case res := <-done:
+			if res.err != nil {
+				if started {
+					// The status line is already out, so the error can
+					// only go in the body.
+					_, response := multipartError(bucketName, objectKey, res.err)
+					xml.NewEncoder(w).Encode(response)
+					return
+				}
+				writeMultipartError(w, bucketName, objectKey, res.err)
+				return
+			}
+			if !started {
+				w.Header().Set("ETag", quoteETag(res.object.ETag))
+				if res.object.VersionID != "" {
+					w.Header().Set("x-amz-version-id", res.object.VersionID)
+				}
+				w.WriteHeader(http.StatusOK)
+			}
+			xml.NewEncoder(w).Encode(models.CompleteMultipartUploadResult{
+				Location: fmt.Sprintf("/%s/%s", bucketName, objectKey),
+				Bucket:   bucketName,
+				Key:      objectKey,
+				ETag:     quoteETag(res.object.ETag),
+			})
+			return
+		case <-ticker.C:
+			if !started {
+				w.WriteHeader(http.StatusOK)
+				io.WriteString(w, xml.Header)
+				started = true
+			}
+			io.WriteString(w, " ")
+			http.NewResponseController(w).Flush()
+		}
+	}
+}
+
+func abortMultipartHandler(w http.ResponseWriter, r *http.Request, bucketName, objectKey, uploadID string) {
+	if err := storage.AbortMultipartUpload(bucketName, objectKey, uploadID); err != nil {
+		writeMultipartError(w, bucketName, objectKey, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func listPartsHandler(w http.ResponseWriter, r *http.Request, bucketName, objectKey, uploadID string) {
+	query := r.URL.Query()
+	maxParts := defaultMaxParts
+	if value := query.Get("max-parts"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Invalid max-parts"})
+			return
+		}
+		maxParts = min(n, defaultMaxParts)
+	}
+	marker := 0
+	if value := query.Get("part-number-marker"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Invalid part-number-marker"})
+			return
+		}
+		marker = n
+	}
+
+	parts, err := storage.ListParts(bucketName, objectKey, uploadID)
+	if err != nil {
+		writeMultipartError(w, bucketName, objectKey, err)
+		return
+	}
+
+	result := models.ListPartsResult{
+		Bucket:           bucketName,
+		Key:              objectKey,
+		UploadID:         uploadID,
+		PartNumberMarker: marker,
+		MaxParts:         maxParts,
+	}
+	for _, part := range parts {
+		if part.Number <= marker {
+			continue
+		}
+		if len(result.Parts) == maxParts {
+			result.IsTruncated = true
+			break
+		}
+		result.Parts = append(result.Parts, models.Part{
+			PartNumber:   part.Number,
+			LastModified: part.LastModified,
+			ETag:         quoteETag(part.ETag),
+			Size:         part.Size,
+		})
+	}
+	if result.IsTruncated {
+		result.NextPartNumberMarker = result.Parts[len(result.Parts)-1].PartNumber
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := xml.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("Error encoding XML response: %v\n", err)
+	}
+}
+
+func writeMultipartError(w http.ResponseWriter, bucketName, objectKey string, err error) {
+	status, response := multipartError(bucketName, objectKey, err)
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(response)
+}
+
+func multipartError(bucketName, objectKey string, err error) (int, models.ErrorResponse) {
+	var authErr *auth.Error
+	switch {
+	case errors.Is(err, storage.ErrBucketNotFound):
+		return http.StatusNotFound, models.ErrorResponse{Status: 404, Message: "Bucket not found"}
+	case errors.Is(err, storage.ErrNoSuchUpload):
+		return http.StatusNotFound, models.ErrorResponse{Status: 404, Code: "NoSuchUpload", Message: "Upload not found"}
+	case errors.Is(err, storage.ErrInvalidPart):
+		return http.StatusBadRequest, models.ErrorResponse{Status: 400, Code: "InvalidPart", Message: err.Error()}
+	case errors.Is(err, storage.ErrInvalidPartOrder):
+		return http.StatusBadRequest, models.ErrorResponse{Status: 400, Code: "InvalidPartOrder", Message: err.Error()}
+	case errors.Is(err, storage.ErrEntityTooSmall):
+		return http.StatusBadRequest, models.ErrorResponse{Status: 400, Code: "EntityTooSmall", Message: err.Error()}
+	case errors.Is(err, storage.ErrBadDigest):
+		return http.StatusBadRequest, models.ErrorResponse{Status: 400, Code: "BadDigest", Message: err.Error()}
+	case errors.Is(err, storage.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed, models.ErrorResponse{Status: 412, Message: "Precondition failed"}
+	case errors.As(err, &authErr):
+		return authErr.Status, models.ErrorResponse{Status: authErr.Status, Code: authErr.Code, Message: authErr.Message}
+	case errors.Is(err, storage.ErrBodyRead):
+		log.Printf("Failed to read upload %s/%s: %v", bucketName, objectKey, err)
+		return http.StatusBadRequest, models.ErrorResponse{Status: 400, Message: "Failed to read object data"}
+	}
+	log.Printf("Multipart upload of %s/%s failed: %v", bucketName, objectKey, err)
+	return http.StatusInternalServerError, models.ErrorResponse{Status: 500, Message: "Error processing multipart upload"}
+}
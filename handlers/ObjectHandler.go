@@ -1,8 +1,11 @@
 package handlers
 
 import (
-	"encoding/csv"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -10,11 +13,13 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"time"
+	"triple-s/auth"
 	"triple-s/flags"
 	"triple-s/models"
 	"triple-s/storage"
-	"triple-s/utils"
 )
 
 func retrieveObjectHandler(w http.ResponseWriter, r *http.Request) {
@@ -25,324 +30,437 @@ func retrieveObjectHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	bucketPath := filepath.Join(flags.StorageDir, bucketName)
-	if !isBucketExists(flags.StorageDir, bucketName) {
+	object, path, ok := lookupObject(w, bucketName, objectKey, r.URL.Query().Get("versionId"))
+	if !ok {
+		return
+	}
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
 		w.WriteHeader(http.StatusNotFound)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Object not found on filesystem"})
 		return
 	}
-	csvPath := filepath.Join(bucketPath, "objects.csv")
-	file, err := os.Open(csvPath)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error opening objects metadata file"})
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Failed to read object data"})
 		return
 	}
 	defer file.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading objects metadata file"})
-		return
+	if object.LastModified.IsZero() {
+		if info, err := file.Stat(); err == nil {
+			object.LastModified = info.ModTime()
+		}
 	}
 
-	objectExists := false
-	for _, record := range records {
-		if len(record) > 0 && record[0] == objectKey {
-			objectExists = true
-			break
+	contentType := objectContentType(object)
+	if contentType == "" {
+		contentType, err = sniffContentType(file)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Failed to read object data"})
+			return
 		}
 	}
+	w.Header().Set("Content-Type", contentType)
+	setObjectHeaders(w, r, object)
+
+	// ServeContent streams from the file and handles Range (single and
+	// multi-part), If-Match, If-None-Match, If-Modified-Since and
+	// If-Unmodified-Since.
+	http.ServeContent(w, r, "", object.LastModified, file)
+}
+
+// headObjectHandler answers from metadata alone; the object file is never
+// opened.
+func headObjectHandler(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) {
+	object, _, ok := lookupObject(w, bucketName, objectKey, r.URL.Query().Get("versionId"))
+	if !ok {
+		return
+	}
 
+	contentType := objectContentType(object)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	setObjectHeaders(w, r, object)
+
+	// ServeContent only seeks a HEAD body to learn its length, so the same
+	// conditional and Range handling as GET applies without any I/O.
+	http.ServeContent(w, r, "", object.LastModified, &metadataBody{size: object.ObjectSize})
+}
+
+// lookupObject checks that the bucket and the object's metadata exist and
+// writes the error response when they do not. An empty versionID selects
+// the current version. It also returns the path of the object's data.
+func lookupObject(w http.ResponseWriter, bucketName, objectKey, versionID string) (models.ObjectCSV, string, bool) {
+	if !isBucketExists(flags.StorageDir, bucketName) {
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
+		return models.ObjectCSV{}, "", false
+	}
+	if versionID != "" {
+		return lookupObjectVersion(w, bucketName, objectKey, versionID)
+	}
+	object, objectExists, err := storage.Metadata.GetObject(bucketName, objectKey)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading objects metadata"})
+		return object, "", false
+	}
 	if !objectExists {
 		w.WriteHeader(http.StatusNotFound)
 		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Object not found in metadata"})
-		return
+		return object, "", false
 	}
+	return object, storage.ObjectPath(bucketName, objectKey), true
+}
 
-	objectPath := filepath.Join(bucketPath, objectKey)
-	if _, err := os.Stat(objectPath); os.IsNotExist(err) {
+func lookupObjectVersion(w http.ResponseWriter, bucketName, objectKey, versionID string) (models.ObjectCSV, string, bool) {
+	object, path, err := storage.GetObjectVersion(bucketName, objectKey, versionID)
+	if errors.Is(err, storage.ErrNoSuchVersion) {
 		w.WriteHeader(http.StatusNotFound)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Object not found on filesystem"})
-		return
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Code: "NoSuchVersion", Message: "Object version not found"})
+		return object, "", false
 	}
-
-	content, err := os.ReadFile(objectPath)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Failed to read object data"})
-		return
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading objects metadata"})
+		return object, "", false
 	}
-
-	contentType := getObjectContentType(objectPath, content)
-	w.Header().Set("Content-Type", contentType)
-	w.Write(content)
+	if object.DeleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+		w.Header().Set("x-amz-version-id", object.VersionID)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 405, Code: "MethodNotAllowed", Message: "The version is a delete marker"})
+		return object, "", false
+	}
+	return object, path, true
 }
 
-func getObjectContentType(objectPath string, content []byte) string {
-	contentType := mime.TypeByExtension(filepath.Ext(objectPath))
-	if contentType == "" {
-		contentType = http.DetectContentType(content)
+// setObjectHeaders adds the metadata-derived headers shared by GET and HEAD.
+// Stored checksums are only returned when the client asks for them with
+// x-amz-checksum-mode: ENABLED.
+func setObjectHeaders(w http.ResponseWriter, r *http.Request, object models.ObjectCSV) {
+	header := w.Header()
+	if object.ETag != "" {
+		header.Set("ETag", quoteETag(object.ETag))
+	}
+	if object.VersionID != "" {
+		header.Set("x-amz-version-id", object.VersionID)
+	}
+	for name, value := range map[string]string{
+		"Cache-Control":       object.CacheControl,
+		"Content-Disposition": object.ContentDisposition,
+		"Content-Encoding":    object.ContentEncoding,
+		"Content-Language":    object.ContentLanguage,
+	} {
+		if value != "" {
+			header.Set(name, value)
+		}
+	}
+	for name, value := range object.UserMetadata {
+		header.Set(userMetadataPrefix+name, value)
+	}
+	if len(object.Tags) > 0 {
+		header.Set("x-amz-tagging-count", strconv.Itoa(len(object.Tags)))
+	}
+	if strings.EqualFold(r.Header.Get("x-amz-checksum-mode"), "ENABLED") {
+		for algorithm, value := range object.Checksums {
+			header.Set("x-amz-checksum-"+algorithm, value)
+		}
 	}
-	return contentType
 }
 
-func updateBucketStatus(bucketName, status string) error {
-	csvPath := filepath.Join(flags.StorageDir, "buckets.csv")
+const userMetadataPrefix = "X-Amz-Meta-"
 
-	file, err := os.OpenFile(csvPath, os.O_RDWR, 0o644)
-	if err != nil {
-		return fmt.Errorf("could not open buckets CSV: %w", err)
+// readObjectHeaders copies the stored request headers into object. It
+// reports false when the x-amz-meta-* headers exceed flags.MaxMetadataSize.
+func readObjectHeaders(r *http.Request, object *models.ObjectCSV) bool {
+	object.CacheControl = r.Header.Get("Cache-Control")
+	object.ContentDisposition = r.Header.Get("Content-Disposition")
+	object.ContentEncoding = r.Header.Get("Content-Encoding")
+	object.ContentLanguage = r.Header.Get("Content-Language")
+
+	size := 0
+	for name, values := range r.Header {
+		if !strings.HasPrefix(name, userMetadataPrefix) || len(name) == len(userMetadataPrefix) {
+			continue
+		}
+		if object.UserMetadata == nil {
+			object.UserMetadata = make(map[string]string)
+		}
+		key := strings.ToLower(strings.TrimPrefix(name, userMetadataPrefix))
+		value := strings.Join(values, ",")
+		object.UserMetadata[key] = value
+		size += len(key) + len(value)
 	}
-	defer file.Close()
+	return size <= flags.MaxMetadataSize
+}
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return fmt.Errorf("could not read buckets CSV: %w", err)
-	}
-
-	updated := false
-	for i, record := range records {
-		if len(record) > 0 && record[0] == bucketName {
-			originalCreationDate := record[1]
-
-			records[i] = []string{
-				bucketName,
-				originalCreationDate,
-				status,
-				time.Now().Format(time.RFC3339),
-			}
-			updated = true
-			break
+// readUploadDigests copies Content-MD5 and the x-amz-checksum-* headers into
+// object as the digests StoreObject must verify. It reports the first
+// header whose value is not a well-formed digest.
+func readUploadDigests(r *http.Request, object *models.ObjectCSV) (string, bool) {
+	if value := r.Header.Get("Content-MD5"); value != "" {
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(sum) != md5.Size {
+			return "Content-MD5", false
 		}
+		object.ETag = hex.EncodeToString(sum)
 	}
+	for _, algorithm := range storage.ChecksumAlgorithms {
+		name := "x-amz-checksum-" + algorithm
+		value := r.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(sum) != storage.ChecksumSize(algorithm) {
+			return name, false
+		}
+		if object.Checksums == nil {
+			object.Checksums = make(map[string]string)
+		}
+		object.Checksums[algorithm] = base64.StdEncoding.EncodeToString(sum)
+	}
+	return "", true
+}
 
-	if !updated {
-		return fmt.Errorf("bucket %s not found in metadata", bucketName)
+// objectContentType prefers the type given at upload and falls back to the
+// key's extension. It returns "" when neither is known.
+func objectContentType(object models.ObjectCSV) string {
+	if object.ContentType != "" {
+		return object.ContentType
 	}
+	return mime.TypeByExtension(filepath.Ext(object.ObjectKey))
+}
 
-	if err := file.Truncate(0); err != nil {
-		return fmt.Errorf("could not truncate file: %w", err)
+// sniffContentType detects the type from the first bytes of the object.
+// file is left at offset 0.
+func sniffContentType(file io.ReadSeeker) (string, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return "", err
 	}
-	if _, err := file.Seek(0, 0); err != nil {
-		return fmt.Errorf("could not seek to beginning of file: %w", err)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
 	}
+	return http.DetectContentType(head[:n]), nil
+}
+
+// metadataBody stands in for an object file of the given size. It can be
+// seeked but holds no data.
+type metadataBody struct {
+	size   int64
+	offset int64
+}
 
-	writer := csv.NewWriter(file)
-	if err := writer.WriteAll(records); err != nil {
-		return fmt.Errorf("could not write updated CSV: %w", err)
+func (b *metadataBody) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		b.offset = offset
+	case io.SeekCurrent:
+		b.offset += offset
+	case io.SeekEnd:
+		b.offset = b.size + offset
 	}
-	writer.Flush()
+	return b.offset, nil
+}
 
-	return writer.Error()
+func (b *metadataBody) Read(p []byte) (int, error) {
+	return 0, io.EOF
 }
 
 func uploadObjectHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/xml")
 
 	bucketName, objectKey, err := splitPath(r.URL.Path)
-	if objectKey == "objects.csv" {
-		w.WriteHeader(http.StatusBadRequest)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Invalid object key"})
-		return
-	}
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Invalid bucket or object key"})
 		return
 	}
 
-	bucketPath := filepath.Join(flags.StorageDir, bucketName)
 	if !isBucketExists(flags.StorageDir, bucketName) {
 		w.WriteHeader(http.StatusNotFound)
 		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
 		return
 	}
 
-	if !utils.IsValidObjectKey(objectKey) {
+	if storage.CheckObjectKey(objectKey) != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Invalid object key"})
 		return
 	}
 
-	content, err := io.ReadAll(r.Body)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Failed to read object data"})
-		return
-	}
 	defer r.Body.Close()
 
-	objectPath := filepath.Join(bucketPath, objectKey)
-	err = os.WriteFile(objectPath, content, 0o644)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error saving object"})
+	acl, ok := readCannedACL(w, r)
+	if !ok {
 		return
 	}
-	fileInfo, err := os.Stat(objectPath)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error retrieving file information"})
+	tags, ok := readTaggingHeader(w, r)
+	if !ok {
 		return
 	}
-
 	contentType := r.Header.Get("Content-Type")
 	csvdata := models.ObjectCSV{
 		ObjectKey:    objectKey,
-		ObjectSize:   fileInfo.Size(),
 		ContentType:  contentType,
+		CreationDate: time.Now(),
 		LastModified: time.Now(),
+		ACL:          acl,
+		Tags:         tags,
 	}
-
-	err = storage.UpdateObjectMetadata(bucketName, csvdata)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error updating metadata"})
+	if !readObjectHeaders(r, &csvdata) {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: fmt.Sprintf("User metadata exceeds %d bytes", flags.MaxMetadataSize)})
+		return
+	}
+	if header, ok := readUploadDigests(r, &csvdata); !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidDigest", Message: fmt.Sprintf("Invalid %s header", header)})
 		return
 	}
 
-	err = updateBucketStatus(bucketName, "active")
+	stored, err := storage.StoreObject(bucketName, csvdata, r.Body, putPrecondition(r))
+	if errors.Is(err, storage.ErrBucketNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
+		return
+	}
+	if errors.Is(err, storage.ErrPreconditionFailed) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 412, Message: "Precondition failed"})
+		return
+	}
+	if errors.Is(err, storage.ErrBadDigest) {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "BadDigest", Message: err.Error()})
+		return
+	}
+	var authErr *auth.Error
+	if errors.As(err, &authErr) {
+		writeAuthError(w, authErr)
+		return
+	}
+	if errors.Is(err, storage.ErrBodyRead) {
+		log.Printf("Failed to read upload %s/%s: %v", bucketName, objectKey, err)
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Failed to read object data"})
+		return
+	}
 	if err != nil {
-		log.Printf("Failed to update bucket metadata: %v", err)
+		log.Printf("Failed to store object %s/%s: %v", bucketName, objectKey, err)
 		w.WriteHeader(http.StatusInternalServerError)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error updating bucket metadata"})
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error saving object"})
 		return
 	}
 
-	updateBucketLastModified(bucketName)
-
+	w.Header().Set("ETag", quoteETag(stored.ETag))
+	if stored.VersionID != "" {
+		w.Header().Set("x-amz-version-id", stored.VersionID)
+	}
 	w.WriteHeader(http.StatusOK)
 	xml.NewEncoder(w).Encode(models.SuccessResponse{Message: fmt.Sprintf("Object %s uploaded successfully", objectKey)})
 }
 
+// putPrecondition turns If-Match and If-None-Match into a check that
+// StoreObject runs atomically with the write. If-None-Match: * makes the
+// PUT create-only; If-Match makes it replace only the version the client
+// last saw.
+func putPrecondition(r *http.Request) storage.Precondition {
+	ifMatch := r.Header.Get("If-Match")
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifMatch == "" && ifNoneMatch == "" {
+		return nil
+	}
+	return func(current *models.ObjectCSV) error {
+		if ifMatch != "" && (current == nil || !etagListMatches(ifMatch, current.ETag)) {
+			return storage.ErrPreconditionFailed
+		}
+		if ifNoneMatch != "" && current != nil && etagListMatches(ifNoneMatch, current.ETag) {
+			return storage.ErrPreconditionFailed
+		}
+		return nil
+	}
+}
+
 func deleteObjectHandler(w http.ResponseWriter, r *http.Request, bucketName, objectName string) {
 	w.Header().Set("Content-Type", "application/xml")
 
-	bucketPath := filepath.Join(flags.StorageDir, bucketName)
 	if !isBucketExists(flags.StorageDir, bucketName) {
 		w.WriteHeader(http.StatusNotFound)
 		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
 		return
 	}
 
-	objectExists, err := checkObjectInCSV(bucketName, objectName)
+	if versionID := r.URL.Query().Get("versionId"); versionID != "" {
+		deleteObjectVersionHandler(w, bucketName, objectName, versionID)
+		return
+	}
+
+	_, objectExists, err := storage.Metadata.GetObject(bucketName, objectName)
 	if err != nil {
-		log.Printf("Error checking object in objects.csv: %v\n", err)
+		log.Printf("Error reading object metadata: %v\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error checking object existence"})
 		return
 	}
 	if !objectExists {
 		w.WriteHeader(http.StatusNotFound)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Object not found in objects.csv"})
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Object not found in metadata"})
 		return
 	}
 
-	objectPath := filepath.Join(bucketPath, objectName)
-	err = os.Remove(objectPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			w.WriteHeader(http.StatusNotFound)
-			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Object not found"})
-		} else {
-			log.Printf("Error deleting object: %v\n", err)
-			w.WriteHeader(http.StatusInternalServerError)
-			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error deleting object"})
-		}
+	marker, err := storage.RemoveObject(bucketName, objectName, nil)
+	if errors.Is(err, storage.ErrInvalidKey) {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Invalid object key"})
 		return
 	}
-
-	isEmpty, err := storage.IsBucketEmptyIgnoringObjectsCSV(bucketPath)
-	if err != nil {
-		log.Printf("Failed to check if bucket is empty: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error checking bucket status"})
+	if errors.Is(err, storage.ErrBucketNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
 		return
 	}
-
-	newStatus := "inactive"
-	if !isEmpty {
-		newStatus = "active"
-	}
-
-	err = updateBucketStatus(bucketName, newStatus)
 	if err != nil {
-		log.Printf("Failed to update bucket metadata: %v", err)
+		log.Printf("Error deleting object %s/%s: %v\n", bucketName, objectName, err)
 		w.WriteHeader(http.StatusInternalServerError)
-		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error updating bucket metadata"})
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error deleting object"})
 		return
 	}
 
-	storage.RemoveObjectMetadata(bucketName, objectName)
+	if marker.DeleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
+		w.Header().Set("x-amz-version-id", marker.VersionID)
+	}
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func checkObjectInCSV(bucketName, objectName string) (bool, error) {
-	csvFilePath := filepath.Join(flags.StorageDir, bucketName, "objects.csv")
-	file, err := os.Open(csvFilePath)
-	if err != nil {
-		return false, err
+// deleteObjectVersionHandler permanently removes one version or delete
+// marker.
+func deleteObjectVersionHandler(w http.ResponseWriter, bucketName, objectName, versionID string) {
+	removed, err := storage.RemoveObjectVersion(bucketName, objectName, versionID)
+	if errors.Is(err, storage.ErrNoSuchVersion) {
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Code: "NoSuchVersion", Message: "Object version not found"})
+		return
 	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
 	if err != nil {
-		return false, err
-	}
-
-	for _, record := range records {
-		if record[0] == objectName { // Assuming ObjectKey is the first column
-			return true, nil
-		}
+		log.Printf("Error deleting version %s of %s/%s: %v\n", versionID, bucketName, objectName, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error deleting object version"})
+		return
 	}
 
-	return false, nil
-}
-
-func updateBucketLastModified(bucketName string) error {
-	csvPath := filepath.Join(flags.StorageDir, "buckets.csv")
-	file, err := os.OpenFile(csvPath, os.O_RDWR, 0o644)
-	if err != nil {
-		return fmt.Errorf("could not open buckets CSV: %v", err)
+	if removed.DeleteMarker {
+		w.Header().Set("x-amz-delete-marker", "true")
 	}
-	defer file.Close()
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return fmt.Errorf("could not read buckets CSV: %v", err)
-	}
-	updated := false
-	for i, record := range records {
-		if len(record) > 0 && record[0] == bucketName {
-			originalCreationDate := record[1]
-			records[i] = []string{
-				bucketName,
-				originalCreationDate,
-				record[2],
-				time.Now().Format(time.RFC3339),
-			}
-			updated = true
-			break
-		}
-	}
-	if !updated {
-		return fmt.Errorf("bucket %s not found in metadata", bucketName)
-	}
-	if err := file.Truncate(0); err != nil {
-		return fmt.Errorf("could not truncate file: %v", err)
-	}
-	if _, err := file.Seek(0, 0); err != nil {
-		return fmt.Errorf("could not seek to beginning of file: %v", err)
-	}
-	writer := csv.NewWriter(file)
-	if err := writer.WriteAll(records); err != nil {
-		return fmt.Errorf("could not write updated CSV: %v", err)
-	}
-	writer.Flush()
-	return writer.Error()
+	w.Header().Set("x-amz-version-id", versionID)
+	w.WriteHeader(http.StatusNoContent)
 }
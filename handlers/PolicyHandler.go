@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"io"
+	"log"
+	"net/http"
+	"triple-s/flags"
+	"triple-s/models"
+	"triple-s/policy"
+	"triple-s/storage"
+)
+
+// bucketPolicyHandler serves GET, PUT and DELETE /{bucket}?policy. The
+// document is JSON, stored as uploaded once it parses.
+func bucketPolicyHandler(w http.ResponseWriter, r *http.Request, bucketName string) {
+	bucket, bucketExists, err := storage.Metadata.GetBucket(bucketName)
+	if err != nil {
+		log.Printf("Error reading bucket metadata: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading bucket metadata"})
+		return
+	}
+	if !bucketExists || !isBucketExists(flags.StorageDir, bucketName) {
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if bucket.Policy == "" {
+			w.WriteHeader(http.StatusNotFound)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Code: "NoSuchBucketPolicy", Message: "The bucket policy does not exist"})
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, bucket.Policy)
+	case http.MethodPut:
+		document, err := io.ReadAll(io.LimitReader(r.Body, policy.MaxSize+1))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Failed to read request body"})
+			return
+		}
+		if _, err := policy.Parse(document, bucketName); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "MalformedPolicy", Message: err.Error()})
+			return
+		}
+		if err := storage.SetBucketPolicy(bucketName, string(document)); err != nil {
+			log.Printf("Error updating policy of %s: %v\n", bucketName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error updating bucket policy"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := storage.SetBucketPolicy(bucketName, ""); err != nil {
+			log.Printf("Error deleting policy of %s: %v\n", bucketName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error deleting bucket policy"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Bad request"})
+	}
+}
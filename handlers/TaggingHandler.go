@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"triple-s/flags"
+	"triple-s/models"
+	"triple-s/storage"
+)
+
+// readTaggingHeader returns the tags of an x-amz-tagging header, which is
+// query-string encoded like "team=data&retention=short", or nil when it
+// is absent. It answers 400 when they are invalid.
+func readTaggingHeader(w http.ResponseWriter, r *http.Request) (map[string]string, bool) {
+	header := r.Header.Get("x-amz-tagging")
+	if header == "" {
+		return nil, true
+	}
+	values, err := url.ParseQuery(header)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidArgument", Message: "Invalid x-amz-tagging header"})
+		return nil, false
+	}
+	tags := make(map[string]string, len(values))
+	for key, list := range values {
+		if len(list) > 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidTag", Message: "Cannot provide multiple Tags with the same key"})
+			return nil, false
+		}
+		tags[key] = list[0]
+	}
+	if err := storage.CheckTags(tags, storage.MaxObjectTags); err != nil {
+		writeTagError(w, err)
+		return nil, false
+	}
+	return tags, true
+}
+
+// readTagSet reads the Tagging document of PUT ?tagging.
+func readTagSet(w http.ResponseWriter, r *http.Request, limit int) (map[string]string, bool) {
+	var tagging models.Tagging
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&tagging); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "MalformedXML", Message: "Invalid Tagging document"})
+		return nil, false
+	}
+	tags := make(map[string]string, len(tagging.TagSet))
+	for _, tag := range tagging.TagSet {
+		if _, ok := tags[tag.Key]; ok {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidTag", Message: "Cannot provide multiple Tags with the same key"})
+			return nil, false
+		}
+		tags[tag.Key] = tag.Value
+	}
+	if err := storage.CheckTags(tags, limit); err != nil {
+		writeTagError(w, err)
+		return nil, false
+	}
+	return tags, true
+}
+
+func writeTagError(w http.ResponseWriter, err error) {
+	code := "InvalidTag"
+	if errors.Is(err, storage.ErrTooManyTags) {
+		code = "BadRequest"
+	}
+	w.WriteHeader(http.StatusBadRequest)
+	xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: code, Message: err.Error()})
+}
+
+// tagging lists tags sorted by key.
+func tagging(tags map[string]string) models.Tagging {
+	result := models.Tagging{TagSet: []models.Tag{}}
+	for key, value := range tags {
+		result.TagSet = append(result.TagSet, models.Tag{Key: key, Value: value})
+	}
+	sort.Slice(result.TagSet, func(a, b int) bool { return result.TagSet[a].Key < result.TagSet[b].Key })
+	return result
+}
+
+// bucketTaggingHandler serves GET, PUT and DELETE /{bucket}?tagging.
+func bucketTaggingHandler(w http.ResponseWriter, r *http.Request, bucketName string) {
+	bucket, bucketExists, err := storage.Metadata.GetBucket(bucketName)
+	if err != nil {
+		log.Printf("Error reading bucket metadata: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading bucket metadata"})
+		return
+	}
+	if !bucketExists || !isBucketExists(flags.StorageDir, bucketName) {
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		if len(bucket.Tags) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Code: "NoSuchTagSet", Message: "The TagSet does not exist"})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		xml.NewEncoder(w).Encode(tagging(bucket.Tags))
+	case http.MethodPut:
+		tags, ok := readTagSet(w, r, storage.MaxBucketTags)
+		if !ok {
+			return
+		}
+		if err := storage.SetBucketTags(bucketName, tags); err != nil {
+			log.Printf("Error updating tags of %s: %v\n", bucketName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error updating bucket tags"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if err := storage.SetBucketTags(bucketName, nil); err != nil {
+			log.Printf("Error deleting tags of %s: %v\n", bucketName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error deleting bucket tags"})
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Bad request"})
+	}
+}
+
+// objectTaggingHandler serves GET, PUT and DELETE
+// /{bucket}/{key}?tagging[&versionId=]. Changing tags does not change the
+// object's LastModified.
+func objectTaggingHandler(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) {
+	versionID := r.URL.Query().Get("versionId")
+	object, _, ok := lookupObject(w, bucketName, objectKey, versionID)
+	if !ok {
+		return
+	}
+
+	var tags map[string]string
+	switch r.Method {
+	case http.MethodGet:
+		if object.VersionID != "" {
+			w.Header().Set("x-amz-version-id", object.VersionID)
+		}
+		w.WriteHeader(http.StatusOK)
+		xml.NewEncoder(w).Encode(tagging(object.Tags))
+		return
+	case http.MethodPut:
+		if tags, ok = readTagSet(w, r, storage.MaxObjectTags); !ok {
+			return
+		}
+	case http.MethodDelete:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Bad request"})
+		return
+	}
+
+	err := storage.SetObjectTags(bucketName, objectKey, versionID, tags)
+	if errors.Is(err, storage.ErrNoSuchObject) || errors.Is(err, storage.ErrNoSuchVersion) {
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Object not found in metadata"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating tags of %s/%s: %v\n", bucketName, objectKey, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error updating object tags"})
+		return
+	}
+	if object.VersionID != "" {
+		w.Header().Set("x-amz-version-id", object.VersionID)
+	}
+	if r.Method == http.MethodDelete {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,169 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"triple-s/auth"
+	"triple-s/models"
+	"triple-s/storage"
+)
+
+// usersHandler serves the user and access key endpoints for admins:
+//
+//	GET    /_admin/users
+//	GET    /_admin/users/{user}
+//	PUT    /_admin/users/{user}[?admin=true]
+//	DELETE /_admin/users/{user}
+//	POST   /_admin/users/{user}/keys
+//	POST   /_admin/users/{user}/keys/{id}?rotate
+//	PUT    /_admin/users/{user}/keys/{id}?status=Active|Inactive
+//	DELETE /_admin/users/{user}/keys/{id}
+func usersHandler(w http.ResponseWriter, r *http.Request, path string) {
+	identity, ok := auth.IdentityFrom(r.Context())
+	if !ok || !identity.Admin {
+		w.WriteHeader(http.StatusForbidden)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 403, Code: "AccessDenied", Message: "Only admins can manage users; create the first one with the users command"})
+		return
+	}
+
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "" && r.Method == http.MethodGet:
+		listUsersHandler(w)
+	case len(parts) == 1 && parts[0] != "":
+		userHandler(w, r, parts[0])
+	case len(parts) == 2 && parts[1] == "keys" && r.Method == http.MethodPost:
+		credential, err := auth.Users.CreateKey(parts[0])
+		writeAccessKey(w, credential, err)
+	case len(parts) == 3 && parts[1] == "keys":
+		accessKeyHandler(w, r, parts[0], parts[2])
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Bad request"})
+	}
+}
+
+func listUsersHandler(w http.ResponseWriter) {
+	users, err := auth.Users.List()
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	result := models.ListUsersResult{Users: []models.User{}}
+	for _, user := range users {
+		result.Users = append(result.Users, userResponse(user))
+	}
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(result)
+}
+
+func userHandler(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		user, err := auth.Users.Get(name)
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		xml.NewEncoder(w).Encode(userResponse(user))
+	case http.MethodPut:
+		user, err := auth.Users.Create(name, r.URL.Query().Get("admin") == "true")
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		xml.NewEncoder(w).Encode(userResponse(user))
+	case http.MethodDelete:
+		owned, err := storage.BucketsOwnedBy(name)
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		if len(owned) > 0 {
+			w.WriteHeader(http.StatusConflict)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 409, Code: "DeleteConflict", Message: fmt.Sprintf("User %s still owns %d buckets", name, len(owned))})
+			return
+		}
+		if err := auth.Users.Delete(name); err != nil {
+			writeUserError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Bad request"})
+	}
+}
+
+func accessKeyHandler(w http.ResponseWriter, r *http.Request, name, accessKeyID string) {
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && query.Has("rotate"):
+		credential, err := auth.Users.RotateKey(name, accessKeyID)
+		writeAccessKey(w, credential, err)
+	case r.Method == http.MethodPut && query.Has("status"):
+		if err := auth.Users.SetKeyStatus(name, accessKeyID, query.Get("status")); err != nil {
+			writeUserError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		xml.NewEncoder(w).Encode(models.SuccessResponse{Message: fmt.Sprintf("Access key %s is %s", accessKeyID, query.Get("status"))})
+	case r.Method == http.MethodDelete:
+		if err := auth.Users.DeleteKey(name, accessKeyID); err != nil {
+			writeUserError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Bad request"})
+	}
+}
+
+func userResponse(user auth.User) models.User {
+	response := models.User{UserName: user.Name, Admin: user.Admin, CreateDate: user.Created}
+	for _, key := range user.Keys {
+		response.AccessKeys = append(response.AccessKeys, models.AccessKeyMetadata{AccessKeyID: key.ID, Status: key.Status, CreateDate: key.Created})
+	}
+	return response
+}
+
+func writeAccessKey(w http.ResponseWriter, credential auth.Credential, err error) {
+	if err != nil {
+		writeUserError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	xml.NewEncoder(w).Encode(models.AccessKey{
+		UserName:        credential.User,
+		AccessKeyID:     credential.AccessKeyID,
+		SecretAccessKey: credential.SecretAccessKey,
+		Status:          auth.KeyActive,
+		CreateDate:      time.Now().UTC().Truncate(time.Second),
+	})
+}
+
+func writeUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidUserName), errors.Is(err, auth.ErrInvalidStatus):
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "InvalidArgument", Message: err.Error()})
+	case errors.Is(err, auth.ErrUserExists):
+		w.WriteHeader(http.StatusConflict)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 409, Code: "EntityAlreadyExists", Message: err.Error()})
+	case errors.Is(err, auth.ErrNoSuchUser), errors.Is(err, auth.ErrNoSuchKey):
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Code: "NoSuchEntity", Message: err.Error()})
+	default:
+		log.Printf("Error managing users: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error managing users"})
+	}
+}
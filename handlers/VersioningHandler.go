@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"encoding/xml"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"triple-s/flags"
+	"triple-s/models"
+	"triple-s/storage"
+)
+
+// bucketVersioningHandler serves GET and PUT /{bucket}?versioning. Once
+// enabled, versioning can only be suspended, not turned off again.
+func bucketVersioningHandler(w http.ResponseWriter, r *http.Request, bucketName string) {
+	bucket, bucketExists, err := storage.Metadata.GetBucket(bucketName)
+	if err != nil {
+		log.Printf("Error reading bucket metadata: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading bucket metadata"})
+		return
+	}
+	if !bucketExists || !isBucketExists(flags.StorageDir, bucketName) {
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		w.WriteHeader(http.StatusOK)
+		xml.NewEncoder(w).Encode(models.VersioningConfiguration{Status: bucket.Versioning})
+	case http.MethodPut:
+		var config models.VersioningConfiguration
+		if err := xml.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&config); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "MalformedXML", Message: "Invalid VersioningConfiguration document"})
+			return
+		}
+		if config.Status != storage.VersioningEnabled && config.Status != storage.VersioningSuspended {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Code: "IllegalVersioningConfigurationException", Message: "Status must be Enabled or Suspended"})
+			return
+		}
+		if err := storage.SetBucketVersioning(bucketName, config.Status); err != nil {
+			log.Printf("Error updating versioning of %s: %v\n", bucketName, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error updating bucket versioning"})
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Bad request"})
+	}
+}
+
+// listVersionsHandler serves GET /{bucket}?versions.
+func listVersionsHandler(w http.ResponseWriter, r *http.Request, bucketName string) {
+	_, bucketExists, err := storage.Metadata.GetBucket(bucketName)
+	if err != nil {
+		log.Printf("Error reading bucket metadata: %v\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading bucket metadata"})
+		return
+	}
+	if !bucketExists || !isBucketExists(flags.StorageDir, bucketName) {
+		w.WriteHeader(http.StatusNotFound)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 404, Message: "Bucket not found"})
+		return
+	}
+
+	query := r.URL.Query()
+	opts := storage.VersionListOptions{
+		Prefix:          query.Get("prefix"),
+		KeyMarker:       query.Get("key-marker"),
+		VersionIDMarker: query.Get("version-id-marker"),
+		MaxKeys:         defaultMaxKeys,
+	}
+	if value := query.Get("max-keys"); value != "" {
+		maxKeys, err := strconv.Atoi(value)
+		if err != nil || maxKeys < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Invalid max-keys"})
+			return
+		}
+		opts.MaxKeys = min(maxKeys, maxMaxKeys)
+	}
+
+	page, err := storage.ListObjectVersions(bucketName, opts)
+	if err != nil {
+		log.Printf("Error listing versions in %s: %v\n", bucketName, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 500, Message: "Error reading objects metadata"})
+		return
+	}
+
+	result := models.ListVersionsResult{
+		Name:                bucketName,
+		Prefix:              opts.Prefix,
+		KeyMarker:           opts.KeyMarker,
+		VersionIDMarker:     opts.VersionIDMarker,
+		NextKeyMarker:       page.NextKeyMarker,
+		NextVersionIDMarker: page.NextVersionIDMarker,
+		MaxKeys:             opts.MaxKeys,
+		IsTruncated:         page.IsTruncated,
+	}
+	for _, version := range page.Versions {
+		if version.DeleteMarker {
+			result.DeleteMarkers = append(result.DeleteMarkers, models.DeleteMarkerEntry{
+				Key:          version.ObjectKey,
+				VersionID:    version.VersionID,
+				IsLatest:     version.IsLatest,
+				LastModified: version.LastModified,
+			})
+			continue
+		}
+		result.Versions = append(result.Versions, models.ObjectVersion{
+			Key:          version.ObjectKey,
+			VersionID:    version.VersionID,
+			IsLatest:     version.IsLatest,
+			LastModified: version.LastModified,
+			ETag:         quoteETag(version.ETag),
+			Size:         version.ObjectSize,
+			StorageClass: "STANDARD",
+		})
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := xml.NewEncoder(w).Encode(result); err != nil {
+		log.Printf("Error encoding XML response: %v\n", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"triple-s/auth"
 	"triple-s/models"
 )
 
@@ -17,7 +18,20 @@ func MyHandler(w http.ResponseWriter, r *http.Request) {
 		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: err.Error()})
 		return
 	}
+	if r.Method == http.MethodOptions {
+		corsPreflightHandler(w, r, bucketName)
+		return
+	}
+	setCORSHeaders(w, r, bucketName)
+	r, ok := authenticate(w, r, true)
+	if !ok {
+		return
+	}
 	if bucketName == "" {
+		if _, ok := auth.IdentityFrom(r.Context()); !ok && auth.Enabled() {
+			writeAuthError(w, auth.ErrAnonymous)
+			return
+		}
 		switch r.Method {
 		case http.MethodGet:
 			listBucketsHandler(w, r)
@@ -25,40 +39,132 @@ func MyHandler(w http.ResponseWriter, r *http.Request) {
 			w.WriteHeader(http.StatusBadRequest)
 			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Bad request"})
 		}
-	} else if objectName == "" {
+		return
+	}
+
+	handler, action := route(r, bucketName, objectName)
+	if handler == nil {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 405, Code: "MethodNotAllowed", Message: "The specified method is not allowed against this resource"})
+		return
+	}
+	if action != batchDelete && !authorize(w, r, bucketName, objectName, r.URL.Query().Get("versionId"), action) {
+		return
+	}
+	handler(w, r)
+}
+
+// batchDelete is the action of POST /{bucket}?delete, which
+// deleteObjectsHandler checks key by key.
+const batchDelete = "batch-delete"
+
+// route picks the handler of a request to a bucket or an object together
+// with the action it performs, as used in bucket policies. Both come from
+// the same table so a request can never reach a handler under another
+// request's action. The handler is nil for requests nothing serves.
+func route(r *http.Request, bucketName, objectKey string) (http.HandlerFunc, string) {
+	query := r.URL.Query()
+	onBucket := func(handler func(http.ResponseWriter, *http.Request, string), actions map[string]string) (http.HandlerFunc, string) {
+		action, ok := actions[r.Method]
+		if !ok {
+			return nil, ""
+		}
+		return func(w http.ResponseWriter, r *http.Request) { handler(w, r, bucketName) }, action
+	}
+	onObject := func(handler func(http.ResponseWriter, *http.Request, string, string), action string) (http.HandlerFunc, string) {
+		return func(w http.ResponseWriter, r *http.Request) { handler(w, r, bucketName, objectKey) }, action
+	}
+
+	if objectKey == "" {
+		switch {
+		case query.Has("acl"):
+			return onBucket(bucketACLHandler, map[string]string{http.MethodGet: "s3:GetBucketAcl", http.MethodPut: "s3:PutBucketAcl"})
+		case query.Has("cors"):
+			return onBucket(bucketCORSHandler, map[string]string{http.MethodGet: "s3:GetBucketCORS", http.MethodPut: "s3:PutBucketCORS", http.MethodDelete: "s3:PutBucketCORS"})
+		case query.Has("lifecycle"):
+			return onBucket(bucketLifecycleHandler, map[string]string{http.MethodGet: "s3:GetLifecycleConfiguration", http.MethodPut: "s3:PutLifecycleConfiguration", http.MethodDelete: "s3:PutLifecycleConfiguration"})
+		case query.Has("tagging"):
+			return onBucket(bucketTaggingHandler, map[string]string{http.MethodGet: "s3:GetBucketTagging", http.MethodPut: "s3:PutBucketTagging", http.MethodDelete: "s3:PutBucketTagging"})
+		case query.Has("policy"):
+			return onBucket(bucketPolicyHandler, map[string]string{http.MethodGet: "s3:GetBucketPolicy", http.MethodPut: "s3:PutBucketPolicy", http.MethodDelete: "s3:DeleteBucketPolicy"})
+		case query.Has("versioning"):
+			return onBucket(bucketVersioningHandler, map[string]string{http.MethodGet: "s3:GetBucketVersioning", http.MethodPut: "s3:PutBucketVersioning"})
+		case query.Has("versions"):
+			return onBucket(listVersionsHandler, map[string]string{http.MethodGet: "s3:ListBucketVersions"})
+		case query.Has("delete"):
+			return onBucket(deleteObjectsHandler, map[string]string{http.MethodPost: batchDelete})
+		}
 		switch r.Method {
+		case http.MethodGet:
+			return onBucket(listObjectsHandler, map[string]string{http.MethodGet: "s3:ListBucket"})
+		case http.MethodHead:
+			return onBucket(headBucketHandler, map[string]string{http.MethodHead: "s3:ListBucket"})
 		case http.MethodPut:
-			createBucketHandler(w, r, bucketName)
+			return onBucket(createBucketHandler, map[string]string{http.MethodPut: "s3:CreateBucket"})
 		case http.MethodDelete:
-			deleteBucketHandler(w, r, bucketName)
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Bad request"})
+			return onBucket(deleteBucketHandler, map[string]string{http.MethodDelete: "s3:DeleteBucket"})
 		}
-	} else {
+		return nil, ""
+	}
+
+	versioned := func(action string) string {
+		if query.Has("versionId") {
+			return action + "Version"
+		}
+		return action
+	}
+	switch {
+	case query.Has("acl"):
+		switch r.Method {
+		case http.MethodGet:
+			return onObject(objectACLHandler, "s3:GetObjectAcl")
+		case http.MethodPut:
+			return onObject(objectACLHandler, "s3:PutObjectAcl")
+		}
+	case query.Has("tagging"):
 		switch r.Method {
 		case http.MethodGet:
-			retrieveObjectHandler(w, r)
+			return onObject(objectTaggingHandler, versioned("s3:GetObject")+"Tagging")
 		case http.MethodPut:
-			uploadObjectHandler(w, r)
+			return onObject(objectTaggingHandler, versioned("s3:PutObject")+"Tagging")
 		case http.MethodDelete:
-			deleteObjectHandler(w, r, bucketName, objectName)
-		default:
-			w.WriteHeader(http.StatusBadRequest)
-			xml.NewEncoder(w).Encode(models.ErrorResponse{Status: 400, Message: "Bad request"})
+			return onObject(objectTaggingHandler, versioned("s3:DeleteObject")+"Tagging")
+		}
+	case query.Has("uploads") || query.Has("uploadId"):
+		switch {
+		case r.Method == http.MethodPost && query.Has("uploads"), r.Method == http.MethodPut && query.Has("uploadId"), r.Method == http.MethodPost && query.Has("uploadId"):
+			return onObject(multipartHandler, "s3:PutObject")
+		case r.Method == http.MethodDelete && query.Has("uploadId"):
+			return onObject(multipartHandler, "s3:AbortMultipartUpload")
+		case r.Method == http.MethodGet && query.Has("uploadId"):
+			return onObject(multipartHandler, "s3:ListMultipartUploadParts")
+		}
+	default:
+		switch r.Method {
+		case http.MethodGet:
+			return retrieveObjectHandler, versioned("s3:GetObject")
+		case http.MethodHead:
+			return onObject(headObjectHandler, versioned("s3:GetObject"))
+		case http.MethodPut:
+			if r.Header.Get("x-amz-copy-source") != "" {
+				return onObject(copyObjectHandler, "s3:PutObject")
+			}
+			return uploadObjectHandler, "s3:PutObject"
+		case http.MethodDelete:
+			return onObject(deleteObjectHandler, versioned("s3:DeleteObject"))
 		}
 	}
+	return nil, ""
 }
 
+// splitPath returns the bucket name and the object key. Everything after
+// the bucket, slashes included, is the key.
 func splitPath(path string) (string, string, error) {
-	components := strings.Split(strings.Trim(path, "/"), "/")
-
-	if len(components) == 1 {
-		return components[0], "", nil
-	} else if len(components) > 1 {
-		return components[0], components[1], nil
+	bucketName, objectKey, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if bucketName == "" && objectKey != "" {
+		return "", "", fmt.Errorf("invalid path, no bucket name provided")
 	}
-	return "", "", fmt.Errorf("invalid path, no bucket name provided")
+	return bucketName, objectKey, nil
 }
 
 func isBucketExists(baseDir, bucketName string) bool {
@@ -67,8 +173,24 @@ func isBucketExists(baseDir, bucketName string) bool {
 	return !os.IsNotExist(err)
 }
 
-func islowercaseLetterorDigit(char byte) bool {
-	return (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9')
+func quoteETag(etag string) string {
+	return `"` + etag + `"`
+}
+
+// etagListMatches reports whether an If-Match or If-None-Match value names
+// etag. "*" matches any existing object; weak validators compare equal.
+func etagListMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if etag != "" && strings.Trim(candidate, `"`) == etag {
+			return true
+		}
+	}
+	return false
 }
 
 func RootHandler(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,886 @@
+package handlers
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"triple-s/auth"
+	"triple-s/flags"
+	"triple-s/models"
+	"triple-s/storage"
+)
+
+// setupStorage points the server at an empty storage directory with
+// authentication on, one admin "root" and one user "alice".
+func setupStorage(t *testing.T) {
+	t.Helper()
+	previousDir, previousCredentials := flags.StorageDir, auth.Credentials
+	flags.StorageDir, flags.MetadataBackend, flags.MaxMetadataSize, flags.Region = t.TempDir(), storage.BackendCSV, 2048, "us-east-1"
+	if err := storage.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	credentialsPath := filepath.Join(t.TempDir(), "credentials")
+	credentials := "[root]\naws_access_key_id = ROOTKEY\naws_secret_access_key = rootsecret\nadmin = true\n" +
+		"[alice]\naws_access_key_id = ALICEKEY\naws_secret_access_key = alicesecret\n"
+	if err := os.WriteFile(credentialsPath, []byte(credentials), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := auth.LoadCredentials(credentialsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth.Credentials = store
+
+	t.Cleanup(func() {
+		storage.Metadata.Close()
+		storage.Journal.Close()
+		flags.StorageDir, auth.Credentials = previousDir, previousCredentials
+	})
+}
+
+func createBucket(t *testing.T, name, owner, acl string) {
+	t.Helper()
+	now := time.Now()
+	bucket := models.Bucket{Name: name, CreationDate: now, LastModified: now, ContentStatus: "inactive", Owner: owner, ACL: acl}
+	if err := storage.CreateBucket(bucket); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// asUser returns r as if it had been signed by user, or r itself for "".
+// "root" is an admin.
+func asUser(r *http.Request, user string) *http.Request {
+	if user == "" {
+		return r
+	}
+	identity := auth.Identity{AccessKeyID: strings.ToUpper(user) + "KEY", User: user, Admin: user == "root"}
+	return r.WithContext(auth.WithIdentity(r.Context(), identity))
+}
+
+func TestRoute(t *testing.T) {
+	tests := []struct {
+		method, target string
+		copySource     bool
+		action         string // "" means no handler
+	}{
+		{"GET", "/b", false, "s3:ListBucket"},
+		{"HEAD", "/b", false, "s3:ListBucket"},
+		{"PUT", "/b", false, "s3:CreateBucket"},
+		{"DELETE", "/b", false, "s3:DeleteBucket"},
+		{"POST", "/b", false, ""},
+		{"PATCH", "/b", false, ""},
+
+		{"GET", "/b?versions", false, "s3:ListBucketVersions"},
+		{"PUT", "/b?versions", false, ""},
+		{"DELETE", "/b?versions", false, ""},
+		{"HEAD", "/b?versions", false, ""},
+		{"POST", "/b?delete", false, batchDelete},
+		{"GET", "/b?delete", false, ""},
+		{"PUT", "/b?delete", false, ""},
+		{"DELETE", "/b?delete", false, ""},
+		{"HEAD", "/b?delete", false, ""},
+		{"POST", "/b?versions&delete", false, ""},
+
+		{"GET", "/b?acl", false, "s3:GetBucketAcl"},
+		{"PUT", "/b?acl", false, "s3:PutBucketAcl"},
+		{"DELETE", "/b?acl", false, ""},
+		{"GET", "/b?cors", false, "s3:GetBucketCORS"},
+		{"DELETE", "/b?cors", false, "s3:PutBucketCORS"},
+		{"POST", "/b?cors", false, ""},
+		{"PUT", "/b?lifecycle", false, "s3:PutLifecycleConfiguration"},
+		{"DELETE", "/b?lifecycle", false, "s3:PutLifecycleConfiguration"},
+		{"GET", "/b?tagging", false, "s3:GetBucketTagging"},
+		{"DELETE", "/b?tagging", false, "s3:PutBucketTagging"},
+		{"DELETE", "/b?policy", false, "s3:DeleteBucketPolicy"},
+		{"PUT", "/b?versioning", false, "s3:PutBucketVersioning"},
+		{"DELETE", "/b?versioning", false, ""},
+
+		{"GET", "/b/k", false, "s3:GetObject"},
+		{"GET", "/b/k?versionId=1", false, "s3:GetObjectVersion"},
+		{"HEAD", "/b/k", false, "s3:GetObject"},
+		{"PUT", "/b/k", false, "s3:PutObject"},
+		{"PUT", "/b/k", true, "s3:PutObject"},
+		{"DELETE", "/b/k", false, "s3:DeleteObject"},
+		{"DELETE", "/b/k?versionId=1", false, "s3:DeleteObjectVersion"},
+		{"POST", "/b/k", false, ""},
+		{"POST", "/b/k?delete", false, ""},
+
+		{"GET", "/b/k?acl", false, "s3:GetObjectAcl"},
+		{"PUT", "/b/k?acl", false, "s3:PutObjectAcl"},
+		{"POST", "/b/k?acl", false, ""},
+		{"DELETE", "/b/k?acl", false, ""},
+		{"GET", "/b/k?tagging", false, "s3:GetObjectTagging"},
+		{"PUT", "/b/k?tagging&versionId=1", false, "s3:PutObjectVersionTagging"},
+		{"DELETE", "/b/k?tagging", false, "s3:DeleteObjectTagging"},
+		{"POST", "/b/k?tagging", false, ""},
+
+		{"POST", "/b/k?uploads", false, "s3:PutObject"},
+		{"GET", "/b/k?uploads", false, ""},
+		{"PUT", "/b/k?uploadId=u&partNumber=1", false, "s3:PutObject"},
+		{"POST", "/b/k?uploadId=u", false, "s3:PutObject"},
+		{"DELETE", "/b/k?uploadId=u", false, "s3:AbortMultipartUpload"},
+		{"GET", "/b/k?uploadId=u", false, "s3:ListMultipartUploadParts"},
+		{"HEAD", "/b/k?uploadId=u", false, ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.target, nil)
+		if test.copySource {
+			r.Header.Set("x-amz-copy-source", "/a/k")
+		}
+		bucketName, objectKey, _ := splitPath(r.URL.Path)
+		handler, action := route(r, bucketName, objectKey)
+		if test.action == "" {
+			if handler != nil {
+				t.Errorf("%s %s: routed to a handler with action %q, want none", test.method, test.target, action)
+			}
+			continue
+		}
+		if handler == nil || action != test.action {
+			t.Errorf("%s %s: got action %q (handler %v), want %q", test.method, test.target, action, handler != nil, test.action)
+		}
+	}
+}
+
+// TestAnonymousRequests sends unsigned requests through MyHandler against
+// a private bucket and buckets with each public canned ACL.
+func TestAnonymousRequests(t *testing.T) {
+	setupStorage(t)
+	createBucket(t, "private-bucket", "alice", storage.ACLPrivate)
+	createBucket(t, "public-bucket", "alice", storage.ACLPublicRead)
+	createBucket(t, "open-bucket", "alice", storage.ACLPublicReadWrite)
+
+	tests := []struct {
+		method, target string
+		status         int
+	}{
+		{"GET", "/private-bucket", http.StatusForbidden},
+		{"GET", "/private-bucket?delete", http.StatusMethodNotAllowed},
+		{"DELETE", "/private-bucket?delete", http.StatusMethodNotAllowed},
+		{"PUT", "/private-bucket?delete", http.StatusMethodNotAllowed},
+		{"GET", "/private-bucket?versions", http.StatusForbidden},
+		{"PUT", "/private-bucket/k", http.StatusForbidden},
+
+		{"GET", "/public-bucket", http.StatusOK},
+		{"HEAD", "/public-bucket", http.StatusOK},
+		{"GET", "/public-bucket?versions", http.StatusOK},
+		{"DELETE", "/public-bucket?versions", http.StatusMethodNotAllowed},
+		{"PUT", "/public-bucket?versions", http.StatusMethodNotAllowed},
+		{"DELETE", "/public-bucket", http.StatusForbidden},
+		{"PUT", "/public-bucket/k", http.StatusForbidden},
+		{"GET", "/public-bucket?acl", http.StatusForbidden},
+		{"PUT", "/public-bucket?policy", http.StatusForbidden},
+
+		{"PUT", "/open-bucket/k", http.StatusOK},
+		{"DELETE", "/open-bucket", http.StatusForbidden},
+		{"DELETE", "/open-bucket?versions", http.StatusMethodNotAllowed},
+		{"PUT", "/open-bucket?acl", http.StatusForbidden},
+		{"PUT", "/open-bucket/k?acl", http.StatusForbidden},
+		{"PUT", "/open-bucket/k?tagging", http.StatusForbidden},
+		{"DELETE", "/open-bucket/k", http.StatusNoContent},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		MyHandler(w, httptest.NewRequest(test.method, test.target, strings.NewReader("data")))
+		if w.Code != test.status {
+			t.Errorf("%s %s: got %d, want %d: %s", test.method, test.target, w.Code, test.status, w.Body.String())
+		}
+	}
+
+	for _, name := range []string{"private-bucket", "public-bucket", "open-bucket"} {
+		if _, exists, err := storage.Metadata.GetBucket(name); err != nil || !exists {
+			t.Errorf("bucket %s is gone (%v)", name, err)
+		}
+	}
+}
+
+func TestBucketAccess(t *testing.T) {
+	setupStorage(t)
+	createBucket(t, "alice-bucket", "alice", storage.ACLPublicReadWrite)
+	createBucket(t, "legacy-bucket", "", storage.ACLPrivate)
+	policy := `{"Version":"2012-10-17","Statement":[
+		{"Effect":"Deny","Principal":"*","Action":"s3:DeleteObject","Resource":"arn:aws:s3:::alice-bucket/locked/*"},
+		{"Effect":"Allow","Principal":{"AWS":["bob"]},"Action":"s3:GetBucketAcl","Resource":"arn:aws:s3:::alice-bucket"}]}`
+	if err := storage.SetBucketPolicy("alice-bucket", policy); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		user, bucket, method, key, action string
+		allowed                           bool
+	}{
+		{"alice", "alice-bucket", "DELETE", "", "s3:DeleteBucket", true},
+		{"alice", "alice-bucket", "DELETE", "locked/k", "s3:DeleteObject", false},
+		{"alice", "alice-bucket", "PUT", "", "s3:PutBucketPolicy", true},
+		{"bob", "alice-bucket", "GET", "", "s3:GetBucketAcl", true},
+		{"bob", "alice-bucket", "PUT", "", "s3:PutBucketAcl", false},
+		{"bob", "alice-bucket", "DELETE", "", "s3:DeleteBucket", false},
+		{"bob", "alice-bucket", "PUT", "", "s3:PutBucketPolicy", false},
+
+		// public-read-write grants listing and object writes only for
+		// the requests they describe.
+		{"", "alice-bucket", "GET", "", "s3:ListBucket", true},
+		{"", "alice-bucket", "DELETE", "", "s3:ListBucket", false},
+		{"", "alice-bucket", "GET", "k", "s3:ListBucket", false},
+		{"", "alice-bucket", "PUT", "k", "s3:PutObject", true},
+		{"", "alice-bucket", "POST", "k", "s3:PutObject", true},
+		{"", "alice-bucket", "PUT", "", "s3:PutObject", false},
+		{"", "alice-bucket", "GET", "k", "s3:PutObject", false},
+		{"", "alice-bucket", "DELETE", "k", "s3:DeleteObject", true},
+		{"", "alice-bucket", "DELETE", "locked/k", "s3:DeleteObject", false},
+		{"", "alice-bucket", "DELETE", "", "s3:DeleteObject", false},
+		{"", "alice-bucket", "DELETE", "", "s3:DeleteBucket", false},
+		{"", "alice-bucket", "GET", "", "s3:GetBucketAcl", false},
+
+		{"root", "legacy-bucket", "DELETE", "", "s3:DeleteBucket", true},
+		{"alice", "legacy-bucket", "GET", "", "s3:ListBucket", false},
+		{"", "legacy-bucket", "GET", "", "s3:ListBucket", false},
+		{"alice", "missing-bucket", "GET", "", "s3:ListBucket", true},
+		{"", "missing-bucket", "GET", "", "s3:ListBucket", false},
+	}
+	for _, test := range tests {
+		r := asUser(httptest.NewRequest(test.method, "/"+test.bucket, nil), test.user)
+		allowed, err := bucketAccess(r, test.bucket)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := allowed(test.key, "", test.action); got != test.allowed {
+			t.Errorf("%q %s %s/%s %s: got %v, want %v", test.user, test.method, test.bucket, test.key, test.action, got, test.allowed)
+		}
+	}
+}
+
+func TestListObjectsMaxKeys(t *testing.T) {
+	setupStorage(t)
+	createBucket(t, "empty-bucket", "alice", storage.ACLPublicRead)
+	createBucket(t, "public-bucket", "alice", storage.ACLPublicRead)
+	for _, key := range []string{"a", "b"} {
+		if _, err := storage.StoreObject("public-bucket", models.ObjectCSV{ObjectKey: key}, strings.NewReader(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := func(target string) models.ListBucketResult {
+		t.Helper()
+		w := httptest.NewRecorder()
+		MyHandler(w, httptest.NewRequest(http.MethodGet, target, nil))
+		var result models.ListBucketResult
+		if err := xml.NewDecoder(w.Body).Decode(&result); w.Code != http.StatusOK || err != nil {
+			t.Fatalf("GET %s: got %d (%v)", target, w.Code, err)
+		}
+		return result
+	}
+
+	if result := list("/empty-bucket?max-keys=0"); result.IsTruncated || result.NextContinuationToken != "" {
+		t.Errorf("empty bucket: got truncated %v, token %q", result.IsTruncated, result.NextContinuationToken)
+	}
+	result := list("/public-bucket?max-keys=0")
+	if result.KeyCount != 0 || !result.IsTruncated || result.NextContinuationToken != "" {
+		t.Errorf("first page: got %d keys, truncated %v, token %q", result.KeyCount, result.IsTruncated, result.NextContinuationToken)
+	}
+
+	token := list("/public-bucket?max-keys=1").NextContinuationToken
+	result = list("/public-bucket?max-keys=0&continuation-token=" + token)
+	if result.KeyCount != 0 || !result.IsTruncated || result.NextContinuationToken != token {
+		t.Errorf("later page: got %d keys, truncated %v, token %q, want %q", result.KeyCount, result.IsTruncated, result.NextContinuationToken, token)
+	}
+	if result := list("/public-bucket?continuation-token=" + token); len(result.Contents) != 1 || result.Contents[0].Key != "b" {
+		t.Errorf("continuing: got %v, want b", result.Contents)
+	}
+}
+
+func TestDeleteBucketWithUploads(t *testing.T) {
+	setupStorage(t)
+	createBucket(t, "bucket", "alice", storage.ACLPrivate)
+	upload, err := storage.CreateMultipartUpload("bucket", models.ObjectCSV{ObjectKey: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	deleteBucketHandler(w, asUser(httptest.NewRequest(http.MethodDelete, "/bucket", nil), "alice"), "bucket")
+	if w.Code != http.StatusConflict || !strings.Contains(w.Body.String(), "BucketNotEmpty") {
+		t.Fatalf("got %d: %s, want 409 BucketNotEmpty", w.Code, w.Body.String())
+	}
+	if err := storage.AbortMultipartUpload("bucket", "k", upload.ID); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	deleteBucketHandler(w, asUser(httptest.NewRequest(http.MethodDelete, "/bucket", nil), "alice"), "bucket")
+	if w.Code != http.StatusNoContent {
+		t.Errorf("after the abort: got %d: %s", w.Code, w.Body.String())
+	}
+}
+
+func TestACLAllows(t *testing.T) {
+	setupStorage(t)
+	acls := []string{storage.ACLPrivate, storage.ACLPublicRead, storage.ACLPublicReadWrite, storage.ACLAuthenticatedRead}
+	buckets := make([]models.Bucket, len(acls))
+	for i, acl := range acls {
+		createBucket(t, acl+"-bucket", "alice", acl)
+		if _, err := storage.StoreObject(acl+"-bucket", models.ObjectCSV{ObjectKey: "k", ACL: acl}, strings.NewReader("k"), nil); err != nil {
+			t.Fatal(err)
+		}
+		bucket, _, err := storage.Metadata.GetBucket(acl + "-bucket")
+		if err != nil {
+			t.Fatal(err)
+		}
+		buckets[i] = bucket
+	}
+
+	// allowed is in the order of acls: private, public-read,
+	// public-read-write, authenticated-read.
+	tests := []struct {
+		method, key, action string
+		authenticated       bool
+		copySource          bool
+		allowed             [4]bool
+	}{
+		{"GET", "", "s3:ListBucket", false, false, [4]bool{false, true, true, false}},
+		{"GET", "", "s3:ListBucket", true, false, [4]bool{false, true, true, true}},
+		{"HEAD", "", "s3:ListBucketVersions", false, false, [4]bool{false, true, true, false}},
+		{"PUT", "", "s3:ListBucket", true, false, [4]bool{}},
+		{"GET", "k", "s3:ListBucket", true, false, [4]bool{}},
+
+		{"GET", "k", "s3:GetObject", false, false, [4]bool{false, true, true, false}},
+		{"GET", "k", "s3:GetObject", true, false, [4]bool{false, true, true, true}},
+		{"HEAD", "k", "s3:GetObject", false, false, [4]bool{false, true, true, false}},
+		{"PUT", "k", "s3:GetObject", false, true, [4]bool{false, true, true, false}},
+		{"PUT", "k", "s3:GetObject", false, false, [4]bool{}},
+		{"DELETE", "k", "s3:GetObject", true, false, [4]bool{}},
+		{"GET", "missing", "s3:GetObject", true, false, [4]bool{}},
+		{"GET", "", "s3:GetObject", true, false, [4]bool{}},
+
+		{"PUT", "k", "s3:PutObject", false, false, [4]bool{false, false, true, false}},
+		{"POST", "k", "s3:PutObject", true, false, [4]bool{false, false, true, false}},
+		{"GET", "k", "s3:PutObject", true, false, [4]bool{}},
+		{"PUT", "", "s3:PutObject", true, false, [4]bool{}},
+		{"DELETE", "k", "s3:DeleteObject", false, false, [4]bool{false, false, true, false}},
+		{"POST", "k", "s3:DeleteObject", false, false, [4]bool{false, false, true, false}},
+		{"DELETE", "k", "s3:DeleteObjectVersion", true, false, [4]bool{false, false, true, false}},
+		{"DELETE", "", "s3:DeleteObject", true, false, [4]bool{}},
+		{"DELETE", "k", "s3:AbortMultipartUpload", false, false, [4]bool{false, false, true, false}},
+		{"POST", "k", "s3:AbortMultipartUpload", false, false, [4]bool{}},
+		{"GET", "k", "s3:ListMultipartUploadParts", false, false, [4]bool{false, false, true, false}},
+
+		// Canned ACLs never grant bucket configuration.
+		{"GET", "", "s3:GetBucketAcl", true, false, [4]bool{}},
+		{"PUT", "", "s3:PutBucketAcl", true, false, [4]bool{}},
+		{"DELETE", "", "s3:DeleteBucket", true, false, [4]bool{}},
+		{"GET", "", "s3:GetBucketPolicy", true, false, [4]bool{}},
+	}
+	for _, test := range tests {
+		for i, bucket := range buckets {
+			r := httptest.NewRequest(test.method, "/"+bucket.Name+"/"+test.key, nil)
+			if test.copySource {
+				r.Header.Set("x-amz-copy-source", "/"+bucket.Name+"/k")
+			}
+			if got := aclAllows(r, bucket, test.key, "", test.action, test.authenticated); got != test.allowed[i] {
+				t.Errorf("%s: %s /%s %s (authenticated %v): got %v, want %v", acls[i], test.method, test.key, test.action, test.authenticated, got, test.allowed[i])
+			}
+		}
+	}
+}
+
+func TestCopyObjectHandler(t *testing.T) {
+	setupStorage(t)
+	createBucket(t, "bucket", "alice", storage.ACLPrivate)
+	source := models.ObjectCSV{ObjectKey: "source", ContentType: "text/plain", CacheControl: "no-cache", UserMetadata: map[string]string{"color": "red"}}
+	source, err := storage.StoreObject("bucket", source, strings.NewReader("data"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, key string
+		headers   map[string]string
+		status    int
+		want      models.ObjectCSV
+	}{
+		{"copy", "copied", nil, http.StatusOK,
+			models.ObjectCSV{ContentType: "text/plain", CacheControl: "no-cache", UserMetadata: map[string]string{"color": "red"}}},
+		{"replace", "replaced", map[string]string{"x-amz-metadata-directive": "REPLACE", "Content-Type": "text/csv", "x-amz-meta-shape": "round"}, http.StatusOK,
+			models.ObjectCSV{ContentType: "text/csv", UserMetadata: map[string]string{"shape": "round"}}},
+		{"if-match holds", "matched", map[string]string{"x-amz-copy-source-if-match": `"` + source.ETag + `"`}, http.StatusOK,
+			models.ObjectCSV{ContentType: "text/plain", CacheControl: "no-cache", UserMetadata: map[string]string{"color": "red"}}},
+		{"if-match fails", "unmatched", map[string]string{"x-amz-copy-source-if-match": `"0123"`}, http.StatusPreconditionFailed, models.ObjectCSV{}},
+		{"onto itself", "source", nil, http.StatusBadRequest, models.ObjectCSV{}},
+	}
+	for _, test := range tests {
+		r := asUser(httptest.NewRequest(http.MethodPut, "/bucket/"+test.key, nil), "alice")
+		r.Header.Set("x-amz-copy-source", "/bucket/source")
+		for name, value := range test.headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		copyObjectHandler(w, r, "bucket", test.key)
+		if w.Code != test.status {
+			t.Errorf("%s: got %d: %s", test.name, w.Code, w.Body.String())
+			continue
+		}
+
+		object, ok, err := storage.Metadata.GetObject("bucket", test.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.status != http.StatusOK {
+			if test.key != "source" && ok {
+				t.Errorf("%s: the failed copy was stored", test.name)
+			}
+			continue
+		}
+		if !ok || object.ETag != source.ETag || object.ContentType != test.want.ContentType || object.CacheControl != test.want.CacheControl ||
+			!maps.Equal(object.UserMetadata, test.want.UserMetadata) {
+			t.Errorf("%s: got %+v", test.name, object)
+		}
+	}
+}
+
+func TestDeleteObjects(t *testing.T) {
+	setupStorage(t)
+	createBucket(t, "bucket", "alice", storage.ACLPrivate)
+	createBucket(t, "versioned", "alice", storage.ACLPrivate)
+	if err := storage.SetBucketVersioning("versioned", storage.VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+	policy := `{"Version":"2012-10-17","Statement":{"Effect":"Deny","Principal":"*","Action":"s3:DeleteObject","Resource":"arn:aws:s3:::bucket/locked/*"}}`
+	if err := storage.SetBucketPolicy("bucket", policy); err != nil {
+		t.Fatal(err)
+	}
+	store := func(bucketName string, keys ...string) {
+		t.Helper()
+		for _, key := range keys {
+			if _, err := storage.StoreObject(bucketName, models.ObjectCSV{ObjectKey: key}, strings.NewReader(key), nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	deleteObjects := func(bucketName string, request models.Delete) (int, models.DeleteResult) {
+		t.Helper()
+		body, err := xml.Marshal(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		deleteObjectsHandler(w, asUser(httptest.NewRequest(http.MethodPost, "/"+bucketName+"?delete", strings.NewReader(string(body))), "alice"), bucketName)
+		var result models.DeleteResult
+		if w.Code == http.StatusOK {
+			if err := xml.NewDecoder(w.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code, result
+	}
+	identifiers := func(keys ...string) []models.ObjectIdentifier {
+		objects := make([]models.ObjectIdentifier, len(keys))
+		for i, key := range keys {
+			objects[i] = models.ObjectIdentifier{Key: key}
+		}
+		return objects
+	}
+
+	store("bucket", "a", "b", "locked/c")
+	status, result := deleteObjects("bucket", models.Delete{Objects: identifiers("a", "a", "missing", "locked/c", "objects.csv")})
+	if status != http.StatusOK {
+		t.Fatalf("verbose: got %d", status)
+	}
+	var deleted []string
+	for _, object := range result.Deleted {
+		deleted = append(deleted, object.Key)
+	}
+	if strings.Join(deleted, ",") != "a,a,missing" {
+		t.Errorf("verbose: got deleted %v, want a twice and missing", deleted)
+	}
+	if len(result.Errors) != 2 || result.Errors[0].Code != "AccessDenied" || result.Errors[1].Code != "InvalidArgument" {
+		t.Errorf("verbose: got errors %+v", result.Errors)
+	}
+	if _, ok, _ := storage.Metadata.GetObject("bucket", "locked/c"); !ok {
+		t.Error("verbose: a denied key was deleted")
+	}
+
+	status, result = deleteObjects("bucket", models.Delete{Quiet: true, Objects: identifiers("b", "locked/c")})
+	if status != http.StatusOK || len(result.Deleted) != 0 || len(result.Errors) != 1 || result.Errors[0].Key != "locked/c" {
+		t.Errorf("quiet: got %d, %+v", status, result)
+	}
+	if _, ok, _ := storage.Metadata.GetObject("bucket", "b"); ok {
+		t.Error("quiet: b was not deleted")
+	}
+
+	keys := make([]string, 1001)
+	for i := range keys {
+		keys[i] = "k"
+	}
+	if status, _ := deleteObjects("bucket", models.Delete{Objects: identifiers(keys...)}); status != http.StatusBadRequest {
+		t.Errorf("1001 keys: got %d, want 400", status)
+	}
+	if status, result := deleteObjects("bucket", models.Delete{Quiet: true, Objects: identifiers(keys[:1000]...)}); status != http.StatusOK || len(result.Errors) != 0 {
+		t.Errorf("1000 keys: got %d, %+v", status, result)
+	}
+	if status, _ := deleteObjects("bucket", models.Delete{}); status != http.StatusBadRequest {
+		t.Errorf("no keys: got %d, want 400", status)
+	}
+
+	store("versioned", "a", "b")
+	a, _, _ := storage.Metadata.GetObject("versioned", "a")
+	status, result = deleteObjects("versioned", models.Delete{Objects: []models.ObjectIdentifier{{Key: "a"}, {Key: "b", VersionID: "nosuchversion"}}})
+	if status != http.StatusOK || len(result.Deleted) != 1 || len(result.Errors) != 1 || result.Errors[0].Code != "NoSuchVersion" {
+		t.Fatalf("versioned: got %d, %+v", status, result)
+	}
+	marker := result.Deleted[0]
+	if !marker.DeleteMarker || marker.DeleteMarkerVersionID == "" || marker.DeleteMarkerVersionID == a.VersionID {
+		t.Errorf("versioned: got %+v, want a new delete marker", marker)
+	}
+
+	// Deleting the marker by its version brings a back.
+	status, result = deleteObjects("versioned", models.Delete{Objects: []models.ObjectIdentifier{{Key: "a", VersionID: marker.DeleteMarkerVersionID}}})
+	if status != http.StatusOK || len(result.Deleted) != 1 || !result.Deleted[0].DeleteMarker || result.Deleted[0].DeleteMarkerVersionID != marker.DeleteMarkerVersionID {
+		t.Errorf("deleting the marker: got %d, %+v", status, result)
+	}
+	if current, ok, _ := storage.Metadata.GetObject("versioned", "a"); !ok || current.VersionID != a.VersionID {
+		t.Errorf("after deleting the marker: got %+v, want version %s", current, a.VersionID)
+	}
+}
+
+func putObject(t *testing.T, target, body string, headers map[string]string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPut, target, strings.NewReader(body))
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	uploadObjectHandler(w, r)
+	return w
+}
+
+func TestGetObjectConditions(t *testing.T) {
+	setupStorage(t)
+	createBucket(t, "bucket", "alice", storage.ACLPublicRead)
+	sum := sha256.Sum256([]byte("hello world"))
+	checksum := base64.StdEncoding.EncodeToString(sum[:])
+	if w := putObject(t, "/bucket/k", "hello world", map[string]string{"Content-Type": "text/plain", "x-amz-checksum-sha256": checksum}); w.Code != http.StatusOK {
+		t.Fatalf("PUT: got %d: %s", w.Code, w.Body.String())
+	}
+	object, _, _ := storage.Metadata.GetObject("bucket", "k")
+	etag := quoteETag(object.ETag)
+	past := object.LastModified.Add(-time.Hour).UTC().Format(http.TimeFormat)
+	future := object.LastModified.Add(time.Hour).UTC().Format(http.TimeFormat)
+
+	tests := []struct {
+		name, method string
+		headers      map[string]string
+		status       int
+		body         string
+		want         map[string]string
+	}{
+		{"plain", "GET", nil, 200, "hello world", map[string]string{"ETag": etag, "Content-Type": "text/plain", "Content-Length": "11", "x-amz-checksum-sha256": ""}},
+		{"checksum mode", "GET", map[string]string{"x-amz-checksum-mode": "ENABLED"}, 200, "hello world", map[string]string{"x-amz-checksum-sha256": checksum}},
+		{"checksum mode on HEAD", "HEAD", map[string]string{"x-amz-checksum-mode": "enabled"}, 200, "", map[string]string{"x-amz-checksum-sha256": checksum, "Content-Length": "11"}},
+		{"range", "GET", map[string]string{"Range": "bytes=0-4"}, 206, "hello", map[string]string{"Content-Range": "bytes 0-4/11"}},
+		{"suffix range", "GET", map[string]string{"Range": "bytes=-5"}, 206, "world", map[string]string{"Content-Range": "bytes 6-10/11"}},
+		{"range on HEAD", "HEAD", map[string]string{"Range": "bytes=6-"}, 206, "", map[string]string{"Content-Length": "5"}},
+		{"unsatisfiable range", "GET", map[string]string{"Range": "bytes=20-"}, 416, "", nil},
+		{"if-match", "GET", map[string]string{"If-Match": etag}, 200, "hello world", nil},
+		{"if-match fails", "GET", map[string]string{"If-Match": `"other"`}, 412, "", nil},
+		{"if-none-match", "GET", map[string]string{"If-None-Match": etag}, 304, "", nil},
+		{"if-none-match fails", "HEAD", map[string]string{"If-None-Match": `"other"`}, 200, "", nil},
+		{"if-modified-since", "GET", map[string]string{"If-Modified-Since": future}, 304, "", nil},
+		{"modified since", "GET", map[string]string{"If-Modified-Since": past}, 200, "hello world", nil},
+		{"if-unmodified-since fails", "GET", map[string]string{"If-Unmodified-Since": past}, 412, "", nil},
+		{"if-match wins over if-unmodified-since", "GET", map[string]string{"If-Match": etag, "If-Unmodified-Since": past}, 200, "hello world", nil},
+		{"range with if-range", "GET", map[string]string{"Range": "bytes=0-4", "If-Range": `"other"`}, 200, "hello world", nil},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/bucket/k", nil)
+		for name, value := range test.headers {
+			r.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		if test.method == http.MethodHead {
+			headObjectHandler(w, r, "bucket", "k")
+		} else {
+			retrieveObjectHandler(w, r)
+		}
+		if w.Code != test.status || (test.body != "" && w.Body.String() != test.body) {
+			t.Errorf("%s: got %d %q, want %d %q", test.name, w.Code, w.Body.String(), test.status, test.body)
+		}
+		for name, value := range test.want {
+			if got := w.Header().Get(name); got != value {
+				t.Errorf("%s: got %s %q, want %q", test.name, name, got, value)
+			}
+		}
+	}
+}
+
+func TestPutObjectConditions(t *testing.T) {
+	setupStorage(t)
+	createBucket(t, "bucket", "alice", storage.ACLPrivate)
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+	}{
+		{"if-match on a missing key", map[string]string{"If-Match": "*"}, 412},
+		{"create-only", map[string]string{"If-None-Match": "*"}, 200},
+		{"create-only again", map[string]string{"If-None-Match": "*"}, 412},
+		{"if-match fails", map[string]string{"If-Match": `"other"`}, 412},
+		{"if-match", map[string]string{"If-Match": `"5d41402abc4b2a76b9719d911017c592"`}, 200},
+	}
+	for _, test := range tests {
+		w := putObject(t, "/bucket/k", "hello", test.headers)
+		if w.Code != test.status {
+			t.Errorf("%s: got %d: %s", test.name, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestPutObjectDigests(t *testing.T) {
+	setupStorage(t)
+	createBucket(t, "bucket", "alice", storage.ACLPrivate)
+	md5Sum := md5.Sum([]byte("data"))
+	otherMD5 := md5.Sum([]byte("other"))
+	shaSum := sha256.Sum256([]byte("data"))
+	otherSHA := sha256.Sum256([]byte("other"))
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		status  int
+		code    string
+	}{
+		{"wrong Content-MD5", map[string]string{"Content-MD5": base64.StdEncoding.EncodeToString(otherMD5[:])}, 400, "BadDigest"},
+		{"malformed Content-MD5", map[string]string{"Content-MD5": "not base64"}, 400, "InvalidDigest"},
+		{"short Content-MD5", map[string]string{"Content-MD5": base64.StdEncoding.EncodeToString(md5Sum[:8])}, 400, "InvalidDigest"},
+		{"wrong checksum", map[string]string{"x-amz-checksum-sha256": base64.StdEncoding.EncodeToString(otherSHA[:])}, 400, "BadDigest"},
+		{"one of two wrong", map[string]string{"Content-MD5": base64.StdEncoding.EncodeToString(md5Sum[:]), "x-amz-checksum-crc32": "AAAAAA=="}, 400, "BadDigest"},
+		{"matching", map[string]string{"Content-MD5": base64.StdEncoding.EncodeToString(md5Sum[:]), "x-amz-checksum-sha256": base64.StdEncoding.EncodeToString(shaSum[:])}, 200, ""},
+	}
+	for _, test := range tests {
+		w := putObject(t, "/bucket/k", "data", test.headers)
+		if w.Code != test.status || !strings.Contains(w.Body.String(), test.code) {
+			t.Errorf("%s: got %d: %s", test.name, w.Code, w.Body.String())
+		}
+		object, exists, err := storage.Metadata.GetObject("bucket", "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries, err := os.ReadDir(storage.BucketPath("bucket"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var files []string
+		for _, entry := range entries {
+			if entry.Name() != "objects.csv" {
+				files = append(files, entry.Name())
+			}
+		}
+		if test.status != http.StatusOK {
+			if exists || len(files) != 0 {
+				t.Errorf("%s: left row %v and files %v", test.name, exists, files)
+			}
+			continue
+		}
+		if !exists || len(files) != 1 || object.Checksums[storage.ChecksumSHA256] != base64.StdEncoding.EncodeToString(shaSum[:]) {
+			t.Errorf("%s: got %+v and files %v", test.name, object, files)
+		}
+	}
+}
+
+func TestCORSPreflight(t *testing.T) {
+	setupStorage(t)
+	createBucket(t, "bucket", "alice", storage.ACLPrivate)
+	createBucket(t, "no-cors", "alice", storage.ACLPrivate)
+	config := `<CORSConfiguration>
+		<CORSRule><AllowedOrigin>https://app.example.com</AllowedOrigin><AllowedMethod>PUT</AllowedMethod><AllowedMethod>DELETE</AllowedMethod>
+			<AllowedHeader>Content-Type</AllowedHeader><AllowedHeader>x-amz-meta-*</AllowedHeader><MaxAgeSeconds>600</MaxAgeSeconds></CORSRule>
+		<CORSRule><AllowedOrigin>https://*.example.com</AllowedOrigin><AllowedMethod>GET</AllowedMethod><AllowedHeader>*</AllowedHeader>
+			<ExposeHeader>ETag</ExposeHeader></CORSRule>
+		<CORSRule><AllowedOrigin>*</AllowedOrigin><AllowedMethod>HEAD</AllowedMethod></CORSRule>
+	</CORSConfiguration>`
+	if _, err := parseCORS([]byte(config)); err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.SetBucketCORS("bucket", config); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name, bucket, origin, method, headers string
+		status                                int
+		want                                  map[string]string
+	}{
+		{"exact origin", "bucket", "https://app.example.com", "PUT", "Content-Type, X-Amz-Meta-Color", 200, map[string]string{
+			"Access-Control-Allow-Origin": "https://app.example.com", "Access-Control-Allow-Credentials": "true",
+			"Access-Control-Allow-Methods": "PUT, DELETE", "Access-Control-Allow-Headers": "content-type, x-amz-meta-color", "Access-Control-Max-Age": "600",
+		}},
+		{"header outside the rule", "bucket", "https://app.example.com", "PUT", "x-amz-acl", 403, nil},
+		{"method outside the rule", "bucket", "https://app.example.com", "POST", "", 403, nil},
+		{"methods are case-sensitive", "bucket", "https://app.example.com", "put", "", 403, nil},
+		{"origin wildcard", "bucket", "https://cdn.example.com", "GET", "x-anything", 200, map[string]string{
+			"Access-Control-Allow-Origin": "https://cdn.example.com", "Access-Control-Expose-Headers": "ETag", "Access-Control-Max-Age": "",
+		}},
+		{"origin wildcard needs the suffix", "bucket", "https://example.com.evil.org", "GET", "", 403, nil},
+		{"origin wildcard needs the prefix", "bucket", "http://cdn.example.com", "GET", "", 403, nil},
+		{"any origin", "bucket", "https://other.org", "HEAD", "", 200, map[string]string{
+			"Access-Control-Allow-Origin": "*", "Access-Control-Allow-Credentials": "",
+		}},
+		{"any origin, other method", "bucket", "https://other.org", "GET", "", 403, nil},
+		{"no configuration", "no-cors", "https://app.example.com", "GET", "", 403, nil},
+		{"no origin", "bucket", "", "GET", "", 400, nil},
+		{"no method", "bucket", "https://app.example.com", "", "", 400, nil},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodOptions, "/"+test.bucket+"/k", nil)
+		for name, value := range map[string]string{"Origin": test.origin, "Access-Control-Request-Method": test.method, "Access-Control-Request-Headers": test.headers} {
+			if value != "" {
+				r.Header.Set(name, value)
+			}
+		}
+		w := httptest.NewRecorder()
+		corsPreflightHandler(w, r, test.bucket)
+		if w.Code != test.status {
+			t.Errorf("%s: got %d: %s", test.name, w.Code, w.Body.String())
+			continue
+		}
+		for name, value := range test.want {
+			if got := w.Header().Get(name); got != value {
+				t.Errorf("%s: got %s %q, want %q", test.name, name, got, value)
+			}
+		}
+	}
+
+	for _, rule := range []string{
+		`<AllowedOrigin>*</AllowedOrigin><AllowedMethod>*</AllowedMethod>`,
+		`<AllowedOrigin>*</AllowedOrigin><AllowedMethod>PATCH</AllowedMethod>`,
+		`<AllowedOrigin>https://*.*.com</AllowedOrigin><AllowedMethod>GET</AllowedMethod>`,
+		`<AllowedOrigin>*</AllowedOrigin><AllowedMethod>GET</AllowedMethod><AllowedHeader>x-*-*</AllowedHeader>`,
+		`<AllowedMethod>GET</AllowedMethod>`,
+	} {
+		if _, err := parseCORS([]byte("<CORSConfiguration><CORSRule>" + rule + "</CORSRule></CORSConfiguration>")); err == nil {
+			t.Errorf("accepted %s", rule)
+		}
+	}
+}
+
+func TestTagging(t *testing.T) {
+	setupStorage(t)
+	createBucket(t, "bucket", "alice", storage.ACLPublicRead)
+	tagSet := func(count int, extra ...string) string {
+		var b strings.Builder
+		b.WriteString("<Tagging><TagSet>")
+		for i := 0; i < count; i++ {
+			fmt.Fprintf(&b, "<Tag><Key>k%d</Key><Value>v</Value></Tag>", i)
+		}
+		for i := 0; i+1 < len(extra); i += 2 {
+			fmt.Fprintf(&b, "<Tag><Key>%s</Key><Value>%s</Value></Tag>", extra[i], extra[i+1])
+		}
+		b.WriteString("</TagSet></Tagging>")
+		return b.String()
+	}
+	tagHeader := func(count int) string {
+		values := url.Values{}
+		for i := 0; i < count; i++ {
+			values.Set(fmt.Sprintf("k%d", i), "v")
+		}
+		return values.Encode()
+	}
+
+	headerTests := []struct {
+		name, header string
+		status       int
+		code         string
+	}{
+		{"ten tags", tagHeader(storage.MaxObjectTags), 200, ""},
+		{"eleven tags", tagHeader(storage.MaxObjectTags + 1), 400, "BadRequest"},
+		{"reserved prefix", "aws:team=data", 400, "InvalidTag"},
+		{"repeated key", "team=a&team=b", 400, "InvalidTag"},
+		{"long key", strings.Repeat("k", storage.MaxTagKeyLength+1) + "=v", 400, "InvalidTag"},
+		{"long value", "k=" + strings.Repeat("v", storage.MaxTagValueLength+1), 400, "InvalidTag"},
+		{"bad character", "k=a%3Bb", 400, "InvalidTag"},
+	}
+	for _, test := range headerTests {
+		w := putObject(t, "/bucket/header", "data", map[string]string{"x-amz-tagging": test.header})
+		if w.Code != test.status || !strings.Contains(w.Body.String(), test.code) {
+			t.Errorf("x-amz-tagging %s: got %d: %s", test.name, w.Code, w.Body.String())
+		}
+	}
+
+	setTags := func(target, document string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPut, target+"?tagging", strings.NewReader(document))
+		if bucketName, objectKey, _ := splitPath(target); objectKey == "" {
+			bucketTaggingHandler(w, r, bucketName)
+		} else {
+			objectTaggingHandler(w, r, bucketName, objectKey)
+		}
+		return w
+	}
+	documentTests := []struct {
+		name, target, document string
+		status                 int
+		code                   string
+	}{
+		{"object at the limit", "/bucket/header", tagSet(storage.MaxObjectTags), 200, ""},
+		{"object over the limit", "/bucket/header", tagSet(storage.MaxObjectTags + 1), 400, "BadRequest"},
+		{"bucket over the object limit", "/bucket", tagSet(storage.MaxObjectTags + 1), 204, ""},
+		{"bucket at the limit", "/bucket", tagSet(storage.MaxBucketTags), 204, ""},
+		{"bucket over the limit", "/bucket", tagSet(storage.MaxBucketTags + 1), 400, "BadRequest"},
+		{"duplicate keys", "/bucket/header", tagSet(0, "a", "1", "a", "2"), 400, "InvalidTag"},
+		{"empty key", "/bucket/header", tagSet(0, "", "1"), 400, "InvalidTag"},
+		{"malformed", "/bucket/header", "<Tagging>", 400, "MalformedXML"},
+		{"missing object", "/bucket/missing", tagSet(1), 404, ""},
+	}
+	for _, test := range documentTests {
+		if w := setTags(test.target, test.document); w.Code != test.status || !strings.Contains(w.Body.String(), test.code) {
+			t.Errorf("%s: got %d: %s", test.name, w.Code, w.Body.String())
+		}
+	}
+
+	for key, header := range map[string]string{"a": "team=data&tier=hot", "b": "team=data&tier=cold", "c": "team=web", "d": ""} {
+		if w := putObject(t, "/bucket/"+key, key, map[string]string{"x-amz-tagging": header}); w.Code != http.StatusOK {
+			t.Fatalf("PUT %s: got %d", key, w.Code)
+		}
+	}
+	listTests := []struct {
+		query, want string
+		status      int
+	}{
+		{"tag=team%3Ddata", "a,b", 200},
+		{"tag=team%3Ddata&tag=tier%3Dcold", "b", 200},
+		{"tag=team%3D", "", 200},
+		{"tag=tier%3Dhot&prefix=b", "", 200},
+		{"tag=team", "", 400},
+		{"tag=%3Ddata", "", 400},
+	}
+	for _, test := range listTests {
+		w := httptest.NewRecorder()
+		MyHandler(w, httptest.NewRequest(http.MethodGet, "/bucket?"+test.query, nil))
+		if w.Code != test.status {
+			t.Errorf("%s: got %d: %s", test.query, w.Code, w.Body.String())
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		var result models.ListBucketResult
+		if err := xml.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatal(err)
+		}
+		var keys []string
+		for _, object := range result.Contents {
+			keys = append(keys, object.Key)
+		}
+		if got := strings.Join(keys, ","); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.query, got, test.want)
+		}
+	}
+}
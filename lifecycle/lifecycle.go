@@ -0,0 +1,164 @@
+// Package lifecycle parses bucket lifecycle configurations and applies
+// them in the background.
+package lifecycle
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"triple-s/models"
+	"triple-s/storage"
+)
+
+// MaxRules is the number of rules S3 accepts in one configuration.
+const MaxRules = 1000
+
+// ErrMalformed is returned for documents that are not a
+// LifecycleConfiguration.
+var ErrMalformed = errors.New("invalid LifecycleConfiguration document")
+
+// Rule is a parsed lifecycle rule. Zero values mean the action is not set.
+type Rule struct {
+	ID      string
+	Enabled bool
+	Prefix  string
+	// Tags selects objects carrying all of them.
+	Tags       map[string]string
+	ExpireDays int
+	ExpireDate time.Time
+	AbortDays  int
+}
+
+// Parse reads a lifecycle configuration and checks its rules the way S3
+// does. Errors other than ErrMalformed describe an invalid rule.
+func Parse(document []byte) ([]Rule, error) {
+	var config models.LifecycleConfiguration
+	if err := xml.NewDecoder(bytes.NewReader(document)).Decode(&config); err != nil {
+		return nil, ErrMalformed
+	}
+	if len(config.Rules) == 0 || len(config.Rules) > MaxRules {
+		return nil, fmt.Errorf("a lifecycle configuration needs 1 to %d rules", MaxRules)
+	}
+
+	rules := make([]Rule, 0, len(config.Rules))
+	ids := make(map[string]bool)
+	for _, raw := range config.Rules {
+		rule, err := parseRule(raw)
+		if err != nil {
+			return nil, err
+		}
+		if rule.ID != "" && ids[rule.ID] {
+			return nil, fmt.Errorf("rule ID %q is used more than once", rule.ID)
+		}
+		ids[rule.ID] = true
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func parseRule(raw models.LifecycleRule) (Rule, error) {
+	rule := Rule{ID: raw.ID, Enabled: raw.Status == "Enabled"}
+	if len(rule.ID) > 255 {
+		return rule, errors.New("rule IDs are limited to 255 characters")
+	}
+	if raw.Status != "Enabled" && raw.Status != "Disabled" {
+		return rule, ErrMalformed
+	}
+
+	switch {
+	case raw.Prefix != nil && raw.Filter != nil, raw.Prefix == nil && raw.Filter == nil:
+		return rule, ErrMalformed
+	case raw.Prefix != nil:
+		rule.Prefix = *raw.Prefix
+	default:
+		filter := raw.Filter
+		set := 0
+		for _, present := range []bool{filter.Prefix != nil, filter.Tag != nil, filter.And != nil} {
+			if present {
+				set++
+			}
+		}
+		if set > 1 {
+			return rule, ErrMalformed
+		}
+		switch {
+		case filter.Prefix != nil:
+			rule.Prefix = *filter.Prefix
+		case filter.Tag != nil:
+			rule.Tags = map[string]string{filter.Tag.Key: filter.Tag.Value}
+		case filter.And != nil:
+			rule.Prefix = filter.And.Prefix
+			rule.Tags = make(map[string]string, len(filter.And.Tags))
+			for _, tag := range filter.And.Tags {
+				if _, ok := rule.Tags[tag.Key]; ok {
+					return rule, fmt.Errorf("tag %q is used more than once in a filter", tag.Key)
+				}
+				rule.Tags[tag.Key] = tag.Value
+			}
+		}
+		if err := storage.CheckTags(rule.Tags, storage.MaxObjectTags); err != nil {
+			return rule, err
+		}
+	}
+
+	if raw.Expiration == nil && raw.AbortIncompleteMultipartUpload == nil {
+		return rule, errors.New("at least one action needs to be specified in a rule")
+	}
+	if expiration := raw.Expiration; expiration != nil {
+		switch {
+		case (expiration.Days == nil) == (expiration.Date == ""):
+			return rule, errors.New("Expiration needs either Days or Date")
+		case expiration.Days != nil:
+			if *expiration.Days < 1 {
+				return rule, errors.New("'Days' for Expiration action must be a positive integer")
+			}
+			rule.ExpireDays = *expiration.Days
+		default:
+			date, err := time.Parse(time.RFC3339, strings.TrimSpace(expiration.Date))
+			if err != nil || !date.Equal(date.UTC().Truncate(24*time.Hour)) {
+				return rule, errors.New("'Date' must be at midnight GMT, such as 2026-01-01T00:00:00Z")
+			}
+			rule.ExpireDate = date
+		}
+	}
+	if abort := raw.AbortIncompleteMultipartUpload; abort != nil {
+		if len(rule.Tags) > 0 {
+			return rule, errors.New("AbortIncompleteMultipartUpload cannot be specified with Tags")
+		}
+		if abort.DaysAfterInitiation == nil || *abort.DaysAfterInitiation < 1 {
+			return rule, errors.New("'DaysAfterInitiation' for AbortIncompleteMultipartUpload action must be a positive integer")
+		}
+		rule.AbortDays = *abort.DaysAfterInitiation
+	}
+	return rule, nil
+}
+
+// Expires reports whether the rule expires object at now.
+func (r Rule) Expires(object models.ObjectCSV, now time.Time) bool {
+	if !r.Enabled || !strings.HasPrefix(object.ObjectKey, r.Prefix) || !storage.MatchesTags(object.Tags, r.Tags) {
+		return false
+	}
+	if r.ExpireDays > 0 {
+		return !now.Before(dueDate(object.LastModified, r.ExpireDays))
+	}
+	return !r.ExpireDate.IsZero() && !now.Before(r.ExpireDate)
+}
+
+// Aborts reports whether the rule aborts upload at now.
+func (r Rule) Aborts(upload storage.MultipartUpload, now time.Time) bool {
+	return r.Enabled && r.AbortDays > 0 && strings.HasPrefix(upload.Object.ObjectKey, r.Prefix) &&
+		!now.Before(dueDate(upload.Initiated, r.AbortDays))
+}
+
+// dueDate adds days to t and rounds up to the next midnight UTC, as S3
+// does.
+func dueDate(t time.Time, days int) time.Time {
+	due := t.UTC().AddDate(0, 0, days)
+	if midnight := due.Truncate(24 * time.Hour); midnight.Before(due) {
+		return midnight.Add(24 * time.Hour)
+	}
+	return due
+}
@@ -0,0 +1,200 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+	"triple-s/flags"
+	"triple-s/models"
+	"triple-s/storage"
+)
+
+func setupStorage(t *testing.T, bucketName string) {
+	t.Helper()
+	previous := flags.StorageDir
+	flags.StorageDir, flags.MetadataBackend = t.TempDir(), storage.BackendCSV
+	if err := storage.Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		storage.Metadata.Close()
+		storage.Journal.Close()
+		flags.StorageDir = previous
+	})
+	now := time.Now()
+	if err := storage.CreateBucket(models.Bucket{Name: bucketName, CreationDate: now, LastModified: now, ContentStatus: "inactive"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func store(t *testing.T, bucketName, key string, tags map[string]string) models.ObjectCSV {
+	t.Helper()
+	object, err := storage.StoreObject(bucketName, models.ObjectCSV{ObjectKey: key, Tags: tags}, strings.NewReader(key), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return object
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name, rule string
+		err        bool
+	}{
+		{"prefix", `<Prefix>logs/</Prefix><Expiration><Days>1</Days></Expiration>`, false},
+		{"filter and", `<Filter><And><Prefix>a/</Prefix><Tag><Key>k</Key><Value>v</Value></Tag></And></Filter><Expiration><Date>2026-01-01T00:00:00Z</Date></Expiration>`, false},
+		{"no filter", `<Expiration><Days>1</Days></Expiration>`, true},
+		{"no action", `<Prefix></Prefix>`, true},
+		{"zero days", `<Prefix></Prefix><Expiration><Days>0</Days></Expiration>`, true},
+		{"date not at midnight", `<Prefix></Prefix><Expiration><Date>2026-01-01T12:00:00Z</Date></Expiration>`, true},
+		{"days and date", `<Prefix></Prefix><Expiration><Days>1</Days><Date>2026-01-01T00:00:00Z</Date></Expiration>`, true},
+		{"abort with tags", `<Filter><Tag><Key>k</Key><Value>v</Value></Tag></Filter><AbortIncompleteMultipartUpload><DaysAfterInitiation>1</DaysAfterInitiation></AbortIncompleteMultipartUpload>`, true},
+	}
+	for _, test := range tests {
+		document := `<LifecycleConfiguration><Rule><ID>r</ID><Status>Enabled</Status>` + test.rule + `</Rule></LifecycleConfiguration>`
+		if _, err := Parse([]byte(document)); (err != nil) != test.err {
+			t.Errorf("%s: got %v", test.name, err)
+		}
+	}
+}
+
+func TestRuleExpires(t *testing.T) {
+	modified := time.Date(2026, 3, 10, 15, 0, 0, 0, time.UTC)
+	object := models.ObjectCSV{ObjectKey: "logs/a", LastModified: modified, Tags: map[string]string{"team": "data", "tier": "cold"}}
+	date := time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		rule Rule
+		now  time.Time
+		want bool
+	}{
+		{"before the day is over", Rule{Enabled: true, ExpireDays: 1}, time.Date(2026, 3, 11, 23, 59, 0, 0, time.UTC), false},
+		{"at the next midnight after a day", Rule{Enabled: true, ExpireDays: 1}, time.Date(2026, 3, 12, 0, 0, 0, 0, time.UTC), true},
+		{"disabled", Rule{ExpireDays: 1}, date, false},
+		{"other prefix", Rule{Enabled: true, Prefix: "tmp/", ExpireDays: 1}, date, false},
+		{"matching prefix", Rule{Enabled: true, Prefix: "logs/", ExpireDays: 1}, date, true},
+		{"all tags match", Rule{Enabled: true, Tags: map[string]string{"team": "data", "tier": "cold"}, ExpireDays: 1}, date, true},
+		{"one tag differs", Rule{Enabled: true, Tags: map[string]string{"team": "data", "tier": "hot"}, ExpireDays: 1}, date, false},
+		{"before the date", Rule{Enabled: true, ExpireDate: date}, date.Add(-time.Second), false},
+		{"on the date", Rule{Enabled: true, ExpireDate: date}, date, true},
+		{"no expiration", Rule{Enabled: true, AbortDays: 1}, date, false},
+	}
+	for _, test := range tests {
+		if got := test.rule.Expires(object, test.now); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRuleAborts(t *testing.T) {
+	initiated := time.Date(2026, 3, 10, 15, 0, 0, 0, time.UTC)
+	upload := storage.MultipartUpload{Object: models.ObjectCSV{ObjectKey: "uploads/a"}, Initiated: initiated}
+
+	tests := []struct {
+		name string
+		rule Rule
+		now  time.Time
+		want bool
+	}{
+		{"before two days are over", Rule{Enabled: true, AbortDays: 2}, time.Date(2026, 3, 12, 23, 0, 0, 0, time.UTC), false},
+		{"after two days", Rule{Enabled: true, AbortDays: 2}, time.Date(2026, 3, 13, 0, 0, 0, 0, time.UTC), true},
+		{"other prefix", Rule{Enabled: true, Prefix: "logs/", AbortDays: 1}, initiated.AddDate(0, 1, 0), false},
+		{"disabled", Rule{Prefix: "uploads/", AbortDays: 1}, initiated.AddDate(0, 1, 0), false},
+		{"only expiration", Rule{Enabled: true, ExpireDays: 1}, initiated.AddDate(0, 1, 0), false},
+	}
+	for _, test := range tests {
+		if got := test.rule.Aborts(upload, test.now); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestExpireSkipsChangedObjects(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, listed models.ObjectCSV)
+		kept   bool
+	}{
+		{"unchanged", func(*testing.T, models.ObjectCSV) {}, false},
+		{"replaced", func(t *testing.T, listed models.ObjectCSV) {
+			if _, err := storage.StoreObject("bucket", models.ObjectCSV{ObjectKey: listed.ObjectKey, Tags: listed.Tags}, strings.NewReader("new"), nil); err != nil {
+				t.Fatal(err)
+			}
+		}, true},
+		{"retagged", func(t *testing.T, listed models.ObjectCSV) {
+			if err := storage.SetObjectTags("bucket", listed.ObjectKey, "", map[string]string{"keep": "yes"}); err != nil {
+				t.Fatal(err)
+			}
+		}, true},
+		{"deleted", func(t *testing.T, listed models.ObjectCSV) {
+			if _, err := storage.RemoveObject("bucket", listed.ObjectKey, nil); err != nil {
+				t.Fatal(err)
+			}
+		}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupStorage(t, "bucket")
+			listed := store(t, "bucket", "k", map[string]string{"team": "data"})
+			test.change(t, listed)
+
+			err := expire("bucket", listed)
+			if test.kept != errors.Is(err, storage.ErrPreconditionFailed) || (!test.kept && err != nil) {
+				t.Fatalf("got %v", err)
+			}
+			_, exists, err := storage.Metadata.GetObject("bucket", "k")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.name != "deleted" && exists != test.kept {
+				t.Errorf("object exists: %v, want %v", exists, test.kept)
+			}
+		})
+	}
+}
+
+func TestScan(t *testing.T) {
+	setupStorage(t, "bucket")
+	document := `<LifecycleConfiguration>
+		<Rule><ID>old</ID><Status>Enabled</Status><Prefix>old/</Prefix><Expiration><Date>2020-01-01T00:00:00Z</Date></Expiration></Rule>
+		<Rule><ID>tagged</ID><Status>Enabled</Status><Filter><Tag><Key>expire</Key><Value>yes</Value></Tag></Filter><Expiration><Date>2020-01-01T00:00:00Z</Date></Expiration></Rule>
+		<Rule><ID>off</ID><Status>Disabled</Status><Prefix>new/</Prefix><Expiration><Date>2020-01-01T00:00:00Z</Date></Expiration></Rule>
+	</LifecycleConfiguration>`
+	if err := storage.SetBucketLifecycle("bucket", document); err != nil {
+		t.Fatal(err)
+	}
+	store(t, "bucket", "old/a", nil)
+	store(t, "bucket", "new/a", nil)
+	store(t, "bucket", "new/b", map[string]string{"expire": "yes"})
+
+	scanner := NewScanner(time.Hour)
+	scanner.scan()
+
+	objects, err := storage.Metadata.ListObjects("bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].ObjectKey != "new/a" {
+		t.Errorf("got %v, want only new/a", objects)
+	}
+	status := scanner.Status()
+	if status.ObjectsScanned != 3 || status.ObjectsExpired != 2 || status.Errors != 0 || status.Passes != 1 {
+		t.Errorf("got status %+v", status)
+	}
+}
+
+func TestStopTwice(t *testing.T) {
+	setupStorage(t, "bucket")
+	scanner := NewScanner(time.Hour)
+	scanner.Start()
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		if err := scanner.Stop(ctx); err != nil {
+			t.Errorf("stop %d: %v", i+1, err)
+		}
+		cancel()
+	}
+}
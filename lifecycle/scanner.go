@@ -0,0 +1,220 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"sync"
+	"time"
+	"triple-s/models"
+	"triple-s/storage"
+)
+
+// Background is the scanner started by the server, nil when lifecycle
+// rules are not applied.
+var Background *Scanner
+
+// Scanner applies the lifecycle rules of every bucket, once when it starts
+// and then every interval.
+type Scanner struct {
+	interval time.Duration
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+
+	mu     sync.Mutex
+	status models.LifecycleStatus
+}
+
+func NewScanner(interval time.Duration) *Scanner {
+	return &Scanner{
+		interval: interval,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+		status:   models.LifecycleStatus{Enabled: true, Interval: interval.String()},
+	}
+}
+
+func (s *Scanner) Start() {
+	go s.run()
+}
+
+// Stop asks the scanner to finish the object it is working on and waits
+// for it until ctx is done. It can be called again, for instance to wait
+// longer after a timeout.
+func (s *Scanner) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stop) })
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Status returns the progress of the scanner.
+func (s *Scanner) Status() models.LifecycleStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+func (s *Scanner) run() {
+	defer close(s.done)
+	for {
+		s.scan()
+		next := time.Now().UTC().Add(s.interval)
+		s.update(func(status *models.LifecycleStatus) { status.NextRun = &next })
+		select {
+		case <-s.stop:
+			return
+		case <-time.After(s.interval):
+		}
+	}
+}
+
+func (s *Scanner) stopped() bool {
+	select {
+	case <-s.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Scanner) update(change func(*models.LifecycleStatus)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	change(&s.status)
+}
+
+func (s *Scanner) fail(err error) {
+	log.Printf("Lifecycle: %v", err)
+	s.update(func(status *models.LifecycleStatus) {
+		status.Errors++
+		status.LastError = err.Error()
+	})
+}
+
+// scan makes one pass over all buckets.
+func (s *Scanner) scan() {
+	started := time.Now().UTC()
+	s.update(func(status *models.LifecycleStatus) {
+		status.Running = true
+		status.LastStarted = &started
+		status.NextRun = nil
+		status.BucketsScanned, status.ObjectsScanned, status.ObjectsExpired, status.UploadsAborted, status.Errors = 0, 0, 0, 0, 0
+		status.LastError = ""
+	})
+
+	buckets, err := storage.Metadata.ListBuckets()
+	if err != nil {
+		s.fail(err)
+	}
+	for _, bucket := range buckets {
+		if s.stopped() {
+			break
+		}
+		if bucket.Lifecycle == "" {
+			continue
+		}
+		rules, err := Parse([]byte(bucket.Lifecycle))
+		if err != nil {
+			s.fail(fmt.Errorf("stored configuration of %s: %w", bucket.Name, err))
+			continue
+		}
+		s.update(func(status *models.LifecycleStatus) {
+			status.Bucket = bucket.Name
+			status.BucketsScanned++
+		})
+		s.scanBucket(bucket.Name, rules)
+	}
+
+	finished := time.Now().UTC()
+	pass := s.Status()
+	s.update(func(status *models.LifecycleStatus) {
+		status.Running = false
+		status.Bucket = ""
+		status.Passes++
+		status.LastFinished = &finished
+	})
+	if pass.ObjectsExpired > 0 || pass.UploadsAborted > 0 || pass.Errors > 0 {
+		log.Printf("Lifecycle: expired %d objects and aborted %d uploads in %d buckets, %d errors",
+			pass.ObjectsExpired, pass.UploadsAborted, pass.BucketsScanned, pass.Errors)
+	}
+}
+
+// scanBucket expires the objects and aborts the uploads of one bucket.
+// An object is only removed if it has not been replaced or retagged since
+// it was listed.
+func (s *Scanner) scanBucket(bucketName string, rules []Rule) {
+	objects, err := storage.Metadata.ListObjects(bucketName)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	for _, object := range objects {
+		if s.stopped() {
+			return
+		}
+		s.update(func(status *models.LifecycleStatus) { status.ObjectsScanned++ })
+		if !anyRule(rules, func(rule Rule) bool { return rule.Expires(object, time.Now()) }) {
+			continue
+		}
+		err := expire(bucketName, object)
+		if errors.Is(err, storage.ErrPreconditionFailed) {
+			continue
+		}
+		if err != nil {
+			s.fail(fmt.Errorf("expiring %s/%s: %w", bucketName, object.ObjectKey, err))
+			continue
+		}
+		s.update(func(status *models.LifecycleStatus) { status.ObjectsExpired++ })
+	}
+
+	uploads, err := storage.ListMultipartUploads(bucketName)
+	if err != nil {
+		s.fail(err)
+		return
+	}
+	for _, upload := range uploads {
+		if s.stopped() {
+			return
+		}
+		if !anyRule(rules, func(rule Rule) bool { return rule.Aborts(upload, time.Now()) }) {
+			continue
+		}
+		err := storage.AbortMultipartUpload(bucketName, upload.Object.ObjectKey, upload.ID)
+		if errors.Is(err, storage.ErrNoSuchUpload) {
+			continue
+		}
+		if err != nil {
+			s.fail(fmt.Errorf("aborting upload %s in %s: %w", upload.ID, bucketName, err))
+			continue
+		}
+		s.update(func(status *models.LifecycleStatus) { status.UploadsAborted++ })
+	}
+}
+
+// expire removes the object listed as object, unless it has been deleted,
+// replaced or retagged since; then it returns storage.ErrPreconditionFailed.
+func expire(bucketName string, object models.ObjectCSV) error {
+	_, err := storage.RemoveObject(bucketName, object.ObjectKey, func(current *models.ObjectCSV) error {
+		if current == nil || !current.LastModified.Equal(object.LastModified) || current.ETag != object.ETag || !maps.Equal(current.Tags, object.Tags) {
+			return storage.ErrPreconditionFailed
+		}
+		return nil
+	})
+	return err
+}
+
+func anyRule(rules []Rule, applies func(Rule) bool) bool {
+	for _, rule := range rules {
+		if applies(rule) {
+			return true
+		}
+	}
+	return false
+}
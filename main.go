@@ -1,11 +1,35 @@
 package main
 
 import (
+	"log"
+	"triple-s/auth"
+	"triple-s/commands"
 	"triple-s/flags"
 	server "triple-s/servers"
+	"triple-s/storage"
 )
 
 func main() {
 	flags.Setup()
-	server.Start(flags.Port)
+
+	switch flags.Command {
+	case "":
+		if err := storage.Init(); err != nil {
+			log.Fatalf("Failed to open metadata store: %v", err)
+		}
+		defer storage.Metadata.Close()
+		defer storage.Journal.Close()
+		if err := auth.Init(); err != nil {
+			log.Fatalf("Failed to load credentials: %v", err)
+		}
+		server.Start(flags.Port, flags.StallTimeout, flags.LifecycleScan)
+	case "migrate":
+		commands.Migrate(flags.CommandArgs)
+	case "fsck":
+		commands.Fsck(flags.CommandArgs)
+	case "users":
+		commands.Users(flags.CommandArgs)
+	default:
+		log.Fatalf("Unknown command %q, see --help", flags.Command)
+	}
 }
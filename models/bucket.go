@@ -10,6 +10,21 @@ type Bucket struct {
 	CreationDate  time.Time `xml:"CreationDate"`
 	ContentStatus string    `xml:"ContentStatus"`
 	LastModified  time.Time `xml:"LastModified"`
+	// Versioning is "", "Enabled" or "Suspended".
+	Versioning string `xml:"-"`
+	// Owner is the user that created the bucket, empty for buckets made
+	// without authentication.
+	Owner string `xml:"-"`
+	// Policy is the bucket policy document as it was uploaded.
+	Policy string `xml:"-"`
+	// ACL is a canned ACL; empty means private.
+	ACL string `xml:"-"`
+	// CORS is the CORS configuration document as it was uploaded.
+	CORS string `xml:"-"`
+	// Lifecycle is the lifecycle configuration document as it was uploaded.
+	Lifecycle string `xml:"-"`
+	// Tags are the bucket's tags, set with ?tagging.
+	Tags map[string]string `xml:"-" json:",omitempty"`
 }
 
 type ListAllMyBucketsResult struct {
@@ -20,6 +35,7 @@ type ListAllMyBucketsResult struct {
 type ErrorResponse struct {
 	XMLName xml.Name `xml:"error"`
 	Status  int      `xml:"status"`
+	Code    string   `xml:"code,omitempty"`
 	Message string   `xml:"message"`
 }
 
@@ -27,3 +43,11 @@ type SuccessResponse struct {
 	XMLName xml.Name `xml:"success"`
 	Message string   `xml:"message"`
 }
+
+// PresignedURL is the response of GET /_admin/presign.
+type PresignedURL struct {
+	XMLName xml.Name  `xml:"PresignedURL"`
+	URL     string    `xml:"URL"`
+	Method  string    `xml:"Method"`
+	Expires time.Time `xml:"Expires"`
+}
@@ -0,0 +1,20 @@
+package models
+
+import "encoding/xml"
+
+// CORSConfiguration is the document of GET and PUT /{bucket}?cors.
+type CORSConfiguration struct {
+	XMLName xml.Name   `xml:"CORSConfiguration"`
+	Rules   []CORSRule `xml:"CORSRule"`
+}
+
+type CORSRule struct {
+	ID             string   `xml:"ID,omitempty"`
+	AllowedOrigins []string `xml:"AllowedOrigin"`
+	AllowedMethods []string `xml:"AllowedMethod"`
+	AllowedHeaders []string `xml:"AllowedHeader,omitempty"`
+	ExposeHeaders  []string `xml:"ExposeHeader,omitempty"`
+	// MaxAgeSeconds is how long browsers may cache a preflight response;
+	// nil leaves it to the browser.
+	MaxAgeSeconds *int `xml:"MaxAgeSeconds,omitempty"`
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// LifecycleConfiguration is the document of GET and PUT /{bucket}?lifecycle.
+type LifecycleConfiguration struct {
+	XMLName xml.Name        `xml:"LifecycleConfiguration"`
+	Rules   []LifecycleRule `xml:"Rule"`
+}
+
+type LifecycleRule struct {
+	ID string `xml:"ID,omitempty"`
+	// Prefix is the filter of rules written before Filter existed.
+	Prefix                         *string                         `xml:"Prefix"`
+	Filter                         *LifecycleFilter                `xml:"Filter"`
+	Status                         string                          `xml:"Status"`
+	Expiration                     *LifecycleExpiration            `xml:"Expiration"`
+	AbortIncompleteMultipartUpload *AbortIncompleteMultipartUpload `xml:"AbortIncompleteMultipartUpload"`
+}
+
+// LifecycleFilter holds one of Prefix, Tag or And. An empty filter
+// selects the whole bucket.
+type LifecycleFilter struct {
+	Prefix *string       `xml:"Prefix"`
+	Tag    *Tag          `xml:"Tag"`
+	And    *LifecycleAnd `xml:"And"`
+}
+
+type LifecycleAnd struct {
+	Prefix string `xml:"Prefix,omitempty"`
+	Tags   []Tag  `xml:"Tag"`
+}
+
+// LifecycleExpiration holds either Days or Date, an ISO 8601 midnight UTC.
+type LifecycleExpiration struct {
+	Days *int   `xml:"Days"`
+	Date string `xml:"Date,omitempty"`
+}
+
+type AbortIncompleteMultipartUpload struct {
+	DaysAfterInitiation *int `xml:"DaysAfterInitiation"`
+}
+
+// LifecycleStatus is the response of GET /_admin/lifecycle. The counters
+// describe the pass that is running, or the last one when none is.
+type LifecycleStatus struct {
+	XMLName        xml.Name   `xml:"LifecycleStatus"`
+	Enabled        bool       `xml:"Enabled"`
+	Interval       string     `xml:"Interval,omitempty"`
+	Running        bool       `xml:"Running"`
+	Bucket         string     `xml:"Bucket,omitempty"`
+	Passes         int        `xml:"Passes"`
+	LastStarted    *time.Time `xml:"LastStarted,omitempty"`
+	LastFinished   *time.Time `xml:"LastFinished,omitempty"`
+	NextRun        *time.Time `xml:"NextRun,omitempty"`
+	BucketsScanned int        `xml:"BucketsScanned"`
+	ObjectsScanned int        `xml:"ObjectsScanned"`
+	ObjectsExpired int        `xml:"ObjectsExpired"`
+	UploadsAborted int        `xml:"UploadsAborted"`
+	Errors         int        `xml:"Errors"`
+	LastError      string     `xml:"LastError,omitempty"`
+}
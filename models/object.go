@@ -1,10 +1,102 @@
 package models
 
-import "time"
+import (
+	"encoding/xml"
+	"time"
+)
 
 type ObjectCSV struct {
 	ObjectKey    string // The key/name of the object
 	ObjectSize   int64
 	ContentType  string    // The MIME type of the object
+	CreationDate time.Time // When the key was first written
 	LastModified time.Time // The last modified time of the object
+	ETag         string    // Hex MD5 of the content
+	SHA256       string    // Hex SHA-256 of the content
+	// VersionID is empty in buckets that never had versioning enabled.
+	VersionID    string
+	DeleteMarker bool   // Only set on entries of the versions table
+	ACL          string // Canned ACL; empty means private
+
+	// Standard headers stored at upload and echoed back on GET and HEAD.
+	CacheControl       string
+	ContentDisposition string
+	ContentEncoding    string
+	ContentLanguage    string
+	// Checksums maps x-amz-checksum algorithms to base64 digests. Only the
+	// algorithms the client supplied at upload are kept.
+	Checksums map[string]string `json:",omitempty"`
+	// UserMetadata holds the x-amz-meta-* headers keyed by the lower-case
+	// name after the prefix.
+	UserMetadata map[string]string `json:",omitempty"`
+	// Tags are the object's tags, set with ?tagging or x-amz-tagging.
+	Tags map[string]string `json:",omitempty"`
+}
+
+// ListBucketResult is the ListObjectsV2 response for GET /{bucket}.
+type ListBucketResult struct {
+	XMLName               xml.Name        `xml:"ListBucketResult"`
+	Name                  string          `xml:"Name"`
+	Prefix                string          `xml:"Prefix"`
+	Delimiter             string          `xml:"Delimiter,omitempty"`
+	StartAfter            string          `xml:"StartAfter,omitempty"`
+	ContinuationToken     string          `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string          `xml:"NextContinuationToken,omitempty"`
+	KeyCount              int             `xml:"KeyCount"`
+	MaxKeys               int             `xml:"MaxKeys"`
+	IsTruncated           bool            `xml:"IsTruncated"`
+	Contents              []ObjectContent `xml:"Contents"`
+	CommonPrefixes        []CommonPrefix  `xml:"CommonPrefixes"`
+}
+
+type ObjectContent struct {
+	Key          string    `xml:"Key"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+	StorageClass string    `xml:"StorageClass"`
+}
+
+type CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// CopyObjectResult is the response to PUT /{bucket}/{key} with
+// x-amz-copy-source.
+type CopyObjectResult struct {
+	XMLName      xml.Name  `xml:"CopyObjectResult"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+}
+
+// Delete is the request body of POST /{bucket}?delete.
+type Delete struct {
+	XMLName xml.Name           `xml:"Delete"`
+	Quiet   bool               `xml:"Quiet"`
+	Objects []ObjectIdentifier `xml:"Object"`
+}
+
+type ObjectIdentifier struct {
+	Key       string `xml:"Key"`
+	VersionID string `xml:"VersionId,omitempty"`
+}
+
+type DeleteResult struct {
+	XMLName xml.Name        `xml:"DeleteResult"`
+	Deleted []DeletedObject `xml:"Deleted"`
+	Errors  []DeleteError   `xml:"Error"`
+}
+
+type DeletedObject struct {
+	Key                   string `xml:"Key"`
+	VersionID             string `xml:"VersionId,omitempty"`
+	DeleteMarker          bool   `xml:"DeleteMarker,omitempty"`
+	DeleteMarkerVersionID string `xml:"DeleteMarkerVersionId,omitempty"`
+}
+
+type DeleteError struct {
+	Key       string `xml:"Key"`
+	VersionID string `xml:"VersionId,omitempty"`
+	Code      string `xml:"Code"`
+	Message   string `xml:"Message"`
 }
@@ -0,0 +1,14 @@
+package models
+
+import "encoding/xml"
+
+// Tagging is the document of GET and PUT ?tagging on buckets and objects.
+type Tagging struct {
+	XMLName xml.Name `xml:"Tagging"`
+	TagSet  []Tag    `xml:"TagSet>Tag"`
+}
+
+type Tag struct {
+	Key   string `xml:"Key"`
+	Value string `xml:"Value"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// ListUsersResult is the response of GET /_admin/users.
+type ListUsersResult struct {
+	XMLName xml.Name `xml:"ListUsersResult"`
+	Users   []User   `xml:"Users>User"`
+}
+
+type User struct {
+	XMLName    xml.Name            `xml:"User"`
+	UserName   string              `xml:"UserName"`
+	Admin      bool                `xml:"Admin"`
+	CreateDate time.Time           `xml:"CreateDate"`
+	AccessKeys []AccessKeyMetadata `xml:"AccessKeys>AccessKey"`
+}
+
+// AccessKeyMetadata describes a key without its secret.
+type AccessKeyMetadata struct {
+	AccessKeyID string    `xml:"AccessKeyId"`
+	Status      string    `xml:"Status"`
+	CreateDate  time.Time `xml:"CreateDate"`
+}
+
+// AccessKey is the response when a key is issued. It is the only time the
+// secret is shown.
+type AccessKey struct {
+	XMLName         xml.Name  `xml:"AccessKey"`
+	UserName        string    `xml:"UserName"`
+	AccessKeyID     string    `xml:"AccessKeyId"`
+	SecretAccessKey string    `xml:"SecretAccessKey"`
+	Status          string    `xml:"Status"`
+	CreateDate      time.Time `xml:"CreateDate"`
+}
@@ -0,0 +1,78 @@
+package policy
+
+import (
+	"net"
+	"strings"
+)
+
+// operators maps each supported condition operator to its test of one
+// request value against one policy value. Negated operators are listed
+// with the test they negate.
+var operators = map[string]struct {
+	test    func(value, want string) bool
+	negated bool
+}{
+	"StringEquals":              {func(value, want string) bool { return value == want }, false},
+	"StringNotEquals":           {func(value, want string) bool { return value == want }, true},
+	"StringEqualsIgnoreCase":    {strings.EqualFold, false},
+	"StringNotEqualsIgnoreCase": {strings.EqualFold, true},
+	"StringLike":                {func(value, want string) bool { return match(want, value) }, false},
+	"StringNotLike":             {func(value, want string) bool { return match(want, value) }, true},
+	"IpAddress":                 {inNetwork, false},
+	"NotIpAddress":              {inNetwork, true},
+	"Bool":                      {strings.EqualFold, false},
+}
+
+// matchesConditions reports whether every condition holds. A condition
+// holds when any of its values matches, or for a negated operator when none
+// does. A key missing from the request fails a condition, except with an
+// ...IfExists operator or a negated one.
+func (s Statement) matchesConditions(context map[string]string) bool {
+	for _, condition := range s.Conditions {
+		name, ifExists := strings.CutSuffix(condition.Operator, "IfExists")
+		operator := operators[name]
+		value, ok := lookup(context, condition.Key)
+		if !ok {
+			if ifExists || operator.negated {
+				continue
+			}
+			return false
+		}
+		matched := false
+		for _, want := range condition.Values {
+			if operator.test(value, want) {
+				matched = true
+				break
+			}
+		}
+		if matched == operator.negated {
+			return false
+		}
+	}
+	return true
+}
+
+// lookup finds a condition key; key names are case-insensitive.
+func lookup(context map[string]string, key string) (string, bool) {
+	if value, ok := context[key]; ok {
+		return value, true
+	}
+	for name, value := range context {
+		if strings.EqualFold(name, key) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+func inNetwork(value, want string) bool {
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return false
+	}
+	if !strings.Contains(want, "/") {
+		return ip.Equal(net.ParseIP(want))
+	}
+	_, network, err := net.ParseCIDR(want)
+	return err == nil && network.Contains(ip)
+}
@@ -0,0 +1,296 @@
+// Package policy parses and evaluates S3 bucket policies.
+package policy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MaxSize is the largest policy document S3 accepts.
+const MaxSize = 20 << 10
+
+const resourcePrefix = "arn:aws:s3:::"
+
+type Decision int
+
+const (
+	// NotApplicable means no statement matched; the caller applies its
+	// default.
+	NotApplicable Decision = iota
+	Allow
+	Deny
+)
+
+// Request is what a statement is matched against.
+type Request struct {
+	// User is the name of the signer, empty for anonymous requests.
+	User     string
+	Action   string
+	Resource string
+	// Context holds the condition keys that apply to the request, such as
+	// aws:SourceIp or s3:prefix. Keys that do not apply are absent.
+	Context map[string]string
+}
+
+// Policy is a parsed bucket policy.
+type Policy struct {
+	Version    string
+	Statements []Statement
+}
+
+type Statement struct {
+	Sid        string
+	Effect     string
+	Principals []string
+	Actions    []string
+	Resources  []string
+	Conditions []Condition
+}
+
+// Condition is one key of a condition operator block, such as
+// {"IpAddress": {"aws:SourceIp": ["10.0.0.0/8"]}}.
+type Condition struct {
+	Operator string
+	Key      string
+	Values   []string
+}
+
+// Resource returns the ARN of a bucket, or of an object when key is set.
+func Resource(bucketName, key string) string {
+	if key == "" {
+		return resourcePrefix + bucketName
+	}
+	return resourcePrefix + bucketName + "/" + key
+}
+
+// Parse reads a policy document and checks that it only refers to
+// bucketName.
+func Parse(data []byte, bucketName string) (*Policy, error) {
+	if len(data) > MaxSize {
+		return nil, fmt.Errorf("policies are limited to %d bytes", MaxSize)
+	}
+	var document struct {
+		Version   string
+		Id        string
+		Statement json.RawMessage
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("invalid policy document: %w", err)
+	}
+	if document.Version != "2012-10-17" && document.Version != "2008-10-17" {
+		return nil, errors.New(`Version must be "2012-10-17"`)
+	}
+
+	var raw []rawStatement
+	if err := json.Unmarshal(document.Statement, &raw); err != nil {
+		var single rawStatement
+		if err := json.Unmarshal(document.Statement, &single); err != nil {
+			return nil, fmt.Errorf("invalid Statement: %w", err)
+		}
+		raw = []rawStatement{single}
+	}
+	if len(raw) == 0 {
+		return nil, errors.New("a policy needs at least one statement")
+	}
+
+	p := &Policy{Version: document.Version}
+	for i, r := range raw {
+		statement, err := r.parse(bucketName)
+		if err != nil {
+			return nil, fmt.Errorf("statement %d: %w", i+1, err)
+		}
+		p.Statements = append(p.Statements, statement)
+	}
+	return p, nil
+}
+
+type rawStatement struct {
+	Sid       string
+	Effect    string
+	Principal json.RawMessage
+	Action    stringList
+	Resource  stringList
+	Condition map[string]map[string]stringList
+}
+
+func (r rawStatement) parse(bucketName string) (Statement, error) {
+	s := Statement{Sid: r.Sid, Effect: r.Effect, Actions: r.Action, Resources: r.Resource}
+	if s.Effect != "Allow" && s.Effect != "Deny" {
+		return s, errors.New(`Effect must be "Allow" or "Deny"`)
+	}
+
+	principals, err := parsePrincipal(r.Principal)
+	if err != nil {
+		return s, err
+	}
+	s.Principals = principals
+
+	if len(s.Actions) == 0 {
+		return s, errors.New("Action is missing")
+	}
+	for _, action := range s.Actions {
+		if action != "*" && !strings.HasPrefix(strings.ToLower(action), "s3:") {
+			return s, fmt.Errorf("action %q is not an s3: action", action)
+		}
+	}
+
+	if len(s.Resources) == 0 {
+		return s, errors.New("Resource is missing")
+	}
+	for _, resource := range s.Resources {
+		rest, ok := strings.CutPrefix(resource, resourcePrefix)
+		name, _, _ := strings.Cut(rest, "/")
+		if !ok || !match(name, bucketName) {
+			return s, fmt.Errorf("resource %q is not in bucket %s", resource, bucketName)
+		}
+	}
+
+	for operator, keys := range r.Condition {
+		if _, ok := operators[strings.TrimSuffix(operator, "IfExists")]; !ok {
+			return s, fmt.Errorf("unsupported condition operator %q", operator)
+		}
+		for key, values := range keys {
+			s.Conditions = append(s.Conditions, Condition{Operator: operator, Key: key, Values: values})
+		}
+	}
+	return s, nil
+}
+
+// parsePrincipal accepts "*", {"AWS": "*"} and {"AWS": [...]} with user
+// names or IAM user ARNs such as arn:aws:iam::123456789012:user/alice.
+func parsePrincipal(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 {
+		return nil, errors.New("Principal is missing")
+	}
+	var wildcard string
+	if err := json.Unmarshal(raw, &wildcard); err == nil {
+		if wildcard != "*" {
+			return nil, fmt.Errorf("invalid principal %q", wildcard)
+		}
+		return []string{"*"}, nil
+	}
+	var principal struct{ AWS stringList }
+	if err := json.Unmarshal(raw, &principal); err != nil || len(principal.AWS) == 0 {
+		return nil, errors.New(`Principal must be "*" or {"AWS": [...]}`)
+	}
+
+	var users []string
+	for _, value := range principal.AWS {
+		if value == "*" {
+			users = append(users, "*")
+			continue
+		}
+		if strings.HasPrefix(value, "arn:") {
+			_, name, ok := strings.Cut(value, ":user/")
+			if !ok || !strings.HasPrefix(value, "arn:aws:iam::") || name == "" {
+				return nil, fmt.Errorf("invalid principal %q", value)
+			}
+			value = name
+		}
+		users = append(users, value)
+	}
+	return users, nil
+}
+
+// Evaluate applies the statements to a request. An explicit Deny wins over
+// any Allow.
+func (p *Policy) Evaluate(request Request) Decision {
+	decision := NotApplicable
+	for _, s := range p.Statements {
+		if !s.matches(request) {
+			continue
+		}
+		if s.Effect == "Deny" {
+			return Deny
+		}
+		decision = Allow
+	}
+	return decision
+}
+
+func (s Statement) matches(request Request) bool {
+	return matchesPrincipal(s.Principals, request.User) &&
+		matchesAny(s.Actions, request.Action, strings.ToLower) &&
+		matchesAny(s.Resources, request.Resource, nil) &&
+		s.matchesConditions(request.Context)
+}
+
+func matchesPrincipal(principals []string, user string) bool {
+	for _, principal := range principals {
+		if principal == "*" || (user != "" && principal == user) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, value string, fold func(string) string) bool {
+	for _, pattern := range patterns {
+		if fold != nil {
+			pattern, value = fold(pattern), fold(value)
+		}
+		if match(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// match reports whether value matches pattern, where * matches any run of
+// characters and ? any single one.
+func match(pattern, value string) bool {
+	p, v := 0, 0
+	star, mark := -1, 0
+	for v < len(value) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, v
+			p++
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == value[v]):
+			p++
+			v++
+		case star >= 0:
+			mark++
+			p, v = star+1, mark
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// stringList decodes a JSON string, number, boolean or an array of them.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var values []any
+	if err := json.Unmarshal(data, &values); err != nil {
+		var value any
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+		values = []any{value}
+	}
+	for _, value := range values {
+		switch v := value.(type) {
+		case string:
+			*l = append(*l, v)
+		case bool:
+			*l = append(*l, strconv.FormatBool(v))
+		case float64:
+			*l = append(*l, strconv.FormatFloat(v, 'f', -1, 64))
+		default:
+			return fmt.Errorf("unexpected value %s", data)
+		}
+	}
+	return nil
+}
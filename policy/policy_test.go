@@ -0,0 +1,108 @@
+package policy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name, document string
+		err            string
+	}{
+		{"single statement", `{"Version": "2012-10-17", "Statement": {"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/*"}}`, ""},
+		{"statement list", `{"Version": "2012-10-17", "Statement": [{"Effect": "Deny", "Principal": {"AWS": ["alice", "arn:aws:iam::123456789012:user/bob"]}, "Action": ["s3:*"], "Resource": ["arn:aws:s3:::bucket", "arn:aws:s3:::bucket/*"]}]}`, ""},
+		{"condition", `{"Version": "2012-10-17", "Statement": {"Effect": "Allow", "Principal": "*", "Action": "s3:ListBucket", "Resource": "arn:aws:s3:::bucket", "Condition": {"StringLikeIfExists": {"s3:prefix": "public/*"}, "Bool": {"aws:SecureTransport": true}}}}`, ""},
+		{"wildcard bucket", `{"Version": "2012-10-17", "Statement": {"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::buck*/*"}}`, ""},
+
+		{"bad version", `{"Version": "2020-01-01", "Statement": {"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/*"}}`, "Version"},
+		{"unknown field", `{"Version": "2012-10-17", "Extra": 1, "Statement": []}`, "invalid policy document"},
+		{"no statements", `{"Version": "2012-10-17", "Statement": []}`, "at least one statement"},
+		{"bad effect", `{"Version": "2012-10-17", "Statement": {"Effect": "Maybe", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/*"}}`, "Effect"},
+		{"no principal", `{"Version": "2012-10-17", "Statement": {"Effect": "Allow", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/*"}}`, "Principal is missing"},
+		{"bad principal", `{"Version": "2012-10-17", "Statement": {"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::123456789012:role/r"}, "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/*"}}`, "invalid principal"},
+		{"other service", `{"Version": "2012-10-17", "Statement": {"Effect": "Allow", "Principal": "*", "Action": "iam:CreateUser", "Resource": "arn:aws:s3:::bucket/*"}}`, "not an s3: action"},
+		{"other bucket", `{"Version": "2012-10-17", "Statement": {"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::other/*"}}`, "not in bucket"},
+		{"unknown operator", `{"Version": "2012-10-17", "Statement": {"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/*", "Condition": {"DateGreaterThan": {"aws:CurrentTime": "2020-01-01"}}}}`, "unsupported condition operator"},
+	}
+	for _, test := range tests {
+		_, err := Parse([]byte(test.document), "bucket")
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: got %v, want an error containing %q", test.name, err, test.err)
+		}
+	}
+
+	if _, err := Parse([]byte(`{"Version": "2012-10-17", "Id": "`+strings.Repeat("x", MaxSize)+`"}`), "bucket"); err == nil {
+		t.Error("a document over MaxSize was accepted")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	p, err := Parse([]byte(`{
+		"Version": "2012-10-17",
+		"Statement": [
+			{"Effect": "Allow", "Principal": "*", "Action": "s3:GetObject", "Resource": "arn:aws:s3:::bucket/public/*"},
+			{"Effect": "Allow", "Principal": {"AWS": "arn:aws:iam::123456789012:user/alice"}, "Action": ["s3:PutObject", "s3:DeleteObject"], "Resource": "arn:aws:s3:::bucket/*"},
+			{"Effect": "Deny", "Principal": "*", "Action": "s3:*", "Resource": "arn:aws:s3:::bucket/public/secret?",
+			 "Condition": {"NotIpAddress": {"aws:SourceIp": "10.0.0.0/8"}}},
+			{"Effect": "Allow", "Principal": "*", "Action": "s3:ListBucket", "Resource": "arn:aws:s3:::bucket",
+			 "Condition": {"StringLike": {"s3:prefix": "public/*"}, "StringEqualsIgnoreCase": {"aws:username": ["ALICE", "bob"]}}}
+		]
+	}`), "bucket")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	external := map[string]string{"aws:SourceIp": "192.0.2.1"}
+	internal := map[string]string{"aws:SourceIp": "10.1.2.3"}
+	tests := []struct {
+		name     string
+		request  Request
+		decision Decision
+	}{
+		{"anonymous read", Request{Action: "s3:GetObject", Resource: Resource("bucket", "public/a"), Context: external}, Allow},
+		{"action names fold case", Request{Action: "S3:GETOBJECT", Resource: Resource("bucket", "public/a"), Context: external}, Allow},
+		{"outside the resource", Request{Action: "s3:GetObject", Resource: Resource("bucket", "private/a"), Context: external}, NotApplicable},
+		{"resources do not fold case", Request{Action: "s3:GetObject", Resource: Resource("bucket", "Public/a"), Context: external}, NotApplicable},
+		{"anonymous write", Request{Action: "s3:PutObject", Resource: Resource("bucket", "a"), Context: external}, NotApplicable},
+		{"named user", Request{User: "alice", Action: "s3:PutObject", Resource: Resource("bucket", "a"), Context: external}, Allow},
+		{"other user", Request{User: "bob", Action: "s3:PutObject", Resource: Resource("bucket", "a"), Context: external}, NotApplicable},
+		{"deny wins", Request{User: "alice", Action: "s3:DeleteObject", Resource: Resource("bucket", "public/secret1"), Context: external}, Deny},
+		{"deny condition does not hold", Request{User: "alice", Action: "s3:DeleteObject", Resource: Resource("bucket", "public/secret1"), Context: internal}, Allow},
+		{"negated condition without the key", Request{Action: "s3:GetObject", Resource: Resource("bucket", "public/secret1")}, Deny},
+		{"? is one character", Request{Action: "s3:GetObject", Resource: Resource("bucket", "public/secret12"), Context: external}, Allow},
+		{"all conditions hold", Request{User: "alice", Action: "s3:ListBucket", Resource: Resource("bucket", ""), Context: map[string]string{"s3:prefix": "public/x", "aws:username": "Alice"}}, Allow},
+		{"one condition fails", Request{User: "carol", Action: "s3:ListBucket", Resource: Resource("bucket", ""), Context: map[string]string{"s3:prefix": "public/x", "aws:username": "carol"}}, NotApplicable},
+		{"condition key missing", Request{User: "alice", Action: "s3:ListBucket", Resource: Resource("bucket", ""), Context: map[string]string{"aws:username": "alice"}}, NotApplicable},
+		{"condition keys fold case", Request{User: "bob", Action: "s3:ListBucket", Resource: Resource("bucket", ""), Context: map[string]string{"S3:Prefix": "public/", "AWS:UserName": "bob"}}, Allow},
+	}
+	for _, test := range tests {
+		if got := p.Evaluate(test.request); got != test.decision {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.decision)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, value string
+		want           bool
+	}{
+		{"*", "", true},
+		{"a*c", "abbbc", true},
+		{"a*c", "abbb", false},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"*/*", "dir/file", true},
+		{"*b*b", "abab", true},
+		{"public/*", "public", false},
+	}
+	for _, test := range tests {
+		if got := match(test.pattern, test.value); got != test.want {
+			t.Errorf("match(%q, %q) = %v, want %v", test.pattern, test.value, got, test.want)
+		}
+	}
+}
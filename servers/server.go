@@ -9,25 +9,43 @@ import (
 	"syscall"
 	"time"
 	"triple-s/handlers"
+	"triple-s/lifecycle"
+	"triple-s/storage"
 )
 
-func Start(Port string) {
+func Start(Port string, stallTimeout, lifecycleInterval time.Duration) {
+	if err := storage.Journal.Recover(); err != nil {
+		log.Fatalf("Journal recovery failed: %v", err)
+	}
+	if lifecycleInterval > 0 {
+		lifecycle.Background = lifecycle.NewScanner(lifecycleInterval)
+		lifecycle.Background.Start()
+	}
+
 	http.HandleFunc("/", handlers.MyHandler)
 	http.HandleFunc("/health", handlers.HealthCheckHandler)
+	http.HandleFunc("/_admin/", handlers.AdminHandler)
 
 	srv := &http.Server{
-		Addr:         ":" + Port,
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:              ":" + Port,
+		Handler:           withStallTimeout(http.DefaultServeMux, stallTimeout),
+		ReadHeaderTimeout: 15 * time.Second,
+		IdleTimeout:       60 * time.Second,
 	}
 
-	go gracefulShutdown(srv)
+	// ListenAndServe returns as soon as shutdown begins; wait for it to
+	// finish before the caller closes the metadata store.
+	stopped := make(chan struct{})
+	go func() {
+		gracefulShutdown(srv)
+		close(stopped)
+	}()
 
 	log.Printf("Starting server on port %s...", Port)
 	if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 		log.Fatalf("Server failed: %v", err)
 	}
+	<-stopped
 
 	log.Println("Server stopped gracefully")
 }
@@ -45,4 +63,9 @@ func gracefulShutdown(srv *http.Server) {
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
+	if lifecycle.Background != nil {
+		if err := lifecycle.Background.Stop(ctx); err != nil {
+			log.Fatalf("Lifecycle scanner did not stop: %v", err)
+		}
+	}
 }
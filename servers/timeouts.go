@@ -0,0 +1,70 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"time"
+)
+
+// withStallTimeout replaces fixed whole-request timeouts with progress
+// deadlines: every chunk read from the body or written to the response
+// pushes the connection deadlines out by stall. A steady multi-gigabyte
+// transfer can run as long as it needs, while a client that stops sending
+// or reading is dropped after stall.
+func withStallTimeout(next http.Handler, stall time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		p := &progress{rc: rc, stall: stall}
+		p.extend(true)
+
+		r.Body = &stallReader{ReadCloser: r.Body, progress: p}
+		next.ServeHTTP(&stallWriter{ResponseWriter: w, progress: p}, r)
+	})
+}
+
+type progress struct {
+	rc    *http.ResponseController
+	stall time.Duration
+}
+
+// extend pushes the write deadline out and, while the body is still being
+// read, the read deadline too. The write deadline follows reads as well,
+// otherwise a long upload would leave no time to send the response.
+func (p *progress) extend(reading bool) {
+	deadline := time.Now().Add(p.stall)
+	if reading {
+		p.rc.SetReadDeadline(deadline)
+	}
+	p.rc.SetWriteDeadline(deadline)
+}
+
+type stallReader struct {
+	io.ReadCloser
+	progress *progress
+}
+
+func (s *stallReader) Read(b []byte) (int, error) {
+	n, err := s.ReadCloser.Read(b)
+	if n > 0 {
+		s.progress.extend(true)
+	}
+	return n, err
+}
+
+type stallWriter struct {
+	http.ResponseWriter
+	progress *progress
+}
+
+func (s *stallWriter) Write(b []byte) (int, error) {
+	n, err := s.ResponseWriter.Write(b)
+	if n > 0 {
+		s.progress.extend(false)
+	}
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying connection.
+func (s *stallWriter) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"errors"
+	"triple-s/models"
+)
+
+// Canned ACLs, the only kind of ACL supported. An empty ACL is private.
+const (
+	ACLPrivate           = "private"
+	ACLPublicRead        = "public-read"
+	ACLPublicReadWrite   = "public-read-write"
+	ACLAuthenticatedRead = "authenticated-read"
+)
+
+var ErrNoSuchObject = errors.New("object does not exist")
+
+func IsCannedACL(acl string) bool {
+	switch acl {
+	case ACLPrivate, ACLPublicRead, ACLPublicReadWrite, ACLAuthenticatedRead:
+		return true
+	}
+	return false
+}
+
+func SetBucketACL(bucketName, acl string) error {
+	return Metadata.UpdateBucket(bucketName, func(bucket *models.Bucket) error {
+		bucket.ACL = acl
+		return nil
+	})
+}
+
+// SetObjectACL replaces the ACL of the current version of objectKey, or of
+// the version versionID when it is set.
+func SetObjectACL(bucketName, objectKey, versionID, acl string) error {
+	return updateObject(bucketName, objectKey, versionID, func(object *models.ObjectCSV) {
+		object.ACL = acl
+	})
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tempPrefix returns the prefix of temp files created next to path while it
+// is being replaced.
+func tempPrefix(path string) string {
+	return "." + filepath.Base(path) + "-"
+}
+
+// WriteFileAtomic replaces path with whatever write produces. The data goes
+// to a temp file in the same directory, is fsynced and then renamed over
+// path, so readers and crashes only ever see the old or the new content.
+func WriteFileAtomic(path string, write func(io.Writer) error) error {
+	return WriteFileAtomicMode(path, 0o644, write)
+}
+
+// WriteFileAtomicMode is WriteFileAtomic for files that need another mode.
+func WriteFileAtomicMode(path string, mode os.FileMode, write func(io.Writer) error) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, tempPrefix(path)+"*")
+	if err != nil {
+		return fmt.Errorf("could not create temp file: %w", err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err := write(tmp); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		return fmt.Errorf("could not set temp file mode: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("could not sync temp file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not close temp file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("could not replace %s: %w", filepath.Base(path), err)
+	}
+	committed = true
+	return syncDir(dir)
+}
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("could not sync directory %s: %w", dir, err)
+	}
+	return nil
+}
+
+// isInternalFile reports whether name is objects.csv or versions.csv, one of
+// the temp files used while replacing them, an upload that has not been
+// renamed yet, the multipart staging directory or the noncurrent version
+// store.
+func isInternalFile(name string) bool {
+	return name == objectsFile ||
+		name == versionsFile ||
+		name == multipartDir ||
+		name == versionsDir ||
+		strings.HasPrefix(name, tempPrefix(objectsFile)) ||
+		strings.HasPrefix(name, tempPrefix(versionsFile)) ||
+		strings.HasPrefix(name, uploadTempPrefix)
+}
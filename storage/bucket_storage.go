@@ -1,7 +1,6 @@
 package storage
 
 import (
-	"encoding/csv"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -10,87 +9,174 @@ import (
 	"triple-s/models"
 )
 
-func UpdateBucketCSV(bucketName string, updatedData models.Bucket) error {
-	csvFilePath := filepath.Join(flags.StorageDir, "buckets.csv")
-	file, err := os.OpenFile(csvFilePath, os.O_RDWR|os.O_CREATE, 0o644)
-	if err != nil {
-		return fmt.Errorf("could not open CSV file: %w", err)
-	}
-	defer file.Close()
+// SystemDir holds server-internal state inside StorageDir. Bucket names
+// cannot start with a dot, so it never collides with a bucket.
+const SystemDir = ".triple-s"
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-	if err != nil {
-		return fmt.Errorf("could not read CSV file: %w", err)
-	}
-
-	updated := false
-	for i, record := range records {
-		if len(record) > 0 && record[0] == bucketName {
-			records[i] = []string{
-				updatedData.Name,
-				updatedData.CreationDate.Format(time.RFC3339),
-				updatedData.ContentStatus,
-				updatedData.LastModified.Format(time.RFC3339),
-			}
-			updated = true
-			break
+// ValidBucketName applies the S3 naming rules: 3 to 63 lowercase letters,
+// digits, dots and hyphens, starting and ending with a letter or digit.
+func ValidBucketName(bucketName string) bool {
+	if len(bucketName) < 3 || len(bucketName) > 63 {
+		return false
+	}
+	if !isLowercaseLetterOrDigit(bucketName[0]) || !isLowercaseLetterOrDigit(bucketName[len(bucketName)-1]) {
+		return false
+	}
+	for i := 0; i < len(bucketName); i++ {
+		char := bucketName[i]
+		if !(isLowercaseLetterOrDigit(char) || char == '-' || char == '.') {
+			return false
 		}
 	}
+	return true
+}
 
-	if !updated {
-		records = append(records, []string{
-			updatedData.Name,
-			updatedData.CreationDate.Format(time.RFC3339),
-			updatedData.ContentStatus,
-			updatedData.LastModified.Format(time.RFC3339),
-		})
+func isLowercaseLetterOrDigit(char byte) bool {
+	return (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9')
+}
+
+func BucketPath(bucketName string) string {
+	return filepath.Join(flags.StorageDir, bucketName)
+}
+
+// CreateBucket makes the bucket directory and records its metadata as one
+// journaled step.
+func CreateBucket(bucket models.Bucket) error {
+	entry, err := Journal.Begin(JournalEntry{Op: OpCreateBucket, Bucket: bucket.Name})
+	if err != nil {
+		return err
 	}
 
-	if err := file.Truncate(0); err != nil {
-		return fmt.Errorf("could not truncate file: %w", err)
+	if err := os.Mkdir(BucketPath(bucket.Name), 0o755); err != nil {
+		Journal.Abort(entry.ID)
+		return fmt.Errorf("could not create bucket directory: %w", err)
+	}
+	if err := Metadata.PutBucket(bucket); err != nil {
+		return fmt.Errorf("could not write bucket metadata: %w", err)
 	}
+	return Journal.Commit(entry.ID)
+}
 
-	if _, err := file.Seek(0, 0); err != nil {
-		return fmt.Errorf("could not seek to beginning of file: %w", err)
+// RemoveBucket deletes an empty bucket's directory and metadata as one
+// journaled step. It returns ErrBucketNotEmpty while the bucket holds
+// objects, versions or multipart uploads. The check and the removal happen
+// under the bucket's removal lock, so no write can land in between.
+func RemoveBucket(bucketName string) error {
+	defer Locks.LockBucketRemoval(bucketName)()
+	if err := checkBucketEmpty(bucketName); err != nil {
+		return err
 	}
 
-	writer := csv.NewWriter(file)
-	if err := writer.WriteAll(records); err != nil {
-		return fmt.Errorf("could not write CSV file: %w", err)
+	entry, err := Journal.Begin(JournalEntry{Op: OpDeleteBucket, Bucket: bucketName})
+	if err != nil {
+		return err
 	}
-	writer.Flush()
 
-	return nil
+	if err := os.RemoveAll(BucketPath(bucketName)); err != nil {
+		return fmt.Errorf("could not delete bucket directory: %w", err)
+	}
+	if err := Metadata.DeleteBucket(bucketName); err != nil {
+		return fmt.Errorf("could not remove bucket metadata: %w", err)
+	}
+	return Journal.Commit(entry.ID)
 }
 
-func RemoveBucketCSV(bucketName string) error {
-	csvPath := filepath.Join(flags.StorageDir, "buckets.csv")
-	file, err := os.Open(csvPath)
+func checkBucketEmpty(bucketName string) error {
+	isEmpty, err := IsBucketEmptyIgnoringObjectsCSV(BucketPath(bucketName))
+	if err != nil {
+		return fmt.Errorf("could not check if bucket is empty: %w", err)
+	}
+	if !isEmpty {
+		return ErrBucketNotEmpty
+	}
+	hasVersions, err := HasVersions(bucketName)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	if hasVersions {
+		return fmt.Errorf("%w: it still has object versions or delete markers", ErrBucketNotEmpty)
+	}
+	uploads, err := ListMultipartUploads(bucketName)
 	if err != nil {
 		return err
 	}
+	if len(uploads) > 0 {
+		return fmt.Errorf("%w: it has multipart uploads in progress", ErrBucketNotEmpty)
+	}
+	return nil
+}
 
-	file, err = os.OpenFile(csvPath, os.O_WRONLY|os.O_TRUNC, 0o644)
+// checkBucketExists returns ErrBucketNotFound once bucketName has been
+// removed. Writes call it under LockBucketWrite.
+func checkBucketExists(bucketName string) error {
+	_, ok, err := Metadata.GetBucket(bucketName)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
+	if !ok {
+		return ErrBucketNotFound
+	}
+	return nil
+}
 
-	writer := csv.NewWriter(file)
-	for _, record := range records {
-		if record[0] != bucketName {
-			writer.Write(record)
+// BucketsOwnedBy returns the names of the buckets created by user.
+func BucketsOwnedBy(user string) ([]string, error) {
+	buckets, err := Metadata.ListBuckets()
+	if err != nil {
+		return nil, err
+	}
+	var owned []string
+	for _, bucket := range buckets {
+		if bucket.Owner == user {
+			owned = append(owned, bucket.Name)
 		}
 	}
-	writer.Flush()
-	return writer.Error()
+	return owned, nil
+}
+
+// SetBucketPolicy stores a policy document, or removes it when document is
+// empty. The caller validates it.
+func SetBucketPolicy(bucketName, document string) error {
+	return Metadata.UpdateBucket(bucketName, func(bucket *models.Bucket) error {
+		bucket.Policy = document
+		return nil
+	})
+}
+
+// SetBucketCORS stores a CORS configuration, or removes it when document is
+// empty. The caller validates it.
+func SetBucketCORS(bucketName, document string) error {
+	return Metadata.UpdateBucket(bucketName, func(bucket *models.Bucket) error {
+		bucket.CORS = document
+		return nil
+	})
+}
+
+// SetBucketLifecycle stores a lifecycle configuration, or removes it when
+// document is empty. The caller validates it.
+func SetBucketLifecycle(bucketName, document string) error {
+	return Metadata.UpdateBucket(bucketName, func(bucket *models.Bucket) error {
+		bucket.Lifecycle = document
+		return nil
+	})
+}
+
+// RefreshBucketStatus recomputes ContentStatus from the bucket directory
+// and bumps LastModified. Both happen under the buckets.csv lock so
+// concurrent uploads and deletes cannot leave a stale status behind.
+func RefreshBucketStatus(bucketName string) error {
+	return Metadata.UpdateBucket(bucketName, func(bucket *models.Bucket) error {
+		isEmpty, err := IsBucketEmptyIgnoringObjectsCSV(BucketPath(bucketName))
+		if err != nil {
+			return fmt.Errorf("could not check if bucket is empty: %w", err)
+		}
+		bucket.ContentStatus = "active"
+		if isEmpty {
+			bucket.ContentStatus = "inactive"
+		}
+		bucket.LastModified = time.Now()
+		return nil
+	})
 }
 
 func IsBucketEmptyIgnoringObjectsCSV(bucketPath string) (bool, error) {
@@ -99,7 +185,7 @@ func IsBucketEmptyIgnoringObjectsCSV(bucketPath string) (bool, error) {
 		return false, err
 	}
 	for _, file := range files {
-		if file.Name() != "objects.csv" {
+		if !isInternalFile(file.Name()) {
 			return false, nil
 		}
 	}
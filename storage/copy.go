@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"triple-s/models"
+)
+
+// CopyObject stores the data of source, a version of a key in sourceBucket
+// as returned by GetObject or GetObjectVersion, as object in bucketName.
+// object takes over the size and digests of source; everything else is
+// stored as given. If source has been replaced or deleted since it was
+// read, ErrPreconditionFailed is returned. check is applied to the
+// destination as in StoreObject.
+func CopyObject(sourceBucket string, source models.ObjectCSV, bucketName string, object models.ObjectCSV, check Precondition) (models.ObjectCSV, error) {
+	object.ObjectSize = source.ObjectSize
+	object.ETag = source.ETag
+	object.SHA256 = source.SHA256
+	object.Checksums = source.Checksums
+
+	return writeObject(bucketName, object, "", check, func(tempPath string, _ *models.ObjectCSV) error {
+		unlock := Locks.LockObject(sourceBucket, source.ObjectKey)
+		defer unlock()
+
+		current, path, err := GetObjectVersion(sourceBucket, source.ObjectKey, VersionID(source))
+		if errors.Is(err, ErrNoSuchVersion) {
+			return ErrPreconditionFailed
+		}
+		if err != nil {
+			return err
+		}
+		if path == "" || current.ETag != source.ETag || current.ObjectSize != source.ObjectSize {
+			return ErrPreconditionFailed
+		}
+		return linkOrCopy(path, tempPath)
+	})
+}
+
+// linkOrCopy makes target a hard link to source. Object files are only ever
+// replaced by rename, never written in place, so two keys or versions can
+// share one inode safely. When linking fails, for example because buckets
+// live on different file systems, the data is copied file to file, which
+// Go does with copy_file_range and which file systems with reflink support
+// turn into a clone.
+func linkOrCopy(source, target string) error {
+	if err := os.Link(source, target); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("could not open copy source: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return fmt.Errorf("could not create object file: %w", err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return fmt.Errorf("could not copy object data: %w", err)
+	}
+	if err := out.Sync(); err != nil {
+		return fmt.Errorf("could not sync object data: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("could not close object file: %w", err)
+	}
+	return nil
+}
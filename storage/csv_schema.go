@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"triple-s/models"
+)
+
+// Every CSV file starts with a "#schema=<table>/<version>" line followed by
+// a header row naming the columns. Files without the marker are version 1:
+// headerless, with the column order the server used before the marker was
+// introduced. Readers always look values up by column name.
+const (
+	schemaMarker  = "#schema="
+	schemaVersion = 11
+
+	bucketsTable  = "buckets"
+	objectsTable  = "objects"
+	versionsTable = "versions"
+)
+
+var (
+	bucketColumns = []string{"name", "created", "status", "modified", "versioning", "owner", "policy", "acl", "cors", "lifecycle", "tags"}
+	objectColumns = []string{
+		"key", "size", "content_type", "created", "modified", "etag", "sha256",
+		"cache_control", "content_disposition", "content_encoding", "content_language", "user_metadata",
+		"checksums", "version_id", "delete_marker", "acl", "tags",
+	}
+
+	legacyColumns = map[string][]string{
+		bucketsTable: {"name", "created", "status", "modified"},
+		objectsTable: {"key", "size", "content_type", "modified"},
+	}
+)
+
+// csvRow maps column names to values. Missing columns read as "".
+type csvRow map[string]string
+
+type csvTable struct {
+	version int
+	rows    []csvRow
+}
+
+func readTable(csvPath, table string) (csvTable, error) {
+	data, err := os.ReadFile(csvPath)
+	if os.IsNotExist(err) {
+		return csvTable{version: schemaVersion}, nil
+	}
+	if err != nil {
+		return csvTable{}, fmt.Errorf("could not open CSV file: %w", err)
+	}
+
+	result := csvTable{version: 1}
+	header := legacyColumns[table]
+	if bytes.HasPrefix(data, []byte(schemaMarker)) {
+		line, rest, _ := bytes.Cut(data, []byte("\n"))
+		version, err := parseSchemaMarker(strings.TrimSpace(string(line)), table)
+		if err != nil {
+			return csvTable{}, fmt.Errorf("%s: %w", csvPath, err)
+		}
+		result.version = version
+		data = rest
+		header = nil
+	}
+
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return csvTable{}, fmt.Errorf("could not read CSV file: %w", err)
+	}
+	if header == nil {
+		if len(records) == 0 {
+			return result, nil
+		}
+		header, records = records[0], records[1:]
+	}
+
+	for _, record := range records {
+		if len(record) == 0 || (len(record) == 1 && record[0] == "") {
+			continue
+		}
+		row := make(csvRow, len(header))
+		for i, column := range header {
+			if i < len(record) {
+				row[column] = record[i]
+			}
+		}
+		result.rows = append(result.rows, row)
+	}
+	return result, nil
+}
+
+func parseSchemaMarker(line, table string) (int, error) {
+	name, version, ok := strings.Cut(strings.TrimPrefix(line, schemaMarker), "/")
+	if !ok || name != table {
+		return 0, fmt.Errorf("unexpected schema marker %q", line)
+	}
+	v, err := strconv.Atoi(version)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected schema marker %q", line)
+	}
+	if v > schemaVersion {
+		return 0, fmt.Errorf("schema version %d is newer than this server supports (%d)", v, schemaVersion)
+	}
+	return v, nil
+}
+
+func writeTable(csvPath, table string, columns []string, rows []csvRow) error {
+	return WriteFileAtomic(csvPath, func(w io.Writer) error {
+		if _, err := fmt.Fprintf(w, "%s%s/%d\n", schemaMarker, table, schemaVersion); err != nil {
+			return fmt.Errorf("could not write CSV file: %w", err)
+		}
+		writer := csv.NewWriter(w)
+		writer.Write(columns)
+		for _, row := range rows {
+			record := make([]string, len(columns))
+			for i, column := range columns {
+				record[i] = row[column]
+			}
+			writer.Write(record)
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			return fmt.Errorf("could not write CSV file: %w", err)
+		}
+		return nil
+	})
+}
+
+func parseTime(value string) time.Time {
+	t, _ := time.Parse(time.RFC3339, value)
+	return t
+}
+
+// formatTime keeps sub-second precision so versions written within the
+// same second still order correctly.
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339Nano)
+}
+
+// formatFlag leaves false cells empty.
+func formatFlag(b bool) string {
+	if b {
+		return "true"
+	}
+	return ""
+}
+
+func bucketFromRow(row csvRow) models.Bucket {
+	return models.Bucket{
+		Name:          row["name"],
+		CreationDate:  parseTime(row["created"]),
+		ContentStatus: row["status"],
+		LastModified:  parseTime(row["modified"]),
+		Versioning:    row["versioning"],
+		Owner:         row["owner"],
+		Policy:        row["policy"],
+		ACL:           row["acl"],
+		CORS:          row["cors"],
+		Lifecycle:     row["lifecycle"],
+		Tags:          decodeValues(row["tags"]),
+	}
+}
+
+func bucketToRow(bucket models.Bucket) csvRow {
+	return csvRow{
+		"name":       bucket.Name,
+		"created":    formatTime(bucket.CreationDate),
+		"status":     bucket.ContentStatus,
+		"modified":   formatTime(bucket.LastModified),
+		"versioning": bucket.Versioning,
+		"owner":      bucket.Owner,
+		"policy":     bucket.Policy,
+		"acl":        bucket.ACL,
+		"cors":       bucket.CORS,
+		"lifecycle":  bucket.Lifecycle,
+		"tags":       encodeValues(bucket.Tags),
+	}
+}
+
+func objectFromRow(row csvRow) models.ObjectCSV {
+	size, _ := strconv.ParseInt(row["size"], 10, 64)
+	return models.ObjectCSV{
+		ObjectKey:    row["key"],
+		ObjectSize:   size,
+		ContentType:  row["content_type"],
+		CreationDate: parseTime(row["created"]),
+		LastModified: parseTime(row["modified"]),
+		ETag:         row["etag"],
+		SHA256:       row["sha256"],
+
+		CacheControl:       row["cache_control"],
+		ContentDisposition: row["content_disposition"],
+		ContentEncoding:    row["content_encoding"],
+		ContentLanguage:    row["content_language"],
+		UserMetadata:       decodeValues(row["user_metadata"]),
+		Checksums:          decodeValues(row["checksums"]),
+		VersionID:          row["version_id"],
+		DeleteMarker:       row["delete_marker"] == "true",
+		ACL:                row["acl"],
+		Tags:               decodeValues(row["tags"]),
+	}
+}
+
+func objectToRow(object models.ObjectCSV) csvRow {
+	return csvRow{
+		"key":          object.ObjectKey,
+		"size":         strconv.FormatInt(object.ObjectSize, 10),
+		"content_type": object.ContentType,
+		"created":      formatTime(object.CreationDate),
+		"modified":     formatTime(object.LastModified),
+		"etag":         object.ETag,
+		"sha256":       object.SHA256,
+
+		"cache_control":       object.CacheControl,
+		"content_disposition": object.ContentDisposition,
+		"content_encoding":    object.ContentEncoding,
+		"content_language":    object.ContentLanguage,
+		"user_metadata":       encodeValues(object.UserMetadata),
+		"checksums":           encodeValues(object.Checksums),
+		"version_id":          object.VersionID,
+		"delete_marker":       formatFlag(object.DeleteMarker),
+		"acl":                 object.ACL,
+		"tags":                encodeValues(object.Tags),
+	}
+}
+
+// Maps such as user metadata are stored in a single column, query-string
+// encoded.
+func encodeValues(m map[string]string) string {
+	values := make(url.Values, len(m))
+	for name, value := range m {
+		values.Set(name, value)
+	}
+	return values.Encode()
+}
+
+func decodeValues(value string) map[string]string {
+	values, err := url.ParseQuery(value)
+	if err != nil || len(values) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(values))
+	for name := range values {
+		m[name] = values.Get(name)
+	}
+	return m
+}
+
+// upgrade rewrites every CSV file older than schemaVersion. Version 1
+// object rows stored a size that was never refreshed on overwrite and had
+// no creation date, so sizes are taken from the files on disk and the
+// modification time stands in for the creation time. Later versions only
+// add columns, which read as empty.
+func (s *CSVStore) upgrade() error {
+	buckets, err := readTable(s.bucketsPath(), bucketsTable)
+	if err != nil {
+		return err
+	}
+	if buckets.version < schemaVersion {
+		if err := s.rewriteBuckets(buckets.rows); err != nil {
+			return err
+		}
+		log.Printf("Upgraded %s to schema version %d", s.bucketsPath(), schemaVersion)
+	}
+
+	for _, row := range buckets.rows {
+		bucketName := row["name"]
+		csvPath := s.objectsPath(bucketName)
+		objects, err := readTable(csvPath, objectsTable)
+		if err != nil {
+			return err
+		}
+		if objects.version >= schemaVersion {
+			continue
+		}
+		if objects.version == 1 {
+			for _, object := range objects.rows {
+				if info, err := os.Stat(ObjectPath(bucketName, object["key"])); err == nil {
+					object["size"] = strconv.FormatInt(info.Size(), 10)
+				}
+				if object["created"] == "" {
+					object["created"] = object["modified"]
+				}
+			}
+		}
+		if err := s.rewriteObjects(bucketName, objects.rows); err != nil {
+			return err
+		}
+		log.Printf("Upgraded %s to schema version %d", csvPath, schemaVersion)
+	}
+	return nil
+}
+
+func (s *CSVStore) rewriteBuckets(rows []csvRow) error {
+	defer Locks.LockBuckets()()
+	return writeTable(s.bucketsPath(), bucketsTable, bucketColumns, rows)
+}
+
+func (s *CSVStore) rewriteObjects(bucketName string, rows []csvRow) error {
+	defer Locks.LockBucket(bucketName)()
+	return writeTable(s.objectsPath(bucketName), objectsTable, objectColumns, rows)
+}
@@ -0,0 +1,306 @@
+package storage
+
+import (
+	"path/filepath"
+	"triple-s/models"
+)
+
+// bucketsFile holds the bucket metadata in the CSV store.
+const bucketsFile = "buckets.csv"
+
+// CSVStore keeps bucket metadata in buckets.csv at the root of the storage
+// directory and object metadata in an objects.csv inside each bucket. The
+// file layout is described in csv_schema.go.
+type CSVStore struct {
+	dir string
+}
+
+func NewCSVStore(dir string) *CSVStore {
+	return &CSVStore{dir: dir}
+}
+
+// OpenCSVStore returns a CSVStore after upgrading any files written with an
+// older schema version.
+func OpenCSVStore(dir string) (*CSVStore, error) {
+	s := NewCSVStore(dir)
+	if err := s.upgrade(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *CSVStore) bucketsPath() string {
+	return filepath.Join(s.dir, bucketsFile)
+}
+
+func (s *CSVStore) objectsPath(bucketName string) string {
+	return filepath.Join(s.dir, bucketName, objectsFile)
+}
+
+func (s *CSVStore) versionsPath(bucketName string) string {
+	return filepath.Join(s.dir, bucketName, versionsFile)
+}
+
+func (s *CSVStore) ListBuckets() ([]models.Bucket, error) {
+	table, err := readTable(s.bucketsPath(), bucketsTable)
+	if err != nil {
+		return nil, err
+	}
+
+	var buckets []models.Bucket
+	for _, row := range table.rows {
+		buckets = append(buckets, bucketFromRow(row))
+	}
+	return buckets, nil
+}
+
+func (s *CSVStore) GetBucket(bucketName string) (models.Bucket, bool, error) {
+	buckets, err := s.ListBuckets()
+	if err != nil {
+		return models.Bucket{}, false, err
+	}
+	for _, bucket := range buckets {
+		if bucket.Name == bucketName {
+			return bucket, true, nil
+		}
+	}
+	return models.Bucket{}, false, nil
+}
+
+func (s *CSVStore) PutBucket(bucket models.Bucket) error {
+	defer Locks.LockBuckets()()
+
+	table, err := readTable(s.bucketsPath(), bucketsTable)
+	if err != nil {
+		return err
+	}
+
+	updated := false
+	for i, row := range table.rows {
+		if row["name"] == bucket.Name {
+			table.rows[i] = bucketToRow(bucket)
+			updated = true
+			break
+		}
+	}
+	if !updated {
+		table.rows = append(table.rows, bucketToRow(bucket))
+	}
+
+	return writeTable(s.bucketsPath(), bucketsTable, bucketColumns, table.rows)
+}
+
+func (s *CSVStore) UpdateBucket(bucketName string, update func(*models.Bucket) error) error {
+	defer Locks.LockBuckets()()
+
+	table, err := readTable(s.bucketsPath(), bucketsTable)
+	if err != nil {
+		return err
+	}
+
+	for i, row := range table.rows {
+		if row["name"] == bucketName {
+			bucket := bucketFromRow(row)
+			if err := update(&bucket); err != nil {
+				return err
+			}
+			table.rows[i] = bucketToRow(bucket)
+			return writeTable(s.bucketsPath(), bucketsTable, bucketColumns, table.rows)
+		}
+	}
+	return ErrBucketNotFound
+}
+
+func (s *CSVStore) DeleteBucket(bucketName string) error {
+	defer Locks.LockBuckets()()
+
+	table, err := readTable(s.bucketsPath(), bucketsTable)
+	if err != nil {
+		return err
+	}
+
+	kept := table.rows[:0]
+	for _, row := range table.rows {
+		if row["name"] != bucketName {
+			kept = append(kept, row)
+		}
+	}
+	return writeTable(s.bucketsPath(), bucketsTable, bucketColumns, kept)
+}
+
+func (s *CSVStore) ListObjects(bucketName string) ([]models.ObjectCSV, error) {
+	table, err := readTable(s.objectsPath(bucketName), objectsTable)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []models.ObjectCSV
+	for _, row := range table.rows {
+		objects = append(objects, objectFromRow(row))
+	}
+	return objects, nil
+}
+
+func (s *CSVStore) GetObject(bucketName, objectKey string) (models.ObjectCSV, bool, error) {
+	objects, err := s.ListObjects(bucketName)
+	if err != nil {
+		return models.ObjectCSV{}, false, err
+	}
+	for _, object := range objects {
+		if object.ObjectKey == objectKey {
+			return object, true, nil
+		}
+	}
+	return models.ObjectCSV{}, false, nil
+}
+
+func (s *CSVStore) PutObject(bucketName string, object models.ObjectCSV) error {
+	defer Locks.LockBucket(bucketName)()
+
+	csvPath := s.objectsPath(bucketName)
+	table, err := readTable(csvPath, objectsTable)
+	if err != nil {
+		return err
+	}
+
+	updated := false
+	for i, row := range table.rows {
+		if row["key"] == object.ObjectKey {
+			table.rows[i] = objectToRow(object)
+			updated = true
+			break
+		}
+	}
+	if !updated {
+		table.rows = append(table.rows, objectToRow(object))
+	}
+
+	return writeTable(csvPath, objectsTable, objectColumns, table.rows)
+}
+
+func (s *CSVStore) DeleteObject(bucketName, objectKey string) error {
+	defer Locks.LockBucket(bucketName)()
+
+	csvPath := s.objectsPath(bucketName)
+	table, err := readTable(csvPath, objectsTable)
+	if err != nil {
+		return err
+	}
+
+	kept := table.rows[:0]
+	for _, row := range table.rows {
+		if row["key"] != objectKey {
+			kept = append(kept, row)
+		}
+	}
+	return writeTable(csvPath, objectsTable, objectColumns, kept)
+}
+
+func (s *CSVStore) DeleteObjects(bucketName string, keys []string, versions []models.ObjectCSV) error {
+	defer Locks.LockBucket(bucketName)()
+
+	deleted := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		deleted[key] = true
+	}
+	csvPath := s.objectsPath(bucketName)
+	table, err := readTable(csvPath, objectsTable)
+	if err != nil {
+		return err
+	}
+	kept := table.rows[:0]
+	for _, row := range table.rows {
+		if !deleted[row["key"]] {
+			kept = append(kept, row)
+		}
+	}
+	if len(kept) != len(table.rows) {
+		if err := writeTable(csvPath, objectsTable, objectColumns, kept); err != nil {
+			return err
+		}
+	}
+	if len(versions) == 0 {
+		return nil
+	}
+
+	csvPath = s.versionsPath(bucketName)
+	table, err = readTable(csvPath, versionsTable)
+	if err != nil {
+		return err
+	}
+	index := make(map[string]int, len(table.rows))
+	for i, row := range table.rows {
+		index[row["key"]+"\x00"+row["version_id"]] = i
+	}
+	for _, version := range versions {
+		if i, ok := index[version.ObjectKey+"\x00"+version.VersionID]; ok {
+			table.rows[i] = objectToRow(version)
+			continue
+		}
+		index[version.ObjectKey+"\x00"+version.VersionID] = len(table.rows)
+		table.rows = append(table.rows, objectToRow(version))
+	}
+	return writeTable(csvPath, versionsTable, objectColumns, table.rows)
+}
+
+func (s *CSVStore) ListVersions(bucketName string) ([]models.ObjectCSV, error) {
+	table, err := readTable(s.versionsPath(bucketName), versionsTable)
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []models.ObjectCSV
+	for _, row := range table.rows {
+		versions = append(versions, objectFromRow(row))
+	}
+	return versions, nil
+}
+
+func (s *CSVStore) PutVersion(bucketName string, version models.ObjectCSV) error {
+	defer Locks.LockBucket(bucketName)()
+
+	csvPath := s.versionsPath(bucketName)
+	table, err := readTable(csvPath, versionsTable)
+	if err != nil {
+		return err
+	}
+
+	updated := false
+	for i, row := range table.rows {
+		if row["key"] == version.ObjectKey && row["version_id"] == version.VersionID {
+			table.rows[i] = objectToRow(version)
+			updated = true
+			break
+		}
+	}
+	if !updated {
+		table.rows = append(table.rows, objectToRow(version))
+	}
+
+	return writeTable(csvPath, versionsTable, objectColumns, table.rows)
+}
+
+func (s *CSVStore) DeleteVersion(bucketName, objectKey, versionID string) error {
+	defer Locks.LockBucket(bucketName)()
+
+	csvPath := s.versionsPath(bucketName)
+	table, err := readTable(csvPath, versionsTable)
+	if err != nil {
+		return err
+	}
+
+	kept := table.rows[:0]
+	for _, row := range table.rows {
+		if row["key"] != objectKey || row["version_id"] != versionID {
+			kept = append(kept, row)
+		}
+	}
+	if len(kept) == len(table.rows) {
+		return nil
+	}
+	return writeTable(csvPath, versionsTable, objectColumns, kept)
+}
+
+func (s *CSVStore) Close() error {
+	return nil
+}
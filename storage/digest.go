@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"triple-s/models"
+)
+
+// Algorithms accepted in x-amz-checksum-<algorithm> headers. Checksum
+// values are base64 encoded, as in S3.
+const (
+	ChecksumCRC32  = "crc32"
+	ChecksumCRC32C = "crc32c"
+	ChecksumSHA1   = "sha1"
+	ChecksumSHA256 = "sha256"
+)
+
+var ChecksumAlgorithms = []string{ChecksumCRC32, ChecksumCRC32C, ChecksumSHA1, ChecksumSHA256}
+
+// ErrBadDigest is returned when uploaded content does not match a digest
+// the client sent with it.
+var ErrBadDigest = errors.New("content does not match the supplied digest")
+
+func newChecksum(algorithm string) hash.Hash {
+	switch algorithm {
+	case ChecksumCRC32:
+		return crc32.NewIEEE()
+	case ChecksumCRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	case ChecksumSHA1:
+		return sha1.New()
+	case ChecksumSHA256:
+		return sha256.New()
+	}
+	return nil
+}
+
+// ChecksumSize returns the digest length in bytes of algorithm, or 0 if the
+// algorithm is not supported.
+func ChecksumSize(algorithm string) int {
+	if h := newChecksum(algorithm); h != nil {
+		return h.Size()
+	}
+	return 0
+}
+
+// digester computes the size and every checksum the server stores for an
+// object while the data streams through it.
+type digester struct {
+	size      int64
+	md5       hash.Hash
+	sha256    hash.Hash
+	checksums map[string]hash.Hash
+}
+
+// newDigester also computes the x-amz-checksum values named by checksums,
+// typically the ones the client supplied or the object already has.
+func newDigester(checksums map[string]string) *digester {
+	d := &digester{md5: md5.New(), sha256: sha256.New(), checksums: make(map[string]hash.Hash)}
+	for algorithm := range checksums {
+		if h := newChecksum(algorithm); h != nil {
+			d.checksums[algorithm] = h
+		}
+	}
+	return d
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	d.md5.Write(p)
+	d.sha256.Write(p)
+	for _, h := range d.checksums {
+		h.Write(p)
+	}
+	d.size += int64(len(p))
+	return len(p), nil
+}
+
+// verify compares the digests with the ones the client sent: a non-empty
+// object.ETag is the expected hex MD5 (from Content-MD5) and
+// object.Checksums holds the expected x-amz-checksum values.
+func (d *digester) verify(object models.ObjectCSV) error {
+	if object.ETag != "" && object.ETag != hex.EncodeToString(d.md5.Sum(nil)) {
+		return fmt.Errorf("%w: Content-MD5", ErrBadDigest)
+	}
+	for algorithm, expected := range object.Checksums {
+		h, ok := d.checksums[algorithm]
+		if !ok || expected != base64.StdEncoding.EncodeToString(h.Sum(nil)) {
+			return fmt.Errorf("%w: x-amz-checksum-%s", ErrBadDigest, algorithm)
+		}
+	}
+	return nil
+}
+
+// apply copies the size and digests onto object. The ETag is the hex MD5
+// of the content, as in S3 for single-part uploads; a multipart ETag is
+// derived from the parts and kept as is.
+func (d *digester) apply(object *models.ObjectCSV) {
+	object.ObjectSize = d.size
+	if !isMultipartETag(object.ETag) {
+		object.ETag = hex.EncodeToString(d.md5.Sum(nil))
+	}
+	object.SHA256 = hex.EncodeToString(d.sha256.Sum(nil))
+	if len(d.checksums) > 0 {
+		object.Checksums = make(map[string]string, len(d.checksums))
+		for algorithm, h := range d.checksums {
+			object.Checksums[algorithm] = base64.StdEncoding.EncodeToString(h.Sum(nil))
+		}
+	}
+}
+
+// digestFile reads the file at path through a digester.
+func digestFile(path string, checksums map[string]string) (*digester, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	d := newDigester(checksums)
+	if _, err := io.Copy(d, file); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
@@ -0,0 +1,342 @@
+package storage
+
+import (
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"triple-s/flags"
+	"triple-s/models"
+)
+
+const (
+	IssueOrphanFile      = "orphan-file"
+	IssueDanglingRow     = "dangling-row"
+	IssueWrongSize       = "wrong-size"
+	IssueUntrackedBucket = "untracked-bucket"
+	IssueMissingBucket   = "missing-bucket-dir"
+	IssueStaleStatus     = "stale-status"
+	IssueStaleTemp       = "stale-temp-file"
+	IssueReservedKey     = "reserved-key"
+	IssueInvalidBucket   = "invalid-bucket-name"
+	IssueOrphanVersion   = "orphan-version-file"
+	IssueDanglingVersion = "dangling-version-row"
+)
+
+type FsckIssue struct {
+	Kind     string `json:"kind"`
+	Bucket   string `json:"bucket"`
+	Key      string `json:"key,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Repaired bool   `json:"repaired"`
+}
+
+// FsckReport is the outcome of a Fsck run. Counts is keyed by issue kind.
+type FsckReport struct {
+	Buckets  int            `json:"buckets"`
+	Objects  int            `json:"objects"`
+	Issues   []FsckIssue    `json:"issues"`
+	Counts   map[string]int `json:"counts"`
+	Repaired int            `json:"repaired"`
+}
+
+func (r *FsckReport) add(issue FsckIssue, repair func() error) error {
+	if repair != nil {
+		if err := repair(); err != nil {
+			return fmt.Errorf("could not repair %s %s/%s: %w", issue.Kind, issue.Bucket, issue.Key, err)
+		}
+		issue.Repaired = true
+		r.Repaired++
+	}
+	r.Issues = append(r.Issues, issue)
+	r.Counts[issue.Kind]++
+	return nil
+}
+
+// Unrepaired returns how many issues are still present on disk.
+func (r *FsckReport) Unrepaired() int {
+	return len(r.Issues) - r.Repaired
+}
+
+// Fsck compares the metadata store with what is actually in StorageDir.
+// With repair set, every issue is fixed in a way that never loses object
+// data: untracked files and buckets are adopted, rows without data are
+// dropped. Fsck must not run while the server is serving requests.
+func Fsck(repair bool) (*FsckReport, error) {
+	report := &FsckReport{Counts: make(map[string]int)}
+
+	fix := func(f func() error) func() error {
+		if repair {
+			return f
+		}
+		return nil
+	}
+
+	buckets, err := Metadata.ListBuckets()
+	if err != nil {
+		return nil, err
+	}
+	known := make(map[string]models.Bucket)
+	for _, bucket := range buckets {
+		known[bucket.Name] = bucket
+	}
+
+	entries, err := os.ReadDir(flags.StorageDir)
+	if err != nil {
+		return nil, err
+	}
+	onDisk := make(map[string]bool)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() {
+			if strings.HasPrefix(name, tempPrefix(bucketsFile)) {
+				path := filepath.Join(flags.StorageDir, name)
+				err := report.add(FsckIssue{Kind: IssueStaleTemp, Key: name, Detail: "leftover temp file"}, fix(func() error {
+					return os.Remove(path)
+				}))
+				if err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		if name == SystemDir {
+			continue
+		}
+		onDisk[name] = true
+		if _, ok := known[name]; ok {
+			continue
+		}
+		if !ValidBucketName(name) {
+			// No request could address it as a bucket.
+			err := report.add(FsckIssue{Kind: IssueInvalidBucket, Bucket: name, Detail: "directory is not a valid bucket name and is not adopted"}, nil)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		bucket := models.Bucket{Name: name, CreationDate: info.ModTime(), ContentStatus: "inactive", LastModified: time.Now()}
+		err = report.add(FsckIssue{Kind: IssueUntrackedBucket, Bucket: name, Detail: "directory has no bucket metadata"}, fix(func() error {
+			return Metadata.PutBucket(bucket)
+		}))
+		if err != nil {
+			return nil, err
+		}
+		known[name] = bucket
+	}
+
+	names := make([]string, 0, len(known))
+	for name := range known {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !onDisk[name] {
+			bucketName := name
+			err := report.add(FsckIssue{Kind: IssueMissingBucket, Bucket: name, Detail: "bucket metadata has no directory"}, fix(func() error {
+				return Metadata.DeleteBucket(bucketName)
+			}))
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		report.Buckets++
+		if err := fsckBucket(known[name], report, fix); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+func fsckBucket(bucket models.Bucket, report *FsckReport, fix func(func() error) func() error) error {
+	bucketName := bucket.Name
+	objects, err := Metadata.ListObjects(bucketName)
+	if err != nil {
+		return err
+	}
+	rows := make(map[string]models.ObjectCSV)
+	seen := make(map[string]bool)
+	for _, object := range objects {
+		if CheckObjectKey(object.ObjectKey) != nil {
+			// Stored before such keys were refused. Its file may be the
+			// bucket's own metadata, so neither is touched.
+			seen[object.ObjectKey] = true
+			err := report.add(FsckIssue{Kind: IssueReservedKey, Bucket: bucketName, Key: object.ObjectKey, Detail: "key names a metadata file and can no longer be written or deleted; copy the object to another key"}, nil)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		rows[objectFileName(object.ObjectKey)] = object
+	}
+
+	files, err := os.ReadDir(BucketPath(bucketName))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || name == objectsFile || name == versionsFile {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return err
+		}
+
+		if isInternalFile(name) {
+			path := filepath.Join(BucketPath(bucketName), name)
+			err := report.add(FsckIssue{Kind: IssueStaleTemp, Bucket: bucketName, Key: name, Detail: "leftover temp file"}, fix(func() error {
+				return os.Remove(path)
+			}))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		report.Objects++
+
+		row, tracked := rows[name]
+		if !tracked {
+			key, ok := objectKeyFromFile(name)
+			if !ok {
+				// The key cannot be recovered from the name, so there is
+				// nothing safe to adopt the file as.
+				err := report.add(FsckIssue{Kind: IssueOrphanFile, Bucket: bucketName, Key: name, Detail: "file name does not map to a key"}, nil)
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			object := models.ObjectCSV{
+				ObjectKey:    key,
+				ContentType:  mime.TypeByExtension(filepath.Ext(key)),
+				CreationDate: info.ModTime(),
+				LastModified: info.ModTime(),
+			}
+			path := filepath.Join(BucketPath(bucketName), name)
+			err := report.add(FsckIssue{Kind: IssueOrphanFile, Bucket: bucketName, Key: key, Detail: "file has no metadata row"}, fix(func() error {
+				digests, err := digestFile(path, nil)
+				if err != nil {
+					return err
+				}
+				digests.apply(&object)
+				return Metadata.PutObject(bucketName, object)
+			}))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		seen[row.ObjectKey] = true
+
+		if row.ObjectSize != info.Size() {
+			detail := fmt.Sprintf("metadata says %d bytes, file has %d", row.ObjectSize, info.Size())
+			path := filepath.Join(BucketPath(bucketName), name)
+			err := report.add(FsckIssue{Kind: IssueWrongSize, Bucket: bucketName, Key: row.ObjectKey, Detail: detail}, fix(func() error {
+				digests, err := digestFile(path, row.Checksums)
+				if err != nil {
+					return err
+				}
+				digests.apply(&row)
+				return Metadata.PutObject(bucketName, row)
+			}))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, object := range objects {
+		if seen[object.ObjectKey] {
+			continue
+		}
+		key := object.ObjectKey
+		err := report.add(FsckIssue{Kind: IssueDanglingRow, Bucket: bucketName, Key: key, Detail: "metadata row has no file"}, fix(func() error {
+			return Metadata.DeleteObject(bucketName, key)
+		}))
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := fsckVersions(bucketName, report, fix); err != nil {
+		return err
+	}
+
+	expected := "inactive"
+	for _, file := range files {
+		if !file.IsDir() && !isInternalFile(file.Name()) {
+			expected = "active"
+			break
+		}
+	}
+	if bucket.ContentStatus != expected {
+		detail := fmt.Sprintf("status is %q, expected %q", bucket.ContentStatus, expected)
+		return report.add(FsckIssue{Kind: IssueStaleStatus, Bucket: bucketName, Detail: detail}, fix(func() error {
+			return Metadata.UpdateBucket(bucketName, func(b *models.Bucket) error {
+				b.ContentStatus = expected
+				return nil
+			})
+		}))
+	}
+	return nil
+}
+
+// fsckVersions compares versions.csv with the data files in .versions.
+// Files without a row cannot be mapped back to a key, so they are only
+// reported.
+func fsckVersions(bucketName string, report *FsckReport, fix func(func() error) func() error) error {
+	versions, err := Metadata.ListVersions(bucketName)
+	if err != nil {
+		return err
+	}
+	files := make(map[string]bool)
+	entries, err := os.ReadDir(filepath.Join(BucketPath(bucketName), versionsDir))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range entries {
+		files[entry.Name()] = true
+	}
+
+	for _, version := range versions {
+		if version.DeleteMarker {
+			continue
+		}
+		name := filepath.Base(versionPath(bucketName, version.ObjectKey, version.VersionID))
+		if files[name] {
+			delete(files, name)
+			continue
+		}
+		key, versionID := version.ObjectKey, version.VersionID
+		err := report.add(FsckIssue{Kind: IssueDanglingVersion, Bucket: bucketName, Key: key, Detail: fmt.Sprintf("version %s has no data file", versionID)}, fix(func() error {
+			return Metadata.DeleteVersion(bucketName, key, versionID)
+		}))
+		if err != nil {
+			return err
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		err := report.add(FsckIssue{Kind: IssueOrphanVersion, Bucket: bucketName, Key: versionsDir + "/" + name, Detail: "version file has no row in the versions table"}, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,310 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	"triple-s/models"
+)
+
+const (
+	OpPutObject    = "put-object"
+	OpDeleteObject = "delete-object"
+	// OpDeleteObjects is a batch delete; Keys lists the removed keys and
+	// Versions the noncurrent versions and delete markers it adds.
+	OpDeleteObjects = "delete-objects"
+	OpCreateBucket  = "create-bucket"
+	OpDeleteBucket  = "delete-bucket"
+
+	stateBegin = "begin"
+	// stateStaged follows begin once the data of a put is complete in its
+	// temp file, carrying the final object. The temp file is only ever
+	// removed after that by the rename or after an abort.
+	stateStaged = "staged"
+	stateCommit = "commit"
+	stateAbort  = "abort"
+
+	// journalCompactAfter is how many records may pile up before the
+	// journal is truncated the next time no mutation is in flight.
+	journalCompactAfter = 1024
+)
+
+// JournalEntry records the intent of a multi-step mutation. A begin entry
+// without a matching commit or abort means the server stopped half way.
+type JournalEntry struct {
+	ID       string             `json:"id"`
+	State    string             `json:"state"`
+	Op       string             `json:"op,omitempty"`
+	Bucket   string             `json:"bucket,omitempty"`
+	Key      string             `json:"key,omitempty"`
+	Object   *models.ObjectCSV  `json:"object,omitempty"`
+	UploadID string             `json:"uploadId,omitempty"`
+	Keys     []string           `json:"keys,omitempty"`
+	Versions []models.ObjectCSV `json:"versions,omitempty"`
+	Time     time.Time          `json:"time"`
+}
+
+// IntentJournal is an append-only, fsynced log of JournalEntry records.
+type IntentJournal struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	pending map[string]JournalEntry
+	records int
+}
+
+// Journal is opened by Init and replayed by Recover before the server
+// starts accepting requests.
+var Journal *IntentJournal
+
+func OpenJournal(path string) (*IntentJournal, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("could not create journal directory: %w", err)
+	}
+
+	j := &IntentJournal{path: path, pending: make(map[string]JournalEntry)}
+	if err := j.load(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open journal: %w", err)
+	}
+	j.file = file
+	return j, nil
+}
+
+func (j *IntentJournal) load() error {
+	file, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not open journal: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// A torn final write; the intent it carried never started.
+			break
+		}
+		j.records++
+		switch entry.State {
+		case stateBegin:
+			j.pending[entry.ID] = entry
+		case stateStaged:
+			if begun, ok := j.pending[entry.ID]; ok {
+				begun.State, begun.Object = stateStaged, entry.Object
+				j.pending[entry.ID] = begun
+			}
+		default:
+			delete(j.pending, entry.ID)
+		}
+	}
+	return scanner.Err()
+}
+
+func (j *IntentJournal) append(entry JournalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("could not append to journal: %w", err)
+	}
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("could not sync journal: %w", err)
+	}
+	j.records++
+	return nil
+}
+
+// Begin durably records entry before any of its steps run and returns it
+// with its ID filled in.
+func (j *IntentJournal) Begin(entry JournalEntry) (JournalEntry, error) {
+	id, err := newJournalID()
+	if err != nil {
+		return entry, err
+	}
+	entry.ID = id
+	entry.State = stateBegin
+	entry.Time = time.Now()
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.append(entry); err != nil {
+		return entry, err
+	}
+	j.pending[id] = entry
+	return entry, nil
+}
+
+// Stage durably records that the data of the put id is complete and will
+// be stored as object.
+func (j *IntentJournal) Stage(id string, object models.ObjectCSV) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.append(JournalEntry{ID: id, State: stateStaged, Object: &object, Time: time.Now()}); err != nil {
+		return err
+	}
+	entry := j.pending[id]
+	entry.State, entry.Object = stateStaged, &object
+	j.pending[id] = entry
+	return nil
+}
+
+func (j *IntentJournal) Commit(id string) error {
+	return j.finish(id, stateCommit)
+}
+
+func (j *IntentJournal) Abort(id string) error {
+	return j.finish(id, stateAbort)
+}
+
+func (j *IntentJournal) finish(id, state string) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.append(JournalEntry{ID: id, State: state, Time: time.Now()}); err != nil {
+		return err
+	}
+	delete(j.pending, id)
+	if len(j.pending) == 0 && j.records >= journalCompactAfter {
+		return j.truncate()
+	}
+	return nil
+}
+
+func (j *IntentJournal) truncate() error {
+	if err := j.file.Truncate(0); err != nil {
+		return fmt.Errorf("could not truncate journal: %w", err)
+	}
+	j.records = 0
+	return j.file.Sync()
+}
+
+// Pending returns the unfinished entries in the order they were begun.
+func (j *IntentJournal) Pending() []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries := make([]JournalEntry, 0, len(j.pending))
+	for _, entry := range j.pending {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Time.Before(entries[b].Time) })
+	return entries
+}
+
+func (j *IntentJournal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
+
+// Recover rolls every unfinished entry forward or back so object files and
+// metadata agree again, then empties the journal.
+func (j *IntentJournal) Recover() error {
+	for _, entry := range j.Pending() {
+		action, err := recoverEntry(entry)
+		if err != nil {
+			return fmt.Errorf("could not recover %s %s/%s: %w", entry.Op, entry.Bucket, entry.Key, err)
+		}
+		log.Printf("Journal: %s %s %s/%s", action, entry.Op, entry.Bucket, entry.Key)
+		if err := j.Commit(entry.ID); err != nil {
+			return err
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.truncate()
+}
+
+func recoverEntry(entry JournalEntry) (string, error) {
+	bucketPath := BucketPath(entry.Bucket)
+
+	switch entry.Op {
+	case OpPutObject:
+		// Only a staged put whose temp file is gone has been renamed into
+		// place. Anything else may have stopped before the temp file was
+		// created, and the object file is still the old one.
+		tempPath := filepath.Join(bucketPath, uploadTempName(entry.ID))
+		_, err := os.Stat(tempPath)
+		if entry.State != stateStaged || err == nil {
+			if err := os.Remove(tempPath); err != nil && !os.IsNotExist(err) {
+				return "", err
+			}
+			return "rolled back", nil
+		}
+		if !os.IsNotExist(err) {
+			return "", err
+		}
+		if err := Metadata.PutObject(entry.Bucket, *entry.Object); err != nil {
+			return "", err
+		}
+		// The parts of a completed multipart upload are in the object now.
+		if entry.UploadID != "" {
+			if err := os.RemoveAll(uploadDir(entry.Bucket, entry.UploadID)); err != nil {
+				return "", err
+			}
+		}
+		return "rolled forward", RefreshBucketStatus(entry.Bucket)
+
+	case OpDeleteObject:
+		if err := os.Remove(ObjectPath(entry.Bucket, entry.Key)); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if err := Metadata.DeleteObject(entry.Bucket, entry.Key); err != nil {
+			return "", err
+		}
+		if entry.Object != nil {
+			if err := Metadata.PutVersion(entry.Bucket, *entry.Object); err != nil {
+				return "", err
+			}
+		}
+		return "rolled forward", RefreshBucketStatus(entry.Bucket)
+
+	case OpDeleteObjects:
+		if err := deleteObjectBatch(entry.Bucket, entry.Keys, entry.Versions); err != nil {
+			return "", err
+		}
+		return "rolled forward", nil
+
+	case OpCreateBucket:
+		if _, ok, err := Metadata.GetBucket(entry.Bucket); err != nil || ok {
+			return "kept", err
+		}
+		if err := os.Remove(bucketPath); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		return "rolled back", nil
+
+	case OpDeleteBucket:
+		if err := os.RemoveAll(bucketPath); err != nil {
+			return "", err
+		}
+		return "rolled forward", Metadata.DeleteBucket(entry.Bucket)
+
+	default:
+		return "ignored", nil
+	}
+}
+
+func newJournalID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate journal id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
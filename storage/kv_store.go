@@ -0,0 +1,391 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"triple-s/models"
+)
+
+// kvRecord is one line of the append-only log backing kvDB.
+type kvRecord struct {
+	Op    string          `json:"op"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// kvDB is a small embedded key-value database. Every mutation is appended
+// to a log file and fsynced; the log is replayed into memory on open and
+// compacted so it does not grow without bound across restarts.
+type kvDB struct {
+	mu   sync.RWMutex
+	path string
+	file *os.File
+	data map[string][]byte
+}
+
+func openKV(path string) (*kvDB, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("could not create database directory: %w", err)
+	}
+
+	db := &kvDB{path: path, data: make(map[string][]byte)}
+	if err := db.replay(); err != nil {
+		return nil, err
+	}
+	if err := db.compact(); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open database: %w", err)
+	}
+	db.file = file
+	return db, nil
+}
+
+func (db *kvDB) replay() error {
+	file, err := os.Open(db.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not open database: %w", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var record kvRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// A torn final write from a crash; everything before it is intact.
+			break
+		}
+		db.set(record)
+	}
+	return scanner.Err()
+}
+
+// set applies record to the in-memory data.
+func (db *kvDB) set(record kvRecord) {
+	switch record.Op {
+	case "put":
+		db.data[record.Key] = record.Value
+	case "del":
+		delete(db.data, record.Key)
+	}
+}
+
+func (db *kvDB) compact() error {
+	return WriteFileAtomic(db.path, func(w io.Writer) error {
+		writer := bufio.NewWriter(w)
+		encoder := json.NewEncoder(writer)
+		for _, key := range db.sortedKeys("") {
+			if err := encoder.Encode(kvRecord{Op: "put", Key: key, Value: db.data[key]}); err != nil {
+				return fmt.Errorf("could not write database: %w", err)
+			}
+		}
+		return writer.Flush()
+	})
+}
+
+// append writes records to the log with a single write and fsync. db.mu
+// must be held.
+func (db *kvDB) append(records ...kvRecord) error {
+	var lines []byte
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+	if _, err := db.file.Write(lines); err != nil {
+		return fmt.Errorf("could not append to database: %w", err)
+	}
+	return db.file.Sync()
+}
+
+func (db *kvDB) Get(key string) ([]byte, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	value, ok := db.data[key]
+	return value, ok
+}
+
+func (db *kvDB) Put(key string, value []byte) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.append(kvRecord{Op: "put", Key: key, Value: value}); err != nil {
+		return err
+	}
+	db.data[key] = value
+	return nil
+}
+
+func (db *kvDB) Delete(key string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, ok := db.data[key]; !ok {
+		return nil
+	}
+	if err := db.append(kvRecord{Op: "del", Key: key}); err != nil {
+		return err
+	}
+	delete(db.data, key)
+	return nil
+}
+
+// Apply makes every put and delete of records as one append to the log.
+func (db *kvDB) Apply(records []kvRecord) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if len(records) == 0 {
+		return nil
+	}
+	if err := db.append(records...); err != nil {
+		return err
+	}
+	for _, record := range records {
+		db.set(record)
+	}
+	return nil
+}
+
+// Scan returns the values of all keys starting with prefix, in key order.
+func (db *kvDB) Scan(prefix string) [][]byte {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	keys := db.sortedKeys(prefix)
+	values := make([][]byte, 0, len(keys))
+	for _, key := range keys {
+		values = append(values, db.data[key])
+	}
+	return values
+}
+
+func (db *kvDB) Keys(prefix string) []string {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.sortedKeys(prefix)
+}
+
+func (db *kvDB) sortedKeys(prefix string) []string {
+	var keys []string
+	for key := range db.data {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (db *kvDB) Close() error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if db.file == nil {
+		return nil
+	}
+	err := db.file.Close()
+	db.file = nil
+	return err
+}
+
+// KVStore keeps all metadata in a single embedded key-value database.
+type KVStore struct {
+	db *kvDB
+}
+
+func OpenKVStore(path string) (*KVStore, error) {
+	db, err := openKV(path)
+	if err != nil {
+		return nil, err
+	}
+	return &KVStore{db: db}, nil
+}
+
+func bucketKey(bucketName string) string {
+	return "buckets/" + bucketName
+}
+
+func objectPrefix(bucketName string) string {
+	return "objects/" + bucketName + "/"
+}
+
+func objectKey(bucketName, key string) string {
+	return objectPrefix(bucketName) + key
+}
+
+func versionPrefix(bucketName string) string {
+	return "versions/" + bucketName + "/"
+}
+
+// versionKey separates key and version ID with a NUL byte, which cannot
+// occur in an object key.
+func versionKey(bucketName, key, versionID string) string {
+	return versionPrefix(bucketName) + key + "\x00" + versionID
+}
+
+func (s *KVStore) ListBuckets() ([]models.Bucket, error) {
+	var buckets []models.Bucket
+	for _, value := range s.db.Scan("buckets/") {
+		var bucket models.Bucket
+		if err := json.Unmarshal(value, &bucket); err != nil {
+			return nil, fmt.Errorf("could not decode bucket: %w", err)
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+func (s *KVStore) GetBucket(bucketName string) (models.Bucket, bool, error) {
+	var bucket models.Bucket
+	value, ok := s.db.Get(bucketKey(bucketName))
+	if !ok {
+		return bucket, false, nil
+	}
+	if err := json.Unmarshal(value, &bucket); err != nil {
+		return bucket, false, fmt.Errorf("could not decode bucket: %w", err)
+	}
+	return bucket, true, nil
+}
+
+func (s *KVStore) PutBucket(bucket models.Bucket) error {
+	defer Locks.LockBuckets()()
+	return s.putBucket(bucket)
+}
+
+func (s *KVStore) putBucket(bucket models.Bucket) error {
+	value, err := json.Marshal(bucket)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(bucketKey(bucket.Name), value)
+}
+
+func (s *KVStore) UpdateBucket(bucketName string, update func(*models.Bucket) error) error {
+	defer Locks.LockBuckets()()
+
+	bucket, ok, err := s.GetBucket(bucketName)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrBucketNotFound
+	}
+	if err := update(&bucket); err != nil {
+		return err
+	}
+	return s.putBucket(bucket)
+}
+
+func (s *KVStore) DeleteBucket(bucketName string) error {
+	defer Locks.LockBuckets()()
+	defer Locks.LockBucket(bucketName)()
+
+	keys := append(s.db.Keys(objectPrefix(bucketName)), s.db.Keys(versionPrefix(bucketName))...)
+	for _, key := range keys {
+		if err := s.db.Delete(key); err != nil {
+			return err
+		}
+	}
+	return s.db.Delete(bucketKey(bucketName))
+}
+
+func (s *KVStore) ListObjects(bucketName string) ([]models.ObjectCSV, error) {
+	var objects []models.ObjectCSV
+	for _, value := range s.db.Scan(objectPrefix(bucketName)) {
+		var object models.ObjectCSV
+		if err := json.Unmarshal(value, &object); err != nil {
+			return nil, fmt.Errorf("could not decode object: %w", err)
+		}
+		objects = append(objects, object)
+	}
+	return objects, nil
+}
+
+func (s *KVStore) GetObject(bucketName, key string) (models.ObjectCSV, bool, error) {
+	var object models.ObjectCSV
+	value, ok := s.db.Get(objectKey(bucketName, key))
+	if !ok {
+		return object, false, nil
+	}
+	if err := json.Unmarshal(value, &object); err != nil {
+		return object, false, fmt.Errorf("could not decode object: %w", err)
+	}
+	return object, true, nil
+}
+
+func (s *KVStore) PutObject(bucketName string, object models.ObjectCSV) error {
+	defer Locks.LockBucket(bucketName)()
+
+	value, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(objectKey(bucketName, object.ObjectKey), value)
+}
+
+func (s *KVStore) DeleteObject(bucketName, key string) error {
+	defer Locks.LockBucket(bucketName)()
+
+	return s.db.Delete(objectKey(bucketName, key))
+}
+
+func (s *KVStore) DeleteObjects(bucketName string, keys []string, versions []models.ObjectCSV) error {
+	defer Locks.LockBucket(bucketName)()
+
+	records := make([]kvRecord, 0, len(keys)+len(versions))
+	for _, key := range keys {
+		records = append(records, kvRecord{Op: "del", Key: objectKey(bucketName, key)})
+	}
+	for _, version := range versions {
+		value, err := json.Marshal(version)
+		if err != nil {
+			return err
+		}
+		records = append(records, kvRecord{Op: "put", Key: versionKey(bucketName, version.ObjectKey, version.VersionID), Value: value})
+	}
+	return s.db.Apply(records)
+}
+
+func (s *KVStore) ListVersions(bucketName string) ([]models.ObjectCSV, error) {
+	var versions []models.ObjectCSV
+	for _, value := range s.db.Scan(versionPrefix(bucketName)) {
+		var version models.ObjectCSV
+		if err := json.Unmarshal(value, &version); err != nil {
+			return nil, fmt.Errorf("could not decode version: %w", err)
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+func (s *KVStore) PutVersion(bucketName string, version models.ObjectCSV) error {
+	defer Locks.LockBucket(bucketName)()
+
+	value, err := json.Marshal(version)
+	if err != nil {
+		return err
+	}
+	return s.db.Put(versionKey(bucketName, version.ObjectKey, version.VersionID), value)
+}
+
+func (s *KVStore) DeleteVersion(bucketName, key, versionID string) error {
+	defer Locks.LockBucket(bucketName)()
+
+	return s.db.Delete(versionKey(bucketName, key, versionID))
+}
+
+func (s *KVStore) Close() error {
+	return s.db.Close()
+}
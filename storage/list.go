@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"sort"
+	"strings"
+	"triple-s/models"
+)
+
+// ListOptions selects one page of a bucket listing. Keys at or before
+// StartAfter are skipped, as is every entry at or before Marker, where an
+// entry is either a key or the common prefix that rolls it up.
+type ListOptions struct {
+	Prefix     string
+	Delimiter  string
+	StartAfter string
+	Marker     string
+	MaxKeys    int
+	// Tags, when set, limits the listing to objects carrying all of them.
+	Tags map[string]string
+}
+
+type ListPage struct {
+	Objects        []models.ObjectCSV
+	CommonPrefixes []string
+	IsTruncated    bool
+	// NextMarker is the last entry on the page, or Marker when a truncated
+	// page is empty; pass it back as Marker to continue. It is empty when
+	// there is nothing to continue from.
+	NextMarker string
+}
+
+// ListObjectsPage lists a bucket in lexicographic key order, rolling keys
+// that contain Delimiter after Prefix up into common prefixes.
+func ListObjectsPage(bucketName string, opts ListOptions) (ListPage, error) {
+	objects, err := Metadata.ListObjects(bucketName)
+	if err != nil {
+		return ListPage{}, err
+	}
+	sort.Slice(objects, func(a, b int) bool { return objects[a].ObjectKey < objects[b].ObjectKey })
+
+	page := ListPage{NextMarker: opts.Marker}
+	entries := 0
+	lastPrefix := ""
+	for _, object := range objects {
+		key := object.ObjectKey
+		if !strings.HasPrefix(key, opts.Prefix) || (opts.StartAfter != "" && key <= opts.StartAfter) {
+			continue
+		}
+		if !MatchesTags(object.Tags, opts.Tags) {
+			continue
+		}
+
+		entry, isPrefix := key, false
+		if opts.Delimiter != "" {
+			rest := key[len(opts.Prefix):]
+			if i := strings.Index(rest, opts.Delimiter); i >= 0 {
+				entry, isPrefix = opts.Prefix+rest[:i+len(opts.Delimiter)], true
+			}
+		}
+		// Keys sharing a common prefix are contiguous in sorted order, so
+		// entries never decrease and one comparison skips whole prefixes.
+		if opts.Marker != "" && entry <= opts.Marker {
+			continue
+		}
+		if isPrefix && entry == lastPrefix {
+			continue
+		}
+
+		if entries == opts.MaxKeys {
+			page.IsTruncated = true
+			break
+		}
+		entries++
+		page.NextMarker = entry
+		if isPrefix {
+			lastPrefix = entry
+			page.CommonPrefixes = append(page.CommonPrefixes, entry)
+		} else {
+			page.Objects = append(page.Objects, object)
+		}
+	}
+	if !page.IsTruncated {
+		page.NextMarker = ""
+	}
+	return page, nil
+}
@@ -0,0 +1,108 @@
+package storage
+
+import "sync"
+
+// LockManager serialises metadata rewrites. buckets.csv is guarded by a
+// single global lock and each bucket's objects.csv by a lock of its own, so
+// uploads to different buckets never wait on each other. Object keys get
+// their own locks for check-and-replace sequences such as conditional PUTs.
+// Writes that add data to a bucket share its removal lock, which deleting
+// the bucket takes exclusively.
+type LockManager struct {
+	buckets sync.Mutex
+
+	mu       sync.Mutex
+	objects  map[string]*namedLock
+	keys     map[string]*namedLock
+	uploads  map[string]*namedLock
+	removals map[string]*namedLock
+}
+
+type namedLock struct {
+	sync.RWMutex
+	refs int
+}
+
+// Locks is shared by every metadata store in the process.
+var Locks = NewLockManager()
+
+func NewLockManager() *LockManager {
+	return &LockManager{
+		objects:  make(map[string]*namedLock),
+		keys:     make(map[string]*namedLock),
+		uploads:  make(map[string]*namedLock),
+		removals: make(map[string]*namedLock),
+	}
+}
+
+// LockBuckets takes the global buckets.csv lock and returns its release func.
+func (m *LockManager) LockBuckets() func() {
+	m.buckets.Lock()
+	return m.buckets.Unlock
+}
+
+// LockBucket takes the objects.csv lock of bucketName and returns its
+// release func.
+func (m *LockManager) LockBucket(bucketName string) func() {
+	return m.lock(m.objects, bucketName)
+}
+
+// LockObject takes the lock of one object key and returns its release func.
+// It must be taken before, never while holding, the bucket's lock.
+func (m *LockManager) LockObject(bucketName, objectKey string) func() {
+	return m.lock(m.keys, bucketName+"/"+objectKey)
+}
+
+// LockUpload takes the lock of one multipart upload and returns its release
+// func. It serialises part replacement against completion and abort.
+func (m *LockManager) LockUpload(uploadID string) func() {
+	return m.lock(m.uploads, uploadID)
+}
+
+// LockBucketWrite keeps bucketName from being removed while a write adds
+// data to it, and returns its release func. Any number of writes may hold
+// it at once. It is taken after the object's lock and before the metadata
+// locks.
+func (m *LockManager) LockBucketWrite(bucketName string) func() {
+	return m.acquire(m.removals, bucketName, true)
+}
+
+// LockBucketRemoval waits for the writes holding LockBucketWrite and keeps
+// new ones out until it is released.
+func (m *LockManager) LockBucketRemoval(bucketName string) func() {
+	return m.lock(m.removals, bucketName)
+}
+
+// lock takes the named lock from table. Locks are dropped from the table
+// once nobody holds them.
+func (m *LockManager) lock(table map[string]*namedLock, name string) func() {
+	return m.acquire(table, name, false)
+}
+
+func (m *LockManager) acquire(table map[string]*namedLock, name string, shared bool) func() {
+	m.mu.Lock()
+	lock, ok := table[name]
+	if !ok {
+		lock = &namedLock{}
+		table[name] = lock
+	}
+	lock.refs++
+	m.mu.Unlock()
+
+	unlock := lock.Unlock
+	if shared {
+		lock.RLock()
+		unlock = lock.RUnlock
+	} else {
+		lock.Lock()
+	}
+	return func() {
+		unlock()
+		m.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(table, name)
+		}
+		m.mu.Unlock()
+	}
+}
@@ -0,0 +1,37 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLockBucketRemoval(t *testing.T) {
+	locks := NewLockManager()
+	unlockFirst := locks.LockBucketWrite("bucket")
+	unlockSecond := locks.LockBucketWrite("bucket")
+
+	removed := make(chan func())
+	go func() { removed <- locks.LockBucketRemoval("bucket") }()
+	select {
+	case <-removed:
+		t.Fatal("removal did not wait for the writes")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlockFirst()
+	unlockSecond()
+	unlockRemoval := <-removed
+
+	written := make(chan func())
+	go func() { written <- locks.LockBucketWrite("bucket") }()
+	select {
+	case <-written:
+		t.Fatal("a write got in during the removal")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlockRemoval()
+	(<-written)()
+
+	if len(locks.removals) != 0 {
+		t.Errorf("%d removal locks left in the table", len(locks.removals))
+	}
+}
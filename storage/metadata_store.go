@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"triple-s/flags"
+	"triple-s/models"
+)
+
+const (
+	BackendCSV = "csv"
+	BackendKV  = "kv"
+)
+
+// MetadataStore is the single entry point for bucket and object metadata.
+// Handlers must not touch the underlying files directly.
+type MetadataStore interface {
+	ListBuckets() ([]models.Bucket, error)
+	GetBucket(bucketName string) (models.Bucket, bool, error)
+	PutBucket(bucket models.Bucket) error
+	// UpdateBucket applies update to the stored bucket as one atomic
+	// read-modify-write. It returns ErrBucketNotFound for unknown buckets.
+	UpdateBucket(bucketName string, update func(*models.Bucket) error) error
+	DeleteBucket(bucketName string) error
+
+	ListObjects(bucketName string) ([]models.ObjectCSV, error)
+	GetObject(bucketName, objectKey string) (models.ObjectCSV, bool, error)
+	PutObject(bucketName string, object models.ObjectCSV) error
+	DeleteObject(bucketName, objectKey string) error
+	// DeleteObjects removes the entries of keys and adds or replaces
+	// versions with one write per table. Keys without an entry are skipped.
+	DeleteObjects(bucketName string, keys []string, versions []models.ObjectCSV) error
+
+	// ListVersions returns the noncurrent versions and delete markers of a
+	// versioned bucket. The current version of each key is the one
+	// returned by GetObject.
+	ListVersions(bucketName string) ([]models.ObjectCSV, error)
+	// PutVersion adds version, or replaces the entry with the same key
+	// and VersionID.
+	PutVersion(bucketName string, version models.ObjectCSV) error
+	DeleteVersion(bucketName, objectKey, versionID string) error
+
+	Close() error
+}
+
+var (
+	ErrBucketNotFound = errors.New("bucket not found in metadata")
+	ErrBucketNotEmpty = errors.New("bucket is not empty")
+)
+
+// Metadata is the store selected by the -metadata flag.
+var Metadata MetadataStore
+
+func Init() error {
+	store, err := Open(flags.MetadataBackend, flags.StorageDir)
+	if err != nil {
+		return err
+	}
+	Metadata = store
+
+	journal, err := OpenJournal(filepath.Join(flags.StorageDir, SystemDir, "journal.log"))
+	if err != nil {
+		return err
+	}
+	Journal = journal
+	return nil
+}
+
+func Open(backend, storageDir string) (MetadataStore, error) {
+	switch backend {
+	case BackendCSV:
+		return OpenCSVStore(storageDir)
+	case BackendKV:
+		return OpenKVStore(filepath.Join(storageDir, SystemDir, "metadata.db"))
+	default:
+		return nil, fmt.Errorf("unknown metadata backend %q", backend)
+	}
+}
+
+// Migrate copies every bucket, object and version record from src into dst.
+// Versions are not included in the object count.
+func Migrate(src, dst MetadataStore) (int, int, error) {
+	buckets, err := src.ListBuckets()
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not list buckets: %w", err)
+	}
+
+	objectCount := 0
+	for _, bucket := range buckets {
+		if err := dst.PutBucket(bucket); err != nil {
+			return 0, 0, fmt.Errorf("could not write bucket %s: %w", bucket.Name, err)
+		}
+		objects, err := src.ListObjects(bucket.Name)
+		if err != nil {
+			return 0, 0, fmt.Errorf("could not list objects of %s: %w", bucket.Name, err)
+		}
+		for _, object := range objects {
+			if err := dst.PutObject(bucket.Name, object); err != nil {
+				return 0, 0, fmt.Errorf("could not write object %s/%s: %w", bucket.Name, object.ObjectKey, err)
+			}
+			objectCount++
+		}
+		versions, err := src.ListVersions(bucket.Name)
+		if err != nil {
+			return 0, 0, fmt.Errorf("could not list versions of %s: %w", bucket.Name, err)
+		}
+		for _, version := range versions {
+			if err := dst.PutVersion(bucket.Name, version); err != nil {
+				return 0, 0, fmt.Errorf("could not write version %s/%s: %w", bucket.Name, version.ObjectKey, err)
+			}
+		}
+	}
+	return len(buckets), objectCount, nil
+}
@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"triple-s/flags"
+	"triple-s/models"
+)
+
+func testBucket() models.Bucket {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return models.Bucket{
+		Name: "bucket", CreationDate: created, LastModified: created, ContentStatus: "active",
+		Tags: map[string]string{"team": "data"},
+	}
+}
+
+func testObject(key string) models.ObjectCSV {
+	modified := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	return models.ObjectCSV{
+		ObjectKey: key, ObjectSize: 4, ContentType: "text/plain, charset=utf-8",
+		CreationDate: modified.Add(-time.Hour), LastModified: modified, ETag: "8d777f385d3dfec8815d20f7496026dc",
+		SHA256: "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7", CacheControl: "no-cache",
+		ContentDisposition: `attachment; filename="a,b"`, Checksums: map[string]string{"CRC32": "SQ9wxg=="},
+		UserMetadata: map[string]string{"color": "red"}, Tags: map[string]string{"a b": "c\nd"},
+	}
+}
+
+// TestStoreRoundTrip runs the same operations against every backend and
+// checks what a reopened store reads back.
+func TestStoreRoundTrip(t *testing.T) {
+	for _, backend := range []string{BackendCSV, BackendKV} {
+		t.Run(backend, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, path := range []string{filepath.Join(dir, SystemDir), filepath.Join(dir, "bucket")} {
+				if err := os.MkdirAll(path, 0o755); err != nil {
+					t.Fatal(err)
+				}
+			}
+			store, err := Open(backend, dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			bucket := testBucket()
+			if err := store.PutBucket(bucket); err != nil {
+				t.Fatal(err)
+			}
+
+			objects := []models.ObjectCSV{testObject("a"), testObject("b/c"), testObject("gone")}
+			for _, object := range objects {
+				if err := store.PutObject("bucket", object); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := store.DeleteObject("bucket", "gone"); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := store.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if store, err = Open(backend, dir); err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			checkStore(t, store, bucket, objects[:2])
+		})
+	}
+}
+
+// checkStore compares the contents of store with the bucket and objects it
+// should hold.
+func checkStore(t *testing.T, store MetadataStore, bucket models.Bucket, objects []models.ObjectCSV) {
+	t.Helper()
+	if got, ok, err := store.GetBucket(bucket.Name); err != nil || !ok || !reflect.DeepEqual(got, bucket) {
+		t.Errorf("got bucket %+v (%v), want %+v", got, err, bucket)
+	}
+	if buckets, err := store.ListBuckets(); err != nil || len(buckets) != 1 {
+		t.Errorf("got buckets %v (%v)", buckets, err)
+	}
+
+	gotObjects, err := store.ListObjects(bucket.Name)
+	if err != nil || !reflect.DeepEqual(gotObjects, objects) {
+		t.Errorf("got objects\n%+v\n(%v), want\n%+v", gotObjects, err, objects)
+	}
+	for _, object := range objects {
+		if got, ok, err := store.GetObject(bucket.Name, object.ObjectKey); err != nil || !ok || !reflect.DeepEqual(got, object) {
+			t.Errorf("got object %+v (%v), want %+v", got, err, object)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range []string{filepath.Join(dir, SystemDir), filepath.Join(dir, "bucket")} {
+		if err := os.MkdirAll(path, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	src, err := Open(BackendCSV, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	bucket := testBucket()
+	objects := []models.ObjectCSV{testObject("a"), testObject("b")}
+	if err := src.PutBucket(bucket); err != nil {
+		t.Fatal(err)
+	}
+	for _, object := range objects {
+		if err := src.PutObject("bucket", object); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst, err := Open(BackendKV, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	bucketCount, objectCount, err := Migrate(src, dst)
+	if err != nil || bucketCount != 1 || objectCount != 2 {
+		t.Fatalf("got %d buckets and %d objects (%v)", bucketCount, objectCount, err)
+	}
+	checkStore(t, dst, bucket, objects)
+}
+
+// TestUpgradeV1 opens a storage directory written by the first release,
+// whose CSV files have no schema marker, no header and fewer columns.
+func TestUpgradeV1(t *testing.T) {
+	previous := flags.StorageDir
+	flags.StorageDir = t.TempDir()
+	t.Cleanup(func() { flags.StorageDir = previous })
+
+	files := map[string]string{
+		bucketsFile: "photos,2024-05-01T10:00:00Z,active,2024-05-02T11:00:00Z\n" +
+			"empty,2024-05-03T10:00:00Z,inactive,2024-05-03T10:00:00Z\n",
+		// cat.jpg was overwritten with a larger file after its row was
+		// written; notes.txt has lost its file.
+		filepath.Join("photos", "objects.csv"): "cat.jpg,3,image/jpeg,2024-05-02T11:00:00Z\n" +
+			"notes.txt,42,text/plain,2024-05-02T10:00:00Z\n",
+		filepath.Join("photos", "cat.jpg"):    "meow meow",
+		filepath.Join("empty", "objects.csv"): "",
+	}
+	for name, content := range files {
+		path := filepath.Join(flags.StorageDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store, err := OpenCSVStore(flags.StorageDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buckets, err := store.ListBuckets()
+	if err != nil || len(buckets) != 2 {
+		t.Fatalf("got buckets %+v (%v)", buckets, err)
+	}
+	photos, _, _ := store.GetBucket("photos")
+	if photos.ContentStatus != "active" || !photos.CreationDate.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) ||
+		!photos.LastModified.Equal(time.Date(2024, 5, 2, 11, 0, 0, 0, time.UTC)) {
+		t.Errorf("got bucket %+v", photos)
+	}
+
+	objects, err := store.ListObjects("photos")
+	if err != nil || len(objects) != 2 {
+		t.Fatalf("got objects %+v (%v)", objects, err)
+	}
+	modified := time.Date(2024, 5, 2, 11, 0, 0, 0, time.UTC)
+	if cat := objects[0]; cat.ObjectKey != "cat.jpg" || cat.ObjectSize != 9 || cat.ContentType != "image/jpeg" ||
+		!cat.LastModified.Equal(modified) || !cat.CreationDate.Equal(modified) || cat.ETag != "" {
+		t.Errorf("got %+v, want the size of the file on disk and its modification time as creation time", cat)
+	}
+	if notes := objects[1]; notes.ObjectKey != "notes.txt" || notes.ObjectSize != 42 {
+		t.Errorf("got %+v, want the listed size for a missing file", notes)
+	}
+
+	for name, table := range map[string]string{bucketsFile: bucketsTable, filepath.Join("photos", "objects.csv"): objectsTable} {
+		data, err := os.ReadFile(filepath.Join(flags.StorageDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		marker := fmt.Sprintf("%s%s/%d\n", schemaMarker, table, schemaVersion)
+		if !strings.HasPrefix(string(data), marker) {
+			t.Errorf("%s starts with %q, want %q", name, strings.SplitN(string(data), "\n", 2)[0], marker)
+		}
+	}
+
+	// Opening the upgraded directory again changes nothing.
+	before, _ := os.ReadFile(filepath.Join(flags.StorageDir, "photos", "objects.csv"))
+	if _, err := OpenCSVStore(flags.StorageDir); err != nil {
+		t.Fatal(err)
+	}
+	if after, _ := os.ReadFile(filepath.Join(flags.StorageDir, "photos", "objects.csv")); string(after) != string(before) {
+		t.Errorf("a second open rewrote objects.csv:\n%s\nwas\n%s", after, before)
+	}
+}
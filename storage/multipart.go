@@ -0,0 +1,370 @@
+package storage
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"triple-s/models"
+)
+
+// Multipart uploads are staged in <bucket>/.multipart/<upload id>/. The
+// directory holds upload.json, describing the object to create, and one
+// file per part named <part number>.<hex md5>, so a part's ETag is known
+// without reading it. The leading dot keeps the staging area out of object
+// listings and emptiness checks.
+const (
+	multipartDir       = ".multipart"
+	multipartInfoFile  = "upload.json"
+	multipartPartTemp  = ".part-"
+	MinPartSize        = 5 << 20
+	MaxPartNumber      = 10000
+	uploadIDRandomSize = 16
+)
+
+var (
+	ErrNoSuchUpload     = errors.New("multipart upload does not exist")
+	ErrInvalidPart      = errors.New("part has not been uploaded or its ETag does not match")
+	ErrInvalidPartOrder = errors.New("parts are not listed in ascending order")
+	ErrEntityTooSmall   = errors.New("part is smaller than the minimum part size")
+)
+
+// MultipartUpload is the content of upload.json. Object carries everything
+// given at initiation: key, content type, headers and user metadata.
+type MultipartUpload struct {
+	ID        string           `json:"id"`
+	Object    models.ObjectCSV `json:"object"`
+	Initiated time.Time        `json:"initiated"`
+}
+
+type Part struct {
+	Number       int
+	Size         int64
+	ETag         string
+	Checksums    map[string]string
+	LastModified time.Time
+	path         string
+}
+
+type CompletedPart struct {
+	Number int
+	ETag   string
+}
+
+func uploadDir(bucketName, uploadID string) string {
+	return filepath.Join(BucketPath(bucketName), multipartDir, uploadID)
+}
+
+func validUploadID(uploadID string) bool {
+	if len(uploadID) != 2*uploadIDRandomSize {
+		return false
+	}
+	_, err := hex.DecodeString(uploadID)
+	return err == nil
+}
+
+// CreateMultipartUpload stages a new upload for object and returns it.
+func CreateMultipartUpload(bucketName string, object models.ObjectCSV) (MultipartUpload, error) {
+	if err := CheckObjectKey(object.ObjectKey); err != nil {
+		return MultipartUpload{}, err
+	}
+	b := make([]byte, uploadIDRandomSize)
+	if _, err := rand.Read(b); err != nil {
+		return MultipartUpload{}, fmt.Errorf("could not generate upload id: %w", err)
+	}
+	upload := MultipartUpload{ID: hex.EncodeToString(b), Object: object, Initiated: time.Now()}
+
+	defer Locks.LockBucketWrite(bucketName)()
+	if err := checkBucketExists(bucketName); err != nil {
+		return upload, err
+	}
+	dir := uploadDir(bucketName, upload.ID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return upload, fmt.Errorf("could not create upload directory: %w", err)
+	}
+	err := WriteFileAtomic(filepath.Join(dir, multipartInfoFile), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(upload)
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		return upload, err
+	}
+	return upload, nil
+}
+
+// GetMultipartUpload returns ErrNoSuchUpload unless uploadID is an upload
+// of objectKey in bucketName.
+func GetMultipartUpload(bucketName, objectKey, uploadID string) (MultipartUpload, error) {
+	var upload MultipartUpload
+	if !validUploadID(uploadID) {
+		return upload, ErrNoSuchUpload
+	}
+	data, err := os.ReadFile(filepath.Join(uploadDir(bucketName, uploadID), multipartInfoFile))
+	if os.IsNotExist(err) {
+		return upload, ErrNoSuchUpload
+	}
+	if err != nil {
+		return upload, fmt.Errorf("could not read upload: %w", err)
+	}
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return upload, fmt.Errorf("could not read upload: %w", err)
+	}
+	if upload.Object.ObjectKey != objectKey {
+		return upload, ErrNoSuchUpload
+	}
+	return upload, nil
+}
+
+// UploadPart streams body into part number of an upload, replacing any
+// earlier upload of the same part. A non-empty expected.ETag is the hex MD5
+// the client expects and expected.Checksums its x-amz-checksum values, as
+// in StoreObject; a mismatch returns ErrBadDigest. The returned part
+// carries the checksums that were verified.
+func UploadPart(bucketName, objectKey, uploadID string, number int, body io.Reader, expected models.ObjectCSV) (Part, error) {
+	if _, err := GetMultipartUpload(bucketName, objectKey, uploadID); err != nil {
+		return Part{}, err
+	}
+	dir := uploadDir(bucketName, uploadID)
+
+	tempPath := filepath.Join(dir, multipartPartTemp+strconv.Itoa(number)+"-"+strconv.FormatInt(time.Now().UnixNano(), 36))
+	digests, err := streamToFile(tempPath, body, expected.Checksums)
+	if err == nil {
+		err = digests.verify(expected)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return Part{}, err
+	}
+	part := Part{Number: number, Size: digests.size, ETag: hex.EncodeToString(digests.md5.Sum(nil)), Checksums: expected.Checksums, LastModified: time.Now()}
+	part.path = filepath.Join(dir, fmt.Sprintf("%05d.%s", number, part.ETag))
+
+	defer Locks.LockUpload(uploadID)()
+
+	previous, err := listParts(dir)
+	if err != nil {
+		os.Remove(tempPath)
+		return Part{}, err
+	}
+	if err := os.Rename(tempPath, part.path); err != nil {
+		os.Remove(tempPath)
+		return Part{}, fmt.Errorf("could not move part into place: %w", err)
+	}
+	if old, ok := previous[number]; ok && old.path != part.path {
+		os.Remove(old.path)
+	}
+	return part, syncDir(dir)
+}
+
+// ListParts returns the parts uploaded so far, ordered by part number.
+func ListParts(bucketName, objectKey, uploadID string) ([]Part, error) {
+	if _, err := GetMultipartUpload(bucketName, objectKey, uploadID); err != nil {
+		return nil, err
+	}
+	parts, err := listParts(uploadDir(bucketName, uploadID))
+	if err != nil {
+		return nil, err
+	}
+	sorted := make([]Part, 0, len(parts))
+	for _, part := range parts {
+		sorted = append(sorted, part)
+	}
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].Number < sorted[b].Number })
+	return sorted, nil
+}
+
+func listParts(dir string) (map[int]Part, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, ErrNoSuchUpload
+	}
+	if err != nil {
+		return nil, err
+	}
+	parts := make(map[int]Part)
+	for _, entry := range entries {
+		number, etag, ok := strings.Cut(entry.Name(), ".")
+		n, err := strconv.Atoi(number)
+		if !ok || err != nil || len(etag) != 2*md5.Size {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		// A crash between the rename and the removal of the replaced part
+		// can leave two files; the newer one is the part.
+		if existing, ok := parts[n]; ok && existing.LastModified.After(info.ModTime()) {
+			continue
+		}
+		parts[n] = Part{Number: n, Size: info.Size(), ETag: etag, LastModified: info.ModTime(), path: filepath.Join(dir, entry.Name())}
+	}
+	return parts, nil
+}
+
+// CompleteMultipartUpload concatenates the listed parts into the object and
+// removes the staging directory. Every part but the last must be at least
+// MinPartSize. The object's ETag is the MD5 of the parts' binary MD5s
+// followed by "-" and the part count, as in S3.
+func CompleteMultipartUpload(bucketName, objectKey, uploadID string, completed []CompletedPart, check Precondition) (models.ObjectCSV, error) {
+	upload, err := GetMultipartUpload(bucketName, objectKey, uploadID)
+	if err != nil {
+		return models.ObjectCSV{}, err
+	}
+	defer Locks.LockUpload(uploadID)()
+
+	dir := uploadDir(bucketName, uploadID)
+	parts, err := listParts(dir)
+	if err != nil {
+		return models.ObjectCSV{}, err
+	}
+	if len(completed) == 0 {
+		return models.ObjectCSV{}, ErrInvalidPart
+	}
+
+	etags := md5.New()
+	paths := make([]string, 0, len(completed))
+	for i, c := range completed {
+		if i > 0 && c.Number <= completed[i-1].Number {
+			return models.ObjectCSV{}, ErrInvalidPartOrder
+		}
+		part, ok := parts[c.Number]
+		if !ok || part.ETag != c.ETag {
+			return models.ObjectCSV{}, fmt.Errorf("%w: part %d", ErrInvalidPart, c.Number)
+		}
+		if part.Size < MinPartSize && i < len(completed)-1 {
+			return models.ObjectCSV{}, fmt.Errorf("%w: part %d", ErrEntityTooSmall, c.Number)
+		}
+		sum, _ := hex.DecodeString(part.ETag)
+		etags.Write(sum)
+		paths = append(paths, part.path)
+	}
+
+	object := upload.Object
+	object.ETag = fmt.Sprintf("%s-%d", hex.EncodeToString(etags.Sum(nil)), len(completed))
+	object.CreationDate = time.Now()
+	object.LastModified = object.CreationDate
+
+	return writeObject(bucketName, object, uploadID, check, func(tempPath string, object *models.ObjectCSV) error {
+		parts := &partsReader{paths: paths}
+		defer parts.Close()
+		digests, err := streamToFile(tempPath, parts, nil)
+		// The parts are read from disk, not from the client, so failing
+		// to read them is not ErrBodyRead.
+		if parts.err != nil {
+			return fmt.Errorf("could not read parts: %w", parts.err)
+		}
+		if err != nil {
+			return err
+		}
+		digests.apply(object)
+		return nil
+	})
+}
+
+// ListMultipartUploads returns the uploads staged in a bucket.
+func ListMultipartUploads(bucketName string) ([]MultipartUpload, error) {
+	entries, err := os.ReadDir(filepath.Join(BucketPath(bucketName), multipartDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not list uploads: %w", err)
+	}
+	var uploads []MultipartUpload
+	for _, entry := range entries {
+		if !entry.IsDir() || !validUploadID(entry.Name()) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(uploadDir(bucketName, entry.Name()), multipartInfoFile))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read upload: %w", err)
+		}
+		var upload MultipartUpload
+		if err := json.Unmarshal(data, &upload); err != nil {
+			return nil, fmt.Errorf("could not parse upload %s: %w", entry.Name(), err)
+		}
+		uploads = append(uploads, upload)
+	}
+	return uploads, nil
+}
+
+// AbortMultipartUpload discards an upload and all of its parts.
+func AbortMultipartUpload(bucketName, objectKey, uploadID string) error {
+	if _, err := GetMultipartUpload(bucketName, objectKey, uploadID); err != nil {
+		return err
+	}
+	defer Locks.LockUpload(uploadID)()
+	if err := os.RemoveAll(uploadDir(bucketName, uploadID)); err != nil {
+		return fmt.Errorf("could not remove upload directory: %w", err)
+	}
+	return nil
+}
+
+// isMultipartETag reports whether etag was computed from parts rather than
+// being the MD5 of the content.
+func isMultipartETag(etag string) bool {
+	return strings.Contains(etag, "-")
+}
+
+// partsReader reads the part files one after the other, keeping only one
+// of them open at a time. err is the first error other than io.EOF.
+type partsReader struct {
+	paths []string
+	file  *os.File
+	err   error
+}
+
+func (p *partsReader) Read(b []byte) (int, error) {
+	n, err := p.read(b)
+	if err != nil && err != io.EOF && p.err == nil {
+		p.err = err
+	}
+	return n, err
+}
+
+// Close closes the part being read, if reading stopped before its end.
+func (p *partsReader) Close() error {
+	if p.file == nil {
+		return nil
+	}
+	err := p.file.Close()
+	p.file = nil
+	return err
+}
+
+func (p *partsReader) read(b []byte) (int, error) {
+	for {
+		if p.file == nil {
+			if len(p.paths) == 0 {
+				return 0, io.EOF
+			}
+			file, err := os.Open(p.paths[0])
+			if err != nil {
+				return 0, err
+			}
+			p.file, p.paths = file, p.paths[1:]
+		}
+		n, err := p.file.Read(b)
+		if err == io.EOF {
+			p.file.Close()
+			p.file = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
@@ -1,116 +1,475 @@
 package storage
 
 import (
-	"encoding/csv"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
-	"triple-s/flags"
 	"triple-s/models"
+	"triple-s/utils"
 )
 
-func UpdateObjectMetadata(bucketName string, metadata models.ObjectCSV) error {
-	csvPath := filepath.Join(flags.StorageDir, bucketName, "objects.csv")
-	file, err := os.OpenFile(csvPath, os.O_RDWR|os.O_CREATE, 0o644)
-	if err != nil {
-		return fmt.Errorf("could not open CSV file: %w", err)
+const (
+	// uploadTempPrefix marks object data that has been written but not yet
+	// renamed into place.
+	uploadTempPrefix = ".upload-"
+
+	maxFileNameLength = 255
+	hashedNamePrefix  = "%H"
+
+	// objectsFile holds the object metadata of a bucket in the CSV store.
+	objectsFile = "objects.csv"
+)
+
+func uploadTempName(journalID string) string {
+	return uploadTempPrefix + journalID
+}
+
+func ObjectPath(bucketName, objectKey string) string {
+	return filepath.Join(BucketPath(bucketName), objectFileName(objectKey))
+}
+
+// objectFileName maps a key onto a flat layout inside the bucket directory.
+// "/" is stored as %2F and a leading "." as %2E, so no key can escape the
+// bucket, need a parent directory or collide with the dot-prefixed internal
+// files. '%' is not a valid key character, which keeps the mapping
+// reversible. Names longer than the file system allows are hashed instead.
+func objectFileName(objectKey string) string {
+	name := strings.ReplaceAll(objectKey, "/", "%2F")
+	if strings.HasPrefix(name, ".") {
+		name = "%2E" + name[1:]
 	}
-	defer file.Close()
+	if len(name) > maxFileNameLength {
+		sum := sha256.Sum256([]byte(objectKey))
+		name = hashedNamePrefix + hex.EncodeToString(sum[:])
+	}
+	return name
+}
+
+// objectKeyFromFile reverses objectFileName. It reports false for internal
+// files and for hashed names, whose key is only known from metadata.
+func objectKeyFromFile(name string) (string, bool) {
+	if name == objectsFile || name == versionsFile || strings.HasPrefix(name, ".") || strings.HasPrefix(name, hashedNamePrefix) {
+		return "", false
+	}
+	if strings.HasPrefix(name, "%2E") {
+		name = "." + name[len("%2E"):]
+	}
+	return strings.ReplaceAll(name, "%2F", "/"), true
+}
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+// CheckObjectKey returns ErrInvalidKey unless objectKey is a valid key
+// that can be stored. The metadata files of the CSV store share the bucket
+// directory with the objects, so their names are not available as keys.
+func CheckObjectKey(objectKey string) error {
+	if !utils.IsValidObjectKey(objectKey) || objectKey == objectsFile || objectKey == versionsFile {
+		return ErrInvalidKey
+	}
+	return nil
+}
+
+var (
+	// ErrInvalidKey is returned for keys that CheckObjectKey refuses.
+	ErrInvalidKey = errors.New("invalid object key")
+	// ErrBodyRead wraps failures to read the upload from the client, as
+	// opposed to failures to store it.
+	ErrBodyRead = errors.New("could not read object data")
+	// ErrPreconditionFailed is returned when a Precondition rejects a write.
+	ErrPreconditionFailed = errors.New("precondition failed")
+)
+
+// Precondition inspects the object that is about to be replaced, nil when
+// the key does not exist yet, and returns an error to refuse the write.
+type Precondition func(current *models.ObjectCSV) error
+
+// StoreObject streams body into objectKey and records its metadata. The
+// data goes to a temp file in the bucket directory, is fsynced and renamed
+// into place, so memory use does not depend on the object size. The intent
+// is journaled first, so a crash at any point is rolled forward or back on
+// the next start instead of leaving an orphan file or a dangling metadata
+// row. check, when not nil, runs before the upload is read and again under
+// the key's lock right before the rename, which makes conditional writes
+// safe against concurrent PUTs. A preset object.ETag or object.Checksums
+// entry is the digest the client expects; if the content does not match,
+// ErrBadDigest is returned and nothing is kept. In a versioned bucket the
+// replaced version is kept as a noncurrent version. The returned object
+// carries the final size, digests and version ID.
+func StoreObject(bucketName string, object models.ObjectCSV, body io.Reader, check Precondition) (models.ObjectCSV, error) {
+	return writeObject(bucketName, object, "", check, func(tempPath string, object *models.ObjectCSV) error {
+		digests, err := streamToFile(tempPath, body, object.Checksums)
+		if err != nil {
+			return err
+		}
+		if err := digests.verify(*object); err != nil {
+			return err
+		}
+		digests.apply(object)
+		return nil
+	})
+}
+
+// writeObject does the work of StoreObject. fill creates the data file at
+// tempPath and sets the object's size and digests; an error from it
+// discards the upload. uploadID, when not empty, is the multipart upload
+// the data was assembled from; its staging directory goes with the commit.
+func writeObject(bucketName string, object models.ObjectCSV, uploadID string, check Precondition, fill func(tempPath string, object *models.ObjectCSV) error) (models.ObjectCSV, error) {
+	if err := CheckObjectKey(object.ObjectKey); err != nil {
+		return object, err
+	}
+	if err := checkPrecondition(bucketName, object.ObjectKey, check); err != nil {
+		return object, err
+	}
+	versionID, err := newVersionID(bucketName)
 	if err != nil {
-		return fmt.Errorf("could not read CSV file: %w", err)
+		return object, err
 	}
+	object.VersionID = versionID
 
-	updated := false
-	for i, record := range records {
-		if len(record) > 0 && record[0] == metadata.ObjectKey {
-			originalCreationDate := record[1]
+	entry, err := Journal.Begin(JournalEntry{Op: OpPutObject, Bucket: bucketName, Key: object.ObjectKey, Object: &object, UploadID: uploadID})
+	if err != nil {
+		return object, err
+	}
 
-			records[i] = []string{
-				metadata.ObjectKey,
-				originalCreationDate,
-				metadata.ContentType,
-				metadata.LastModified.Format(time.RFC3339),
-			}
-			updated = true
-			break
+	// The journal must give up on the write before its temp file goes, or
+	// recovery would take the missing file for a finished rename. If even
+	// the abort fails, recovery removes the file.
+	tempPath := filepath.Join(BucketPath(bucketName), uploadTempName(entry.ID))
+	discard := func(err error) (models.ObjectCSV, error) {
+		if Journal.Abort(entry.ID) == nil {
+			os.Remove(tempPath)
 		}
+		return object, err
+	}
+	if err := fill(tempPath, &object); err != nil {
+		return discard(err)
 	}
 
-	if !updated {
-		records = append(records, []string{
-			metadata.ObjectKey,
-			fmt.Sprintf("%d", metadata.ObjectSize),
-			metadata.ContentType,
-			metadata.LastModified.Format(time.RFC3339),
-		})
+	unlock := Locks.LockObject(bucketName, object.ObjectKey)
+	defer unlock()
+	defer Locks.LockBucketWrite(bucketName)()
+
+	if err := checkBucketExists(bucketName); err != nil {
+		return discard(err)
+	}
+	if err := checkPrecondition(bucketName, object.ObjectKey, check); err != nil {
+		return discard(err)
+	}
+	if existing, ok, err := Metadata.GetObject(bucketName, object.ObjectKey); err == nil && ok && !existing.CreationDate.IsZero() {
+		object.CreationDate = existing.CreationDate
+	}
+	if err := Journal.Stage(entry.ID, object); err != nil {
+		return discard(err)
+	}
+	if err := archiveCurrent(bucketName, object.ObjectKey, object.VersionID); err != nil {
+		return discard(err)
+	}
+	if err := os.Rename(tempPath, ObjectPath(bucketName, object.ObjectKey)); err != nil {
+		return discard(fmt.Errorf("could not move object into place: %w", err))
 	}
+	// Renaming onto another link of the same file does nothing and leaves
+	// the temp name behind; that happens when an object is copied onto
+	// itself.
+	os.Remove(tempPath)
+	if err := syncDir(BucketPath(bucketName)); err != nil {
+		return object, err
+	}
+
+	if err := Metadata.PutObject(bucketName, object); err != nil {
+		return object, fmt.Errorf("could not write object metadata: %w", err)
+	}
+	if err := RefreshBucketStatus(bucketName); err != nil {
+		return object, fmt.Errorf("could not update bucket metadata: %w", err)
+	}
+	if uploadID != "" {
+		if err := os.RemoveAll(uploadDir(bucketName, uploadID)); err != nil {
+			return object, fmt.Errorf("could not remove upload directory: %w", err)
+		}
+	}
+	return object, Journal.Commit(entry.ID)
+}
 
-	if err := file.Truncate(0); err != nil {
-		return fmt.Errorf("could not truncate file: %w", err)
+func checkPrecondition(bucketName, objectKey string, check Precondition) error {
+	if check == nil {
+		return nil
 	}
+	current, ok, err := Metadata.GetObject(bucketName, objectKey)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return check(nil)
+	}
+	return check(&current)
+}
 
-	if _, err := file.Seek(0, 0); err != nil {
-		return fmt.Errorf("could not seek to beginning of file: %w", err)
+// RemoveObject deletes objectKey. In a bucket with versioning enabled or
+// suspended the current version is kept as a noncurrent version and a
+// delete marker takes its place; the marker is returned. Otherwise the
+// object is gone for good and the zero ObjectCSV is returned. check, when
+// not nil, runs under the key's lock before anything is removed.
+func RemoveObject(bucketName, objectKey string, check Precondition) (models.ObjectCSV, error) {
+	if err := CheckObjectKey(objectKey); err != nil {
+		return models.ObjectCSV{}, err
+	}
+	versionID, err := newVersionID(bucketName)
+	if err != nil {
+		return models.ObjectCSV{}, err
 	}
 
-	writer := csv.NewWriter(file)
-	if err := writer.WriteAll(records); err != nil {
-		return fmt.Errorf("could not write CSV file: %w", err)
+	unlock := Locks.LockObject(bucketName, objectKey)
+	defer unlock()
+	defer Locks.LockBucketWrite(bucketName)()
+
+	if err := checkBucketExists(bucketName); err != nil {
+		return models.ObjectCSV{}, err
+	}
+	if err := checkPrecondition(bucketName, objectKey, check); err != nil {
+		return models.ObjectCSV{}, err
 	}
-	writer.Flush()
 
-	return nil
+	if versionID == "" {
+		return models.ObjectCSV{}, removeCurrent(bucketName, objectKey, nil)
+	}
+	if err := archiveCurrent(bucketName, objectKey, versionID); err != nil {
+		return models.ObjectCSV{}, err
+	}
+	now := time.Now()
+	marker := models.ObjectCSV{ObjectKey: objectKey, VersionID: versionID, DeleteMarker: true, CreationDate: now, LastModified: now}
+	return marker, removeCurrent(bucketName, objectKey, &marker)
 }
 
-func RemoveObjectMetadata(bucketName, objectKey string) error {
-	csvPath := filepath.Join(flags.StorageDir, bucketName, "objects.csv")
-	file, err := os.Open(csvPath)
+// RemoveObjects deletes every key in keys as RemoveObject does, but as one
+// journaled step with a single metadata write for the whole batch. Keys
+// that do not exist, or cannot, are skipped. The returned map holds the
+// delete marker created for each key in a versioned bucket.
+func RemoveObjects(bucketName string, keys []string) (map[string]models.ObjectCSV, error) {
+	versionID, err := newVersionID(bucketName)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer file.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	unique := make([]string, 0, len(keys))
+	seen := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if !seen[key] && CheckObjectKey(key) == nil {
+			seen[key] = true
+			unique = append(unique, key)
+		}
+	}
+	// Taking the key locks in sorted order keeps two batches from
+	// deadlocking on each other.
+	sort.Strings(unique)
+	for _, key := range unique {
+		defer Locks.LockObject(bucketName, key)()
+	}
+	defer Locks.LockBucketWrite(bucketName)()
+
+	if err := checkBucketExists(bucketName); err != nil {
+		return nil, err
+	}
+	objects, err := Metadata.ListObjects(bucketName)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	current := make(map[string]models.ObjectCSV, len(objects))
+	for _, object := range objects {
+		current[object.ObjectKey] = object
+	}
+
+	markers := make(map[string]models.ObjectCSV)
+	var removed []string
+	var versions []models.ObjectCSV
+	now := time.Now()
+	for _, key := range unique {
+		object, ok := current[key]
+		if !ok {
+			continue
+		}
+		removed = append(removed, key)
+		if versionID == "" {
+			continue
+		}
+
+		markerID := versionID
+		if markerID != NullVersionID {
+			if markerID, err = randomVersionID(); err != nil {
+				return nil, err
+			}
+		}
+		object.VersionID = VersionID(object)
+		if object.VersionID != markerID {
+			versions = append(versions, object)
+		}
+		marker := models.ObjectCSV{ObjectKey: key, VersionID: markerID, DeleteMarker: true, CreationDate: now, LastModified: now}
+		versions = append(versions, marker)
+		markers[key] = marker
+	}
+	if len(removed) == 0 {
+		return markers, nil
 	}
 
-	file, err = os.OpenFile(csvPath, os.O_WRONLY|os.O_TRUNC, 0o644)
+	entry, err := Journal.Begin(JournalEntry{Op: OpDeleteObjects, Bucket: bucketName, Keys: removed, Versions: versions})
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer file.Close()
+	if err := deleteObjectBatch(bucketName, removed, versions); err != nil {
+		return nil, err
+	}
+	return markers, Journal.Commit(entry.ID)
+}
 
-	writer := csv.NewWriter(file)
-	for _, record := range records {
-		if record[0] != objectKey {
-			writer.Write(record)
+// deleteObjectBatch does the journaled part of RemoveObjects. Every step
+// can be repeated, so recovery runs it again as it is. The replaced
+// versions are linked first; a link that exists was made by an earlier
+// attempt, before the object file went.
+func deleteObjectBatch(bucketName string, keys []string, versions []models.ObjectCSV) error {
+	linked := false
+	for _, version := range versions {
+		if version.DeleteMarker {
+			continue
+		}
+		if _, err := os.Stat(versionPath(bucketName, version.ObjectKey, version.VersionID)); err == nil {
+			continue
+		}
+		if err := linkVersion(bucketName, version); err != nil {
+			return err
+		}
+		linked = true
+	}
+	if linked {
+		if err := syncDir(filepath.Join(BucketPath(bucketName), versionsDir)); err != nil {
+			return err
 		}
 	}
-	writer.Flush()
-	deleteCSV(csvPath)
-	return writer.Error()
+	for _, key := range keys {
+		if err := os.Remove(ObjectPath(bucketName, key)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not delete object: %w", err)
+		}
+	}
+	if err := Metadata.DeleteObjects(bucketName, keys, versions); err != nil {
+		return fmt.Errorf("could not remove object metadata: %w", err)
+	}
+	// In a suspended bucket the null delete marker took the place of the
+	// key's previous null version, whose data is no longer referenced.
+	for _, version := range versions {
+		if !version.DeleteMarker || version.VersionID != NullVersionID {
+			continue
+		}
+		if err := os.Remove(versionPath(bucketName, version.ObjectKey, NullVersionID)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("could not delete version: %w", err)
+		}
+	}
+	if err := RefreshBucketStatus(bucketName); err != nil {
+		return fmt.Errorf("could not update bucket metadata: %w", err)
+	}
+	return nil
 }
 
-func deleteCSV(csvPath string) error {
-	isEmpty, err := IsBucketEmptyIgnoringObjectsCSV(csvPath)
+// removeCurrent deletes the object file and its metadata, and records
+// marker if it is not nil, as one journaled step. A file that is already
+// gone is not an error. The caller holds the key's lock.
+func removeCurrent(bucketName, objectKey string, marker *models.ObjectCSV) error {
+	entry, err := Journal.Begin(JournalEntry{Op: OpDeleteObject, Bucket: bucketName, Key: objectKey, Object: marker})
 	if err != nil {
-		return fmt.Errorf("error checking bucket: %v", err)
+		return err
+	}
+
+	if err := os.Remove(ObjectPath(bucketName, objectKey)); err != nil && !os.IsNotExist(err) {
+		Journal.Abort(entry.ID)
+		return fmt.Errorf("could not delete object: %w", err)
+	}
+	if err := Metadata.DeleteObject(bucketName, objectKey); err != nil {
+		return fmt.Errorf("could not remove object metadata: %w", err)
 	}
+	if marker != nil {
+		if err := Metadata.PutVersion(bucketName, *marker); err != nil {
+			return fmt.Errorf("could not write delete marker: %w", err)
+		}
+	}
+	if err := RefreshBucketStatus(bucketName); err != nil {
+		return fmt.Errorf("could not update bucket metadata: %w", err)
+	}
+	return Journal.Commit(entry.ID)
+}
 
-	if !isEmpty {
-		return fmt.Errorf("bucket is not empty")
+// updateObject changes the metadata of the current version of objectKey,
+// or of the version versionID when it is set, under the key's lock. Delete
+// markers cannot be changed.
+func updateObject(bucketName, objectKey, versionID string, update func(*models.ObjectCSV)) error {
+	unlock := Locks.LockObject(bucketName, objectKey)
+	defer unlock()
+
+	if versionID == "" {
+		object, ok, err := Metadata.GetObject(bucketName, objectKey)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrNoSuchObject
+		}
+		update(&object)
+		return Metadata.PutObject(bucketName, object)
+	}
+
+	version, _, err := GetObjectVersion(bucketName, objectKey, versionID)
+	if err != nil {
+		return err
 	}
+	if version.DeleteMarker {
+		return ErrNoSuchVersion
+	}
+	update(&version)
+	if current, ok, err := Metadata.GetObject(bucketName, objectKey); err != nil {
+		return err
+	} else if ok && VersionID(current) == versionID {
+		return Metadata.PutObject(bucketName, version)
+	}
+	return Metadata.PutVersion(bucketName, version)
+}
 
-	err = os.RemoveAll(csvPath)
+// streamToFile copies body into a new file at path and fsyncs it. The
+// returned digester holds the size and checksums of what was written.
+func streamToFile(path string, body io.Reader, checksums map[string]string) (*digester, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
 	if err != nil {
-		return fmt.Errorf("failed to delete file: %v", err)
+		return nil, fmt.Errorf("could not create object file: %w", err)
 	}
+	defer file.Close()
 
-	return nil
+	digests := newDigester(checksums)
+	source := &trackingReader{r: body}
+	if _, err := io.Copy(io.MultiWriter(file, digests), source); err != nil {
+		if source.err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrBodyRead, source.err)
+		}
+		return nil, fmt.Errorf("could not write object data: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("could not sync object data: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return nil, fmt.Errorf("could not close object file: %w", err)
+	}
+	return digests, nil
+}
+
+// trackingReader remembers the last read error so callers can tell a
+// broken client stream apart from a failing disk.
+type trackingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (t *trackingReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if err != nil && err != io.EOF {
+		t.err = err
+	}
+	return n, err
 }
@@ -0,0 +1,743 @@
+package storage
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"triple-s/flags"
+	"triple-s/models"
+)
+
+// setupStorage opens the CSV store on an empty storage directory with one
+// bucket per name.
+func setupStorage(t *testing.T, bucketNames ...string) {
+	t.Helper()
+	previous := flags.StorageDir
+	flags.StorageDir, flags.MetadataBackend = t.TempDir(), BackendCSV
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		Metadata.Close()
+		Journal.Close()
+		flags.StorageDir = previous
+	})
+	for _, name := range bucketNames {
+		now := time.Now()
+		if err := CreateBucket(models.Bucket{Name: name, CreationDate: now, LastModified: now, ContentStatus: "inactive"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func storeString(t *testing.T, bucketName, key, content string) models.ObjectCSV {
+	t.Helper()
+	stored, err := StoreObject(bucketName, models.ObjectCSV{ObjectKey: key, ContentType: "text/plain"}, strings.NewReader(content), nil)
+	if err != nil {
+		t.Fatalf("storing %s: %v", key, err)
+	}
+	return stored
+}
+
+func readString(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestReservedKeys(t *testing.T) {
+	setupStorage(t, "bucket")
+	if err := SetBucketVersioning("bucket", VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+	storeString(t, "bucket", "k", "one")
+	source := storeString(t, "bucket", "k", "two")
+
+	metadataFiles := map[string]string{}
+	for _, name := range []string{objectsFile, versionsFile} {
+		metadataFiles[name] = readString(t, filepath.Join(BucketPath("bucket"), name))
+	}
+
+	for name := range metadataFiles {
+		if _, err := StoreObject("bucket", models.ObjectCSV{ObjectKey: name}, strings.NewReader("x"), nil); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("StoreObject(%s): got %v, want ErrInvalidKey", name, err)
+		}
+		if _, err := CopyObject("bucket", source, "bucket", models.ObjectCSV{ObjectKey: name}, nil); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("CopyObject(%s): got %v, want ErrInvalidKey", name, err)
+		}
+		if _, err := CreateMultipartUpload("bucket", models.ObjectCSV{ObjectKey: name}); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("CreateMultipartUpload(%s): got %v, want ErrInvalidKey", name, err)
+		}
+		if _, err := RemoveObject("bucket", name, nil); !errors.Is(err, ErrInvalidKey) {
+			t.Errorf("RemoveObject(%s): got %v, want ErrInvalidKey", name, err)
+		}
+		if _, err := RemoveObjects("bucket", []string{name}); err != nil {
+			t.Errorf("RemoveObjects(%s): %v", name, err)
+		}
+	}
+
+	for name, content := range metadataFiles {
+		if got := readString(t, filepath.Join(BucketPath("bucket"), name)); got != content {
+			t.Errorf("%s changed:\n%s\nwant\n%s", name, got, content)
+		}
+	}
+	if versions, err := Metadata.ListVersions("bucket"); err != nil || len(versions) != 1 {
+		t.Errorf("got versions %v (%v), want the first upload of k", versions, err)
+	}
+}
+
+func TestObjectFileName(t *testing.T) {
+	for _, key := range []string{"a", "a/b/c", ".hidden", "./x", "%", "objects.csv.bak", strings.Repeat("x", 300)} {
+		name := objectFileName(key)
+		if strings.Contains(name, "/") || len(name) > maxFileNameLength || isInternalFile(name) {
+			t.Errorf("key %q is stored as %q", key, name)
+		}
+		if back, ok := objectKeyFromFile(name); ok && back != key {
+			t.Errorf("key %q is read back as %q", key, back)
+		}
+	}
+}
+
+// crashPut runs the steps of a put of content as key up to the point a
+// crash would stop it, then reopens the journal and recovers.
+func crashPut(t *testing.T, bucketName, key, content string, createTemp, stage, rename bool) {
+	t.Helper()
+	object := models.ObjectCSV{ObjectKey: key, ETag: "new-etag", ObjectSize: int64(len(content)), ContentType: "application/x-new"}
+	entry, err := Journal.Begin(JournalEntry{Op: OpPutObject, Bucket: bucketName, Key: key, Object: &object})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tempPath := filepath.Join(BucketPath(bucketName), uploadTempName(entry.ID))
+	if createTemp {
+		if err := os.WriteFile(tempPath, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stage {
+		if err := Journal.Stage(entry.ID, object); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if rename {
+		if err := os.Rename(tempPath, ObjectPath(bucketName, key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := Journal.path
+	Journal.Close()
+	if Journal, err = OpenJournal(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := Journal.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(tempPath); !os.IsNotExist(err) {
+		t.Errorf("temp file is left behind (%v)", err)
+	}
+}
+
+func TestRecoverPut(t *testing.T) {
+	tests := []struct {
+		name                      string
+		createTemp, stage, rename bool
+		rolledForward             bool
+	}{
+		{"before the temp file", false, false, false, false},
+		{"while writing the temp file", true, false, false, false},
+		{"staged, before the rename", true, true, false, false},
+		{"after the rename", true, true, true, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			setupStorage(t, "bucket")
+			old := storeString(t, "bucket", "k", "old")
+
+			crashPut(t, "bucket", "k", "new!", test.createTemp, test.stage, test.rename)
+
+			object, ok, err := Metadata.GetObject("bucket", "k")
+			if err != nil || !ok {
+				t.Fatalf("object is gone (%v)", err)
+			}
+			content := readString(t, ObjectPath("bucket", "k"))
+			if test.rolledForward {
+				if object.ETag != "new-etag" || content != "new!" {
+					t.Errorf("got ETag %s and content %q, want the new object", object.ETag, content)
+				}
+				return
+			}
+			if object.ETag != old.ETag || object.ContentType != old.ContentType || content != "old" {
+				t.Errorf("got ETag %s, type %q and content %q, want the old object", object.ETag, object.ContentType, content)
+			}
+		})
+	}
+}
+
+func TestFailedPutKeepsOldObject(t *testing.T) {
+	setupStorage(t, "bucket")
+	old := storeString(t, "bucket", "k", "old")
+
+	_, err := StoreObject("bucket", models.ObjectCSV{ObjectKey: "k", ETag: "0123456789abcdef0123456789abcdef"}, strings.NewReader("new"), nil)
+	if !errors.Is(err, ErrBadDigest) {
+		t.Fatalf("got %v, want ErrBadDigest", err)
+	}
+	if pending := Journal.Pending(); len(pending) != 0 {
+		t.Errorf("journal still has %d pending entries", len(pending))
+	}
+	if err := Journal.Recover(); err != nil {
+		t.Fatal(err)
+	}
+	if object, _, _ := Metadata.GetObject("bucket", "k"); object.ETag != old.ETag {
+		t.Errorf("got ETag %s, want %s", object.ETag, old.ETag)
+	}
+}
+
+func TestUploadPartChecksums(t *testing.T) {
+	setupStorage(t, "bucket")
+	upload, err := CreateMultipartUpload("bucket", models.ObjectCSV{ObjectKey: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The CRC32 of "part".
+	good := models.ObjectCSV{Checksums: map[string]string{"crc32": "SQ9wxg=="}}
+	if _, err := UploadPart("bucket", "k", upload.ID, 1, strings.NewReader("other"), good); !errors.Is(err, ErrBadDigest) {
+		t.Errorf("a part that does not match x-amz-checksum-crc32: got %v, want ErrBadDigest", err)
+	}
+	part, err := UploadPart("bucket", "k", upload.ID, 1, strings.NewReader("part"), good)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if part.Checksums["crc32"] != "SQ9wxg==" {
+		t.Errorf("got checksums %v", part.Checksums)
+	}
+}
+
+func TestCompleteMultipartUploadPartReadError(t *testing.T) {
+	setupStorage(t, "bucket")
+	upload, err := CreateMultipartUpload("bucket", models.ObjectCSV{ObjectKey: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	part, err := UploadPart("bucket", "k", upload.ID, 1, strings.NewReader("part"), models.ObjectCSV{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A directory in place of the part file fails on read, like a bad disk.
+	if err := os.Remove(part.path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(part.path, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = CompleteMultipartUpload("bucket", "k", upload.ID, []CompletedPart{{Number: 1, ETag: part.ETag}}, nil)
+	if err == nil || errors.Is(err, ErrBodyRead) {
+		t.Errorf("got %v, want a server error", err)
+	}
+}
+
+func TestRecoverCompletedUpload(t *testing.T) {
+	setupStorage(t, "bucket")
+	upload, err := CreateMultipartUpload("bucket", models.ObjectCSV{ObjectKey: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := UploadPart("bucket", "k", upload.ID, 1, strings.NewReader("part"), models.ObjectCSV{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server dies right after the assembled object was renamed into
+	// place.
+	object := models.ObjectCSV{ObjectKey: "k", ETag: "etag-1", ObjectSize: 4}
+	entry, err := Journal.Begin(JournalEntry{Op: OpPutObject, Bucket: "bucket", Key: "k", Object: &object, UploadID: upload.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Journal.Stage(entry.ID, object); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ObjectPath("bucket", "k"), []byte("part"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Journal.Recover(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(uploadDir("bucket", upload.ID)); !os.IsNotExist(err) {
+		t.Errorf("upload directory is left behind (%v)", err)
+	}
+	if _, ok, err := Metadata.GetObject("bucket", "k"); err != nil || !ok {
+		t.Errorf("object is missing (%v)", err)
+	}
+}
+
+func TestRemoveBucket(t *testing.T) {
+	setupStorage(t, "objects", "versions", "uploads", "empty")
+	storeString(t, "objects", "k", "data")
+	if err := SetBucketVersioning("versions", VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+	storeString(t, "versions", "k", "data")
+	if _, err := RemoveObject("versions", "k", nil); err != nil {
+		t.Fatal(err)
+	}
+	upload, err := CreateMultipartUpload("uploads", models.ObjectCSV{ObjectKey: "k"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"objects", "versions", "uploads"} {
+		if err := RemoveBucket(name); !errors.Is(err, ErrBucketNotEmpty) {
+			t.Errorf("%s: got %v, want ErrBucketNotEmpty", name, err)
+		}
+	}
+	if _, err := GetMultipartUpload("uploads", "k", upload.ID); err != nil {
+		t.Errorf("upload is gone: %v", err)
+	}
+
+	if err := RemoveBucket("empty"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := StoreObject("empty", models.ObjectCSV{ObjectKey: "k"}, strings.NewReader("data"), nil); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("upload to a removed bucket: got %v, want ErrBucketNotFound", err)
+	}
+	if _, err := CreateMultipartUpload("empty", models.ObjectCSV{ObjectKey: "k"}); !errors.Is(err, ErrBucketNotFound) {
+		t.Errorf("multipart upload to a removed bucket: got %v, want ErrBucketNotFound", err)
+	}
+}
+
+func TestRemoveBucketWaitsForWrites(t *testing.T) {
+	setupStorage(t, "bucket")
+	unlock := Locks.LockBucketWrite("bucket")
+	removed := make(chan error)
+	go func() { removed <- RemoveBucket("bucket") }()
+
+	select {
+	case err := <-removed:
+		t.Fatalf("bucket was removed while a write held it (%v)", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// The write lands while RemoveBucket waits.
+	if err := os.WriteFile(ObjectPath("bucket", "k"), []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	if err := <-removed; !errors.Is(err, ErrBucketNotEmpty) {
+		t.Errorf("got %v, want ErrBucketNotEmpty", err)
+	}
+}
+
+func TestPartsReaderClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "part")
+	if err := os.WriteFile(path, []byte("data"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	parts := &partsReader{paths: []string{path}}
+	if _, err := parts.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	file := parts.file
+	if err := parts.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err == nil {
+		t.Error("the part was left open")
+	}
+}
+
+func TestCopyObject(t *testing.T) {
+	setupStorage(t, "source", "target")
+	source := storeString(t, "source", "k", "data")
+
+	for _, target := range []struct{ bucket, key string }{{"source", "copy"}, {"target", "k"}} {
+		copied, err := CopyObject("source", source, target.bucket, models.ObjectCSV{ObjectKey: target.key, ContentType: "text/plain"}, nil)
+		if err != nil {
+			t.Fatalf("%s/%s: %v", target.bucket, target.key, err)
+		}
+		if copied.ETag != source.ETag || copied.ObjectSize != source.ObjectSize || copied.ContentType != "text/plain" {
+			t.Errorf("%s/%s: got %+v", target.bucket, target.key, copied)
+		}
+		if got := readString(t, ObjectPath(target.bucket, target.key)); got != "data" {
+			t.Errorf("%s/%s: got %q", target.bucket, target.key, got)
+		}
+	}
+
+	// The copies are hard links to the source file; replacing the source
+	// renames a new file over it and leaves them alone.
+	storeString(t, "source", "k", "new data")
+	for _, path := range []string{ObjectPath("source", "copy"), ObjectPath("target", "k")} {
+		if got := readString(t, path); got != "data" {
+			t.Errorf("%s after replacing the source: got %q", path, got)
+		}
+	}
+	if got := readString(t, ObjectPath("source", "k")); got != "new data" {
+		t.Errorf("source: got %q", got)
+	}
+
+	// source still describes the replaced object.
+	if _, err := CopyObject("source", source, "target", models.ObjectCSV{ObjectKey: "stale"}, nil); !errors.Is(err, ErrPreconditionFailed) {
+		t.Errorf("copying a replaced source: got %v, want ErrPreconditionFailed", err)
+	}
+	if _, ok, err := Metadata.GetObject("target", "stale"); ok || err != nil {
+		t.Errorf("a failed copy left a row (%v)", err)
+	}
+	if _, err := os.Stat(ObjectPath("target", "stale")); !os.IsNotExist(err) {
+		t.Errorf("a failed copy left a file (%v)", err)
+	}
+}
+
+func TestRemoveObjects(t *testing.T) {
+	setupStorage(t, "plain", "versioned")
+	if err := SetBucketVersioning("versioned", VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+	for _, bucketName := range []string{"plain", "versioned"} {
+		storeString(t, bucketName, "a", "a")
+		storeString(t, bucketName, "b", "b")
+		storeString(t, bucketName, "kept", "kept")
+	}
+
+	markers, err := RemoveObjects("plain", []string{"a", "b", "a", "missing"})
+	if err != nil || len(markers) != 0 {
+		t.Fatalf("got markers %v (%v)", markers, err)
+	}
+	if objects, _ := Metadata.ListObjects("plain"); len(objects) != 1 || objects[0].ObjectKey != "kept" {
+		t.Errorf("got %v, want only kept", objects)
+	}
+	for _, key := range []string{"a", "b"} {
+		if _, err := os.Stat(ObjectPath("plain", key)); !os.IsNotExist(err) {
+			t.Errorf("%s is still on disk (%v)", key, err)
+		}
+	}
+
+	a, _, _ := Metadata.GetObject("versioned", "a")
+	markers, err = RemoveObjects("versioned", []string{"a", "b", "missing"})
+	if err != nil || len(markers) != 2 || markers["a"].VersionID == "" || markers["a"].VersionID == markers["b"].VersionID {
+		t.Fatalf("got markers %v (%v)", markers, err)
+	}
+	if objects, _ := Metadata.ListObjects("versioned"); len(objects) != 1 {
+		t.Errorf("got %v, want only kept", objects)
+	}
+	versions, err := Metadata.ListVersions("versioned")
+	if err != nil || len(versions) != 4 {
+		t.Fatalf("got versions %v (%v), want a and b with a marker each", versions, err)
+	}
+	if got := readString(t, versionPath("versioned", "a", a.VersionID)); got != "a" {
+		t.Errorf("the old version of a reads %q", got)
+	}
+}
+
+func TestRecoverRemoveObjects(t *testing.T) {
+	setupStorage(t, "bucket")
+	if err := SetBucketVersioning("bucket", VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+	object := storeString(t, "bucket", "k", "data")
+
+	// The server dies right after Begin, before any version is linked.
+	marker := models.ObjectCSV{ObjectKey: "k", VersionID: "marker", DeleteMarker: true, CreationDate: time.Now(), LastModified: time.Now()}
+	if _, err := Journal.Begin(JournalEntry{Op: OpDeleteObjects, Bucket: "bucket", Keys: []string{"k"}, Versions: []models.ObjectCSV{object, marker}}); err != nil {
+		t.Fatal(err)
+	}
+	path := Journal.path
+	Journal.Close()
+	var err error
+	if Journal, err = OpenJournal(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := Journal.Recover(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, _ := Metadata.GetObject("bucket", "k"); ok {
+		t.Error("k is still current")
+	}
+	if got := readString(t, versionPath("bucket", "k", object.VersionID)); got != "data" {
+		t.Errorf("the version reads %q", got)
+	}
+	// Replaying again finds the link in place.
+	if err := deleteObjectBatch("bucket", []string{"k"}, []models.ObjectCSV{object, marker}); err != nil {
+		t.Errorf("replaying twice: %v", err)
+	}
+}
+
+func TestFsck(t *testing.T) {
+	setupStorage(t, "bucket")
+	if err := SetBucketVersioning("bucket", VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+	storeString(t, "bucket", "k", "one")
+	storeString(t, "bucket", "k", "two")
+	versions, err := Metadata.ListVersions("bucket")
+	if err != nil || len(versions) != 1 {
+		t.Fatalf("got versions %v (%v)", versions, err)
+	}
+	if err := os.Remove(versionPath("bucket", "k", versions[0].VersionID)); err != nil {
+		t.Fatal(err)
+	}
+	orphan := filepath.Join(BucketPath("bucket"), versionsDir, "orphan")
+	for _, path := range []string{orphan, filepath.Join(flags.StorageDir, tempPrefix(bucketsFile)+"123")} {
+		if err := os.WriteFile(path, nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"Not_A_Bucket", "ok-bucket"} {
+		if err := os.Mkdir(filepath.Join(flags.StorageDir, name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := Fsck(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{IssueStaleTemp: 1, IssueInvalidBucket: 1, IssueUntrackedBucket: 1, IssueDanglingVersion: 1, IssueOrphanVersion: 1}
+	for kind, count := range want {
+		if report.Counts[kind] != count {
+			t.Errorf("got %d %s issues, want %d (%v)", report.Counts[kind], kind, count, report.Issues)
+		}
+	}
+	if len(report.Issues) != 5 || report.Unrepaired() != 2 {
+		t.Errorf("got issues %v, want the invalid bucket and the orphan version unrepaired", report.Issues)
+	}
+	if _, ok, _ := Metadata.GetBucket("Not_A_Bucket"); ok {
+		t.Error("a directory with an invalid name was adopted as a bucket")
+	}
+	if versions, _ := Metadata.ListVersions("bucket"); len(versions) != 0 {
+		t.Errorf("version row without data is kept: %v", versions)
+	}
+	if _, err := os.Stat(orphan); err != nil {
+		t.Errorf("orphan version file is gone (%v)", err)
+	}
+}
+
+func TestValidBucketName(t *testing.T) {
+	tests := map[string]bool{
+		"admin": true, "my-bucket.1": true, "abc": true,
+		"ab": false, strings.Repeat("a", 64): false, "_admin": false, ".triple-s": false,
+		"-abc": false, "abc-": false, "Abc": false, "a_b": false,
+	}
+	for name, want := range tests {
+		if got := ValidBucketName(name); got != want {
+			t.Errorf("ValidBucketName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestVersioning(t *testing.T) {
+	setupStorage(t, "bucket")
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	put := func(content string) models.ObjectCSV {
+		t.Helper()
+		clock = clock.Add(time.Minute)
+		stored, err := StoreObject("bucket", models.ObjectCSV{ObjectKey: "k", LastModified: clock}, strings.NewReader(content), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stored
+	}
+	listVersions := func() []string {
+		t.Helper()
+		page, err := ListObjectVersions("bucket", VersionListOptions{MaxKeys: 1000})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		for _, version := range page.Versions {
+			id := version.VersionID
+			if version.DeleteMarker {
+				id += " (marker)"
+			}
+			if version.IsLatest {
+				id += " latest"
+			}
+			ids = append(ids, id)
+		}
+		return ids
+	}
+	current := func() string {
+		t.Helper()
+		object, ok, err := Metadata.GetObject("bucket", "k")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return ""
+		}
+		return VersionID(object) + ":" + readString(t, ObjectPath("bucket", "k"))
+	}
+	expect := func(step string, want ...string) {
+		t.Helper()
+		if got := listVersions(); strings.Join(got, ", ") != strings.Join(want, ", ") {
+			t.Errorf("%s: got versions %q, want %q", step, got, want)
+		}
+	}
+
+	put("unversioned")
+	if err := SetBucketVersioning("bucket", VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+	v1 := put("one").VersionID
+	v2 := put("two").VersionID
+	expect("enabled", v2+" latest", v1, "null")
+	if got := readString(t, versionPath("bucket", "k", NullVersionID)); got != "unversioned" {
+		t.Errorf("the null version reads %q", got)
+	}
+
+	// A suspended bucket writes null versions, each replacing the last.
+	if err := SetBucketVersioning("bucket", VersioningSuspended); err != nil {
+		t.Fatal(err)
+	}
+	put("suspended")
+	expect("suspended", "null latest", v2, v1)
+	if _, err := os.Stat(versionPath("bucket", "k", NullVersionID)); !os.IsNotExist(err) {
+		t.Errorf("the first null version is still on disk (%v)", err)
+	}
+
+	marker, err := RemoveObject("bucket", "k", nil)
+	if err != nil || !marker.DeleteMarker || marker.VersionID != NullVersionID {
+		t.Fatalf("got marker %+v (%v)", marker, err)
+	}
+	expect("deleted", "null (marker) latest", v2, v1)
+	if got := current(); got != "" {
+		t.Errorf("deleted: current is %s", got)
+	}
+	if _, path, err := GetObjectVersion("bucket", "k", NullVersionID); path != "" || err != nil {
+		t.Errorf("the marker has data at %q (%v)", path, err)
+	}
+
+	// Removing the marker makes the newest version current again, and so
+	// does removing that current version.
+	if _, err := RemoveObjectVersion("bucket", "k", NullVersionID); err != nil {
+		t.Fatal(err)
+	}
+	if got := current(); got != v2+":two" {
+		t.Errorf("after removing the marker: current is %s", got)
+	}
+	expect("marker removed", v2+" latest", v1)
+	if _, err := RemoveObjectVersion("bucket", "k", v2); err != nil {
+		t.Fatal(err)
+	}
+	if got := current(); got != v1+":one" {
+		t.Errorf("after removing the latest version: current is %s", got)
+	}
+	expect("latest removed", v1+" latest")
+
+	if _, err := RemoveObjectVersion("bucket", "k", v2); !errors.Is(err, ErrNoSuchVersion) {
+		t.Errorf("removing a removed version: got %v, want ErrNoSuchVersion", err)
+	}
+	if _, err := RemoveObjectVersion("bucket", "k", v1); err != nil {
+		t.Fatal(err)
+	}
+	expect("all removed")
+	if has, err := HasVersions("bucket"); has || err != nil {
+		t.Errorf("versions are left (%v)", err)
+	}
+}
+
+func TestListObjectVersionsPages(t *testing.T) {
+	setupStorage(t, "bucket")
+	if err := SetBucketVersioning("bucket", VersioningEnabled); err != nil {
+		t.Fatal(err)
+	}
+	clock := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, key := range []string{"a", "a", "b", "c/x", "c/x"} {
+		clock = clock.Add(time.Minute)
+		if _, err := StoreObject("bucket", models.ObjectCSV{ObjectKey: key, LastModified: clock}, strings.NewReader(key), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var keys []string
+	opts := VersionListOptions{MaxKeys: 2}
+	for pages := 0; ; pages++ {
+		if pages == 5 {
+			t.Fatal("the listing does not end")
+		}
+		page, err := ListObjectVersions("bucket", opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, version := range page.Versions {
+			keys = append(keys, version.ObjectKey)
+		}
+		if !page.IsTruncated {
+			break
+		}
+		opts.KeyMarker, opts.VersionIDMarker = page.NextKeyMarker, page.NextVersionIDMarker
+	}
+	if got := strings.Join(keys, ","); got != "a,a,b,c/x,c/x" {
+		t.Errorf("got %s", got)
+	}
+
+	page, err := ListObjectVersions("bucket", VersionListOptions{Prefix: "c/", MaxKeys: 1000})
+	if err != nil || len(page.Versions) != 2 || !page.Versions[0].IsLatest || page.Versions[1].IsLatest {
+		t.Errorf("prefix c/: got %+v (%v)", page.Versions, err)
+	}
+}
+
+func TestEncodedKeys(t *testing.T) {
+	setupStorage(t, "bucket")
+	long := strings.Repeat("x", 300)
+	longDir := strings.Repeat("d/", 200) + "file"
+	tests := []struct{ key, file string }{
+		{"a/b/c", "a%2Fb%2Fc"},
+		{".hidden", "%2Ehidden"},
+		{"./x", "%2E%2Fx"},
+		{"..", "%2E."},
+		{"dir/.x", "dir%2F.x"},
+		{long, hashedNamePrefix},
+		{longDir, hashedNamePrefix},
+	}
+	for _, test := range tests {
+		storeString(t, "bucket", test.key, test.key)
+		path := ObjectPath("bucket", test.key)
+		if filepath.Dir(path) != BucketPath("bucket") || !strings.HasPrefix(filepath.Base(path), test.file) {
+			t.Errorf("%q is stored at %s, want %s", test.key, path, test.file)
+		}
+		if got := readString(t, path); got != test.key {
+			t.Errorf("%q reads back as %q", test.key, got)
+		}
+	}
+
+	page, err := ListObjectsPage("bucket", ListOptions{Delimiter: "/", MaxKeys: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, object := range page.Objects {
+		keys = append(keys, object.ObjectKey)
+	}
+	if want := []string{"..", ".hidden", long}; strings.Join(keys, ",") != strings.Join(want, ",") ||
+		strings.Join(page.CommonPrefixes, ",") != "./,a/,d/,dir/" {
+		t.Errorf("got keys %q and prefixes %q", keys, page.CommonPrefixes)
+	}
+	page, err = ListObjectsPage("bucket", ListOptions{Prefix: "dir/", MaxKeys: 1000})
+	if err != nil || len(page.Objects) != 1 || page.Objects[0].ObjectKey != "dir/.x" {
+		t.Errorf("prefix dir/: got %+v (%v)", page.Objects, err)
+	}
+	if report, err := Fsck(false); err != nil || len(report.Issues) != 0 {
+		t.Errorf("fsck found %v (%v)", report.Issues, err)
+	}
+
+	for _, test := range tests {
+		if _, err := RemoveObject("bucket", test.key, nil); err != nil {
+			t.Fatalf("removing %q: %v", test.key, err)
+		}
+	}
+	entries, err := os.ReadDir(BucketPath("bucket"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if _, ok := objectKeyFromFile(entry.Name()); ok || strings.HasPrefix(entry.Name(), hashedNamePrefix) {
+			t.Errorf("%s is left in the bucket", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"triple-s/models"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Tag limits of S3.
+const (
+	MaxObjectTags     = 10
+	MaxBucketTags     = 50
+	MaxTagKeyLength   = 128
+	MaxTagValueLength = 256
+)
+
+// ErrTooManyTags is returned by CheckTags when a tag set is over its limit.
+var ErrTooManyTags = errors.New("too many tags")
+
+// CheckTags validates a tag set the way S3 does: at most limit tags, keys
+// of 1 to 128 and values of up to 256 characters, limited to letters,
+// digits, spaces and + - = . _ : / @, and no keys in the reserved aws:
+// namespace.
+func CheckTags(tags map[string]string, limit int) error {
+	if len(tags) > limit {
+		return fmt.Errorf("%w: at most %d are allowed", ErrTooManyTags, limit)
+	}
+	for key, value := range tags {
+		switch {
+		case key == "" || utf8.RuneCountInString(key) > MaxTagKeyLength:
+			return fmt.Errorf("tag keys must be 1 to %d characters long", MaxTagKeyLength)
+		case utf8.RuneCountInString(value) > MaxTagValueLength:
+			return fmt.Errorf("tag values must be at most %d characters long", MaxTagValueLength)
+		case strings.HasPrefix(strings.ToLower(key), "aws:"):
+			return fmt.Errorf("tag key %q uses the reserved aws: prefix", key)
+		case !validTagText(key) || !validTagText(value):
+			return fmt.Errorf("tag %q=%q contains characters that are not allowed", key, value)
+		}
+	}
+	return nil
+}
+
+func validTagText(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !unicode.IsSpace(r) && !strings.ContainsRune("+-=._:/@", r) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchesTags reports whether tags holds every key of filter with the same
+// value.
+func MatchesTags(tags, filter map[string]string) bool {
+	for key, value := range filter {
+		if actual, ok := tags[key]; !ok || actual != value {
+			return false
+		}
+	}
+	return true
+}
+
+// SetObjectTags replaces the tags of the current version of objectKey, or
+// of the version versionID when it is set. The caller validates them.
+func SetObjectTags(bucketName, objectKey, versionID string, tags map[string]string) error {
+	return updateObject(bucketName, objectKey, versionID, func(object *models.ObjectCSV) {
+		object.Tags = tags
+	})
+}
+
+// SetBucketTags replaces the tags of a bucket. The caller validates them.
+func SetBucketTags(bucketName string, tags map[string]string) error {
+	return Metadata.UpdateBucket(bucketName, func(bucket *models.Bucket) error {
+		bucket.Tags = tags
+		return nil
+	})
+}
@@ -0,0 +1,363 @@
+package storage
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"triple-s/models"
+)
+
+// Versioning keeps the current version of every key where it always was:
+// the object file and its objects.csv row. Noncurrent versions and delete
+// markers are recorded with MetadataStore.PutVersion (versions.csv next to
+// objects.csv for the CSV backend) and their data lives in
+// <bucket>/.versions/, named by a hash of key and version ID.
+const (
+	VersioningEnabled   = "Enabled"
+	VersioningSuspended = "Suspended"
+	// NullVersionID is the version of objects written while versioning was
+	// off or suspended.
+	NullVersionID = "null"
+
+	versionsFile = "versions.csv"
+	versionsDir  = ".versions"
+)
+
+var ErrNoSuchVersion = errors.New("object version does not exist")
+
+// VersionID returns the version ID of object, NullVersionID for objects
+// written before versioning was enabled.
+func VersionID(object models.ObjectCSV) string {
+	if object.VersionID == "" {
+		return NullVersionID
+	}
+	return object.VersionID
+}
+
+func versionPath(bucketName, objectKey, versionID string) string {
+	sum := sha256.Sum256([]byte(objectKey + "\x00" + versionID))
+	return filepath.Join(BucketPath(bucketName), versionsDir, hex.EncodeToString(sum[:]))
+}
+
+func SetBucketVersioning(bucketName, status string) error {
+	return Metadata.UpdateBucket(bucketName, func(bucket *models.Bucket) error {
+		bucket.Versioning = status
+		bucket.LastModified = time.Now()
+		return nil
+	})
+}
+
+// newVersionID returns the version ID for a write to bucketName: a fresh
+// one when versioning is enabled, NullVersionID when it is suspended and ""
+// when it was never enabled.
+func newVersionID(bucketName string) (string, error) {
+	bucket, ok, err := Metadata.GetBucket(bucketName)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", ErrBucketNotFound
+	}
+	switch bucket.Versioning {
+	case VersioningEnabled:
+		return randomVersionID()
+	case VersioningSuspended:
+		return NullVersionID, nil
+	}
+	return "", nil
+}
+
+func randomVersionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate version id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// archiveCurrent keeps the current version of objectKey as a noncurrent
+// version before a write with versionID replaces or deletes it. The data
+// is hard-linked, so the write can still rename over or remove the object
+// file. A suspended bucket keeps one null version per key, so a write with
+// NullVersionID drops the previous one instead. The caller holds the key's
+// lock.
+func archiveCurrent(bucketName, objectKey, versionID string) error {
+	if versionID == "" {
+		return nil
+	}
+	if versionID == NullVersionID {
+		if err := removeVersion(bucketName, objectKey, NullVersionID); err != nil {
+			return err
+		}
+	}
+
+	current, ok, err := Metadata.GetObject(bucketName, objectKey)
+	if err != nil || !ok {
+		return err
+	}
+	current.VersionID = VersionID(current)
+	if current.VersionID == versionID {
+		return nil
+	}
+
+	if err := linkVersion(bucketName, current); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Join(BucketPath(bucketName), versionsDir)); err != nil {
+		return err
+	}
+	return Metadata.PutVersion(bucketName, current)
+}
+
+// linkVersion hard-links the object file of version.ObjectKey as the data
+// of version. The caller syncs the versions directory.
+func linkVersion(bucketName string, version models.ObjectCSV) error {
+	path := versionPath(bucketName, version.ObjectKey, version.VersionID)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("could not create version directory: %w", err)
+	}
+	// A link left by an interrupted earlier attempt.
+	os.Remove(path)
+	if err := os.Link(ObjectPath(bucketName, version.ObjectKey), path); err != nil {
+		return fmt.Errorf("could not keep previous version: %w", err)
+	}
+	return nil
+}
+
+func removeVersion(bucketName, objectKey, versionID string) error {
+	if err := Metadata.DeleteVersion(bucketName, objectKey, versionID); err != nil {
+		return err
+	}
+	if err := os.Remove(versionPath(bucketName, objectKey, versionID)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not delete version: %w", err)
+	}
+	return nil
+}
+
+// keyVersions returns the noncurrent versions of objectKey, newest first.
+func keyVersions(bucketName, objectKey string) ([]models.ObjectCSV, error) {
+	versions, err := Metadata.ListVersions(bucketName)
+	if err != nil {
+		return nil, err
+	}
+	var matching []models.ObjectCSV
+	for _, version := range versions {
+		if version.ObjectKey == objectKey {
+			matching = append(matching, version)
+		}
+	}
+	sort.SliceStable(matching, func(a, b int) bool { return matching[a].LastModified.After(matching[b].LastModified) })
+	return matching, nil
+}
+
+// HasVersions reports whether bucketName retains noncurrent versions or
+// delete markers.
+func HasVersions(bucketName string) (bool, error) {
+	versions, err := Metadata.ListVersions(bucketName)
+	return len(versions) > 0, err
+}
+
+// GetObjectVersion returns one version of objectKey and the path of its
+// data, which is "" for delete markers.
+func GetObjectVersion(bucketName, objectKey, versionID string) (models.ObjectCSV, string, error) {
+	current, ok, err := Metadata.GetObject(bucketName, objectKey)
+	if err != nil {
+		return current, "", err
+	}
+	if ok && VersionID(current) == versionID {
+		return current, ObjectPath(bucketName, objectKey), nil
+	}
+
+	versions, err := keyVersions(bucketName, objectKey)
+	if err != nil {
+		return models.ObjectCSV{}, "", err
+	}
+	for _, version := range versions {
+		if version.VersionID != versionID {
+			continue
+		}
+		if version.DeleteMarker {
+			return version, "", nil
+		}
+		return version, versionPath(bucketName, objectKey, versionID), nil
+	}
+	return models.ObjectCSV{}, "", ErrNoSuchVersion
+}
+
+// RemoveObjectVersion permanently deletes one version of objectKey. If that
+// leaves the key without a current version while its newest remaining
+// version holds data, that version becomes current again.
+func RemoveObjectVersion(bucketName, objectKey, versionID string) (models.ObjectCSV, error) {
+	unlock := Locks.LockObject(bucketName, objectKey)
+	defer unlock()
+
+	current, ok, err := Metadata.GetObject(bucketName, objectKey)
+	if err != nil {
+		return current, err
+	}
+	if ok && VersionID(current) == versionID {
+		if err := removeCurrent(bucketName, objectKey, nil); err != nil {
+			return current, err
+		}
+		// A stale duplicate left by an interrupted archive.
+		if err := Metadata.DeleteVersion(bucketName, objectKey, versionID); err != nil {
+			return current, err
+		}
+		return current, promoteLatest(bucketName, objectKey)
+	}
+
+	versions, err := keyVersions(bucketName, objectKey)
+	if err != nil {
+		return models.ObjectCSV{}, err
+	}
+	for _, version := range versions {
+		if version.VersionID != versionID {
+			continue
+		}
+		if err := removeVersion(bucketName, objectKey, versionID); err != nil {
+			return version, err
+		}
+		if !ok {
+			return version, promoteLatest(bucketName, objectKey)
+		}
+		return version, nil
+	}
+	return models.ObjectCSV{}, ErrNoSuchVersion
+}
+
+// promoteLatest makes the newest noncurrent version of objectKey current
+// when the key has no current version and that version is not a delete
+// marker. The caller holds the key's lock.
+func promoteLatest(bucketName, objectKey string) error {
+	if _, ok, err := Metadata.GetObject(bucketName, objectKey); err != nil || ok {
+		return err
+	}
+	versions, err := keyVersions(bucketName, objectKey)
+	if err != nil || len(versions) == 0 || versions[0].DeleteMarker {
+		return err
+	}
+	latest := versions[0]
+
+	entry, err := Journal.Begin(JournalEntry{Op: OpPutObject, Bucket: bucketName, Key: objectKey, Object: &latest})
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(versionPath(bucketName, objectKey, latest.VersionID), ObjectPath(bucketName, objectKey)); err != nil {
+		Journal.Abort(entry.ID)
+		return fmt.Errorf("could not restore version: %w", err)
+	}
+	if err := syncDir(BucketPath(bucketName)); err != nil {
+		return err
+	}
+	if err := Metadata.PutObject(bucketName, latest); err != nil {
+		return fmt.Errorf("could not write object metadata: %w", err)
+	}
+	if err := Metadata.DeleteVersion(bucketName, objectKey, latest.VersionID); err != nil {
+		return err
+	}
+	if err := RefreshBucketStatus(bucketName); err != nil {
+		return fmt.Errorf("could not update bucket metadata: %w", err)
+	}
+	return Journal.Commit(entry.ID)
+}
+
+// VersionListOptions selects one page of ListObjectVersions. KeyMarker and
+// VersionIDMarker name the last entry of the previous page.
+type VersionListOptions struct {
+	Prefix          string
+	KeyMarker       string
+	VersionIDMarker string
+	MaxKeys         int
+}
+
+type ObjectVersion struct {
+	models.ObjectCSV
+	IsLatest bool
+}
+
+type VersionPage struct {
+	Versions            []ObjectVersion
+	IsTruncated         bool
+	NextKeyMarker       string
+	NextVersionIDMarker string
+}
+
+// ListObjectVersions lists every version and delete marker in key order,
+// newest first within a key.
+func ListObjectVersions(bucketName string, opts VersionListOptions) (VersionPage, error) {
+	objects, err := Metadata.ListObjects(bucketName)
+	if err != nil {
+		return VersionPage{}, err
+	}
+	versions, err := Metadata.ListVersions(bucketName)
+	if err != nil {
+		return VersionPage{}, err
+	}
+
+	currentIDs := make(map[string]string, len(objects))
+	var all []ObjectVersion
+	for _, object := range objects {
+		object.VersionID = VersionID(object)
+		currentIDs[object.ObjectKey] = object.VersionID
+		all = append(all, ObjectVersion{ObjectCSV: object, IsLatest: true})
+	}
+	for _, version := range versions {
+		if id, ok := currentIDs[version.ObjectKey]; ok && id == version.VersionID {
+			continue
+		}
+		all = append(all, ObjectVersion{ObjectCSV: version})
+	}
+	sort.SliceStable(all, func(a, b int) bool {
+		if all[a].ObjectKey != all[b].ObjectKey {
+			return all[a].ObjectKey < all[b].ObjectKey
+		}
+		if all[a].IsLatest != all[b].IsLatest {
+			return all[a].IsLatest
+		}
+		return all[a].LastModified.After(all[b].LastModified)
+	})
+	// Without a current version, the newest entry of a key is its latest.
+	for i := range all {
+		if i == 0 || all[i].ObjectKey != all[i-1].ObjectKey {
+			all[i].IsLatest = true
+		}
+	}
+
+	var page VersionPage
+	skipping := opts.KeyMarker != ""
+	for _, version := range all {
+		if !strings.HasPrefix(version.ObjectKey, opts.Prefix) {
+			continue
+		}
+		if skipping {
+			if version.ObjectKey < opts.KeyMarker {
+				continue
+			}
+			if version.ObjectKey == opts.KeyMarker {
+				if opts.VersionIDMarker == "" || version.VersionID != opts.VersionIDMarker {
+					continue
+				}
+				skipping = false
+				continue
+			}
+			skipping = false
+		}
+		if len(page.Versions) == opts.MaxKeys {
+			page.IsTruncated = true
+			break
+		}
+		page.Versions = append(page.Versions, version)
+	}
+	if page.IsTruncated && len(page.Versions) > 0 {
+		last := page.Versions[len(page.Versions)-1]
+		page.NextKeyMarker, page.NextVersionIDMarker = last.ObjectKey, last.VersionID
+	}
+	return page, nil
+}